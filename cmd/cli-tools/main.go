@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/urfave/cli/v2"
+
+	"tbl/mongo"
+)
+
+// Build metadata, set by release builds with
+// -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)".
+var (
+	version = "1.0.0"
+	commit  = ""
+	date    = ""
+)
+
+func main() {
+	// Load environment variables from ./.env when there is one; an installed
+	// binary usually gets them from the environment alone
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	// Create a new CLI app
+	app := cli.NewApp()
+	app.Name = "cli-tools"
+	app.Usage = "A simple library of cli tools built and used by topic to make the devs life easier!"
+	app.Version = version
+	cli.VersionPrinter = printVersion
+
+	app.EnableBashCompletion = true
+	// --filter values are JSON, full of commas; list flags are repeated instead
+	app.DisableSliceFlagSeparator = true
+
+	// Every tool is a subcommand, run in this process
+	app.Flags = mongo.Flags()
+	app.Before = mongo.Before
+	app.Commands = mongo.Commands()
+
+	// Ctrl-C and SIGTERM cancel the commands' context so they can stop cleanly;
+	// a second one kills the process right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Run the CLI app
+	err := app.RunContext(ctx, os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printVersion prints the version with the commit and date it was built from,
+// read from the module's VCS stamp when the build didn't set them.
+func printVersion(c *cli.Context) {
+	rev, built, modified := commit, date, false
+	if info, ok := debug.ReadBuildInfo(); ok && commit == "" {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision":
+				rev = s.Value
+			case s.Key == "vcs.time" && built == "":
+				built = s.Value
+			case s.Key == "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	} else if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if modified {
+		rev += "-dirty"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	fmt.Fprintf(c.App.Writer, "%s %s (commit %s, built %s, %s %s/%s)\n", c.App.Name, c.App.Version, rev, built, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,65 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// sqlAssertion is a query whose single result must equal Expect, e.g.
+// {"name": "posts have authors", "query": "SELECT count(*) FROM posts WHERE author IS NULL", "expect": "0"}.
+type sqlAssertion struct {
+	Name   string `json:"name" doc:"label used when reporting the result"`
+	Query  string `json:"query" doc:"query returning a single value"`
+	Expect string `json:"expect" doc:"expected value, compared as text (NULL for SQL NULL)"`
+}
+
+var assertCommand = &cli.Command{
+	Name:  "assert",
+	Usage: "Run the SQL assertions from --config against MySQL",
+	Flags: mysqlFlags,
+	Action: func(c *cli.Context) error {
+		cfg, err := loadConfig(c.String("config"))
+		if err != nil {
+			return err
+		}
+		mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+		defer mysqlDB.Close()
+		return checkAssertions(mysqlDB, cfg.Assertions)
+	},
+}
+
+// checkAssertions runs every assertion, logging each outcome, and fails if any of them did.
+func checkAssertions(mysqlDB *sql.DB, assertions []sqlAssertion) error {
+	failed := 0
+	for _, a := range assertions {
+		got, err := queryValue(mysqlDB, a.Query)
+		switch {
+		case err != nil:
+			logf(levelError, "FAIL %s: %v", a.Name, err)
+			failed++
+		case got != a.Expect:
+			logf(levelError, "FAIL %s: got %q, expected %q", a.Name, got, a.Expect)
+			failed++
+		default:
+			logf(levelInfo, "ok   %s", a.Name)
+		}
+	}
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d assertion(s) failed", failed, len(assertions)), 1)
+	}
+	return nil
+}
+
+// queryValue returns the first column of the first row of query as text.
+func queryValue(mysqlDB *sql.DB, query string) (string, error) {
+	var value sql.NullString
+	if err := mysqlDB.QueryRow(query).Scan(&value); err != nil {
+		return "", err
+	}
+	if !value.Valid {
+		return "NULL", nil
+	}
+	return value.String, nil
+}
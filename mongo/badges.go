@@ -0,0 +1,80 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// badgeRule awards a badge to the users its query selects. The query returns
+// a user_id column and an awarded_at column, NULL when the date is unknown.
+type badgeRule struct {
+	Badge string `json:"badge" doc:"badge name stored in user_badge, e.g. early-adopter"`
+	Query string `json:"query" doc:"query returning the user_id and awarded_at of every holder"`
+}
+
+var badgeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func (r badgeRule) validate() error {
+	if !badgeNamePattern.MatchString(r.Badge) {
+		return fmt.Errorf("badge %q: invalid name", r.Badge)
+	}
+	if strings.TrimSpace(r.Query) == "" {
+		return fmt.Errorf("badge %s: no query", r.Badge)
+	}
+	return nil
+}
+
+// defaultBadgeRules are backfilled when the config lists no badges. Followers
+// aren't migrated, so follower-count badges need a rule in the config once
+// they are.
+var defaultBadgeRules = []badgeRule{
+	{
+		Badge: "early-adopter",
+		Query: "SELECT u.id AS user_id, u.created_at AS awarded_at FROM users u JOIN (SELECT MIN(created_at) AS first FROM users) f ON u.created_at < f.first + INTERVAL 90 DAY",
+	},
+	{
+		Badge: "verified-developer",
+		Query: "SELECT id AS user_id, NULL AS awarded_at FROM users WHERE is_verified AND is_developer",
+	},
+}
+
+var backfillBadgesCommand = &cli.Command{
+	Name:  "backfill-badges",
+	Usage: "Award the badges of the config's rules to the migrated users, in the user_badge table",
+	Flags: mysqlFlags,
+	Action: func(c *cli.Context) error {
+		cfg, err := loadConfig(c.String("config"))
+		if err != nil {
+			return err
+		}
+		mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+		defer mysqlDB.Close()
+		if err := schemaUp(mysqlDB, 0, nil); err != nil {
+			return err
+		}
+		return backfillBadges(mysqlDB, cfg.badges())
+	},
+}
+
+// backfillBadges awards every rule's badge to the users it selects. Badges a
+// user already holds are kept as they are, so the backfill can be rerun after
+// every migration without moving award dates.
+func backfillBadges(mysqlDB *sql.DB, rules []badgeRule) error {
+	for _, rule := range rules {
+		res, err := mysqlDB.Exec("INSERT IGNORE INTO user_badge (user_id, badge, awarded_at) SELECT b.user_id, ?, b.awarded_at FROM ("+rule.Query+") AS b", rule.Badge)
+		if err != nil {
+			return fmt.Errorf("error backfilling badge %s: %v", rule.Badge, err)
+		}
+		awarded, _ := res.RowsAffected()
+		var holders int
+		if err := mysqlDB.QueryRow("SELECT count(*) FROM user_badge WHERE badge = ?", rule.Badge).Scan(&holders); err != nil {
+			return fmt.Errorf("error counting badge %s: %v", rule.Badge, err)
+		}
+		logf(levelInfo, "%s: %d awarded, %d holder(s)", rule.Badge, awarded, holders)
+	}
+	return nil
+}
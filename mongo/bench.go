@@ -0,0 +1,318 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// benchTie is how close to the fastest setting another has to be for bench
+// to recommend it when it is the lighter one.
+const benchTie = 0.95
+
+var benchCommand = &cli.Command{
+	Name:  "bench",
+	Usage: "Measure read throughput from MongoDB and write throughput to MySQL at several settings, and recommend the fastest",
+	Flags: withFlags(mongoFlags, mysqlFlags, []cli.Flag{
+		&cli.StringFlag{Name: "collection", Value: "posts", Usage: "collection the reads are timed on"},
+		&cli.IntFlag{Name: "documents", Value: 10000, Usage: "documents read at each batch size"},
+		&cli.StringFlag{Name: "batch-sizes", Value: "100,1000,5000", Usage: "comma separated --batch-size values the reads are timed at"},
+		&cli.IntFlag{Name: "rows", Value: 10000, Usage: "synthetic rows written at each setting"},
+		&cli.StringFlag{Name: "rows-per-tx", Value: "1,100,1000", Usage: "comma separated numbers of rows committed per transaction the writes are timed at"},
+		&cli.StringFlag{Name: "concurrency", Value: "1,2,4,8", Usage: "comma separated numbers of connections writing at once"},
+		&cli.BoolFlag{Name: "skip-reads", Usage: "only time the writes, without MongoDB"},
+		&cli.BoolFlag{Name: "skip-writes", Usage: "only time the reads, without MySQL"},
+	}),
+	Action: bench,
+}
+
+// benchResult is the throughput measured at one setting.
+type benchResult struct {
+	setting []int
+	n       int
+	bytes   int64
+	took    time.Duration
+}
+
+func (r benchResult) rate() float64 {
+	return float64(r.n) / r.took.Seconds()
+}
+
+func bench(c *cli.Context) error {
+	if c.Bool("skip-reads") && c.Bool("skip-writes") {
+		return fmt.Errorf("--skip-reads and --skip-writes leave nothing to measure")
+	}
+	var recommended []string
+	if !c.Bool("skip-reads") {
+		sizes, err := parseIntList("batch-sizes", c.String("batch-sizes"))
+		if err != nil {
+			return err
+		}
+		if c.Int("documents") < 1 {
+			return fmt.Errorf("--documents must be at least 1")
+		}
+		results, err := benchReads(c.Context, c.String("mongodb-uri"), c.String("collection"), c.Int("documents"), sizes)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reads from %s, %d document(s) at each batch size:\n", c.String("collection"), c.Int("documents"))
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  batch size\tdocuments/s\tMiB/s\t")
+		for _, r := range results {
+			fmt.Fprintf(w, "  %d\t%.0f\t%.1f\t\n", r.setting[0], r.rate(), float64(r.bytes)/(1<<20)/r.took.Seconds())
+		}
+		w.Flush()
+		best := fastest(results)
+		recommended = append(recommended, fmt.Sprintf("--batch-size %d (%.0f documents/s)", best.setting[0], best.rate()))
+	}
+	if !c.Bool("skip-writes") {
+		perTx, err := parseIntList("rows-per-tx", c.String("rows-per-tx"))
+		if err != nil {
+			return err
+		}
+		concurrency, err := parseIntList("concurrency", c.String("concurrency"))
+		if err != nil {
+			return err
+		}
+		if c.Int("rows") < 1 {
+			return fmt.Errorf("--rows must be at least 1")
+		}
+		mysqlDB, err := openMySQL(c.String("mysql-uri"), nil)
+		if err != nil {
+			return err
+		}
+		defer mysqlDB.Close()
+		results, err := benchWrites(c.Context, mysqlDB, c.Int("rows"), perTx, concurrency)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Writes of %d synthetic row(s) at each setting:\n", c.Int("rows"))
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  rows/tx\tconnections\trows/s\t")
+		for _, r := range results {
+			fmt.Fprintf(w, "  %d\t%d\t%.0f\t\n", r.setting[0], r.setting[1], r.rate())
+		}
+		w.Flush()
+		best := fastest(results)
+		// migrate commits each document on its own or the documents
+		// between two checkpoints together
+		txPer := "row"
+		if best.setting[0] > 1 {
+			txPer = "batch"
+		}
+		recommended = append(recommended, fmt.Sprintf("--tx-per %s (%.0f rows/s at %d rows per transaction)", txPer, best.rate(), best.setting[0]))
+		if n := best.setting[1]; n > 1 {
+			// One migrate writes over a single connection, so more writers
+			// means more runs, each with its own --collections
+			recommended = append(recommended, fmt.Sprintf("%d migrate runs at once, splitting the collections between them with --collections, and --mysql-max-open-conns of at least 2 each", n))
+		}
+	}
+	fmt.Println("Recommended:")
+	for _, r := range recommended {
+		fmt.Printf("  %s\n", r)
+	}
+	return nil
+}
+
+// parseIntList parses the comma separated positive numbers of a flag, in
+// ascending order.
+func parseIntList(flag, list string) ([]int, error) {
+	var values []int
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid --%s %q, expected comma separated numbers of at least 1", flag, list)
+		}
+		values = append(values, n)
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+// fastest returns the result with the highest rate, or the lightest setting
+// within benchTie of it; results are ordered from the lightest setting up.
+func fastest(results []benchResult) benchResult {
+	best := results[0]
+	for _, r := range results {
+		if r.rate() > best.rate() {
+			best = r
+		}
+	}
+	for _, r := range results {
+		if r.rate() >= best.rate()*benchTie {
+			return r
+		}
+	}
+	return best
+}
+
+// benchReads times reading documents from a collection at each batch size,
+// after one untimed pass that warms the server's cache.
+func benchReads(ctx context.Context, uri, collection string, documents int, sizes []int) ([]benchResult, error) {
+	source, err := newMongoSource(ctx, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close(context.TODO())
+	if _, err := benchRead(ctx, source, collection, documents); err != nil {
+		return nil, err
+	}
+	var results []benchResult
+	for _, size := range sizes {
+		source.batchSize = int32(size)
+		r, err := benchRead(ctx, source, collection, documents)
+		if err != nil {
+			return nil, err
+		}
+		if r.n < documents {
+			logf(levelWarn, "%s holds only %d document(s)", collection, r.n)
+		}
+		r.setting = []int{size}
+		results = append(results, r)
+		logf(levelDebug, "Read %d %s document(s) in batches of %d in %s", r.n, collection, size, r.took)
+	}
+	return results, nil
+}
+
+// benchRead times reading up to documents documents of a collection.
+func benchRead(ctx context.Context, source *mongoSource, collection string, documents int) (benchResult, error) {
+	started := time.Now()
+	cursor, err := source.Open(ctx, collection, readOptions{})
+	if err != nil {
+		return benchResult{}, err
+	}
+	defer cursor.Close(context.TODO())
+	// The BSON as it came over the wire, without decoding it
+	raw := cursor.(mongoCursor)
+	var r benchResult
+	for r.n < documents && raw.Next(ctx) {
+		r.n++
+		r.bytes += int64(len(raw.Current))
+	}
+	if err := raw.Err(); err != nil {
+		return benchResult{}, fmt.Errorf("error reading %s: %v", collection, err)
+	}
+	if r.n == 0 {
+		return benchResult{}, fmt.Errorf("%s has no documents to read", collection)
+	}
+	r.took = time.Since(started)
+	return r, nil
+}
+
+// benchWrites times inserting rows into a scratch table, one row per
+// statement as migrate does, at each number of rows per transaction and of
+// connections. The table is emptied between two settings and dropped at the end.
+func benchWrites(ctx context.Context, mysqlDB *sql.DB, rows int, perTx, concurrency []int) ([]benchResult, error) {
+	table := fmt.Sprintf("bench_%d", time.Now().Unix())
+	_, err := mysqlDB.Exec("CREATE TABLE " + table + ` (
+    id VARCHAR(64) NOT NULL PRIMARY KEY,
+    author VARCHAR(64) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the scratch table %s: %v", table, err)
+	}
+	defer func() {
+		if _, err := mysqlDB.Exec("DROP TABLE " + table); err != nil {
+			logf(levelError, "error dropping the scratch table %s: %v", table, err)
+		}
+	}()
+	mysqlDB.SetMaxIdleConns(concurrency[len(concurrency)-1])
+
+	var results []benchResult
+	for _, n := range perTx {
+		for _, conns := range concurrency {
+			if mysqlPool.maxOpen > 0 && conns > mysqlPool.maxOpen {
+				logf(levelWarn, "Skipping %d connections, above --mysql-max-open-conns %d", conns, mysqlPool.maxOpen)
+				continue
+			}
+			if _, err := mysqlDB.Exec("TRUNCATE TABLE " + table); err != nil {
+				return nil, fmt.Errorf("error emptying the scratch table %s: %v", table, err)
+			}
+			took, err := benchWrite(ctx, mysqlDB, table, rows, n, conns)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, benchResult{setting: []int{n, conns}, n: rows, took: took})
+			logf(levelDebug, "Wrote %d row(s), %d per transaction over %d connection(s), in %s", rows, n, conns, took)
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no --concurrency fits --mysql-max-open-conns %d", mysqlPool.maxOpen)
+	}
+	return results, nil
+}
+
+// benchWrite inserts rows synthetic rows into table from conns connections at
+// once, committing every perTx of them.
+func benchWrite(ctx context.Context, mysqlDB *sql.DB, table string, rows, perTx, conns int) (time.Duration, error) {
+	insert := "INSERT INTO " + table + " (id, author, title, content, created_at) VALUES (?, ?, ?, ?, ?)"
+	started := time.Now()
+	errs := make(chan error, conns)
+	var wg sync.WaitGroup
+	for w := 0; w < conns; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(w)))
+			// Worker w writes every conns-th row
+			for first := w; first < rows; first += perTx * conns {
+				tx, err := mysqlDB.BeginTx(ctx, nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for i := first; i < rows && i < first+perTx*conns; i += conns {
+					if _, err := tx.Exec(insert, benchRow(random, i)...); err != nil {
+						tx.Rollback()
+						errs <- err
+						return
+					}
+				}
+				if err := tx.Commit(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return 0, fmt.Errorf("error writing to the scratch table %s: %v", table, err)
+	}
+	return time.Since(started), nil
+}
+
+// benchWords make up the synthetic titles and contents.
+var benchWords = strings.Fields("the a post about flux social coterie update release today new community thoughts on building shipping weekend photo music code friends")
+
+// benchRow is a synthetic post row, sized like a real one.
+func benchRow(random *rand.Rand, i int) []interface{} {
+	words := func(n int) string {
+		parts := make([]string, n)
+		for j := range parts {
+			parts[j] = benchWords[random.Intn(len(benchWords))]
+		}
+		return strings.Join(parts, " ")
+	}
+	return []interface{}{
+		fmt.Sprintf("%024x", i),
+		fmt.Sprintf("%024x", random.Int63()),
+		words(8),
+		words(80),
+		time.Now().Add(-time.Duration(random.Int63n(int64(365 * 24 * time.Hour)))).UTC().Format("2006-01-02 15:04:05"),
+	}
+}
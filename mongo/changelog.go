@@ -0,0 +1,86 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// schemaChangelog records every schema decision one run makes, including what
+// it found already in place, so whoever reviews the target can tell what the
+// tool did from what existed before. A nil *schemaChangelog records nothing.
+type schemaChangelog struct {
+	path    string
+	f       *os.File
+	changes int
+}
+
+func newSchemaChangelog(path string) (*schemaChangelog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema changelog: %v", err)
+	}
+	fmt.Fprintf(f, "# Schema changes of the run started %s\n", time.Now().UTC().Format(time.RFC3339))
+	return &schemaChangelog{path: path, f: f}, nil
+}
+
+// changed records something the run did to the schema.
+func (l *schemaChangelog) changed(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.changes++
+	l.write("changed", format, args...)
+}
+
+// kept records something the run found already in place and left alone.
+func (l *schemaChangelog) kept(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.write("kept", format, args...)
+}
+
+// skipped records a change the run decided not to make.
+func (l *schemaChangelog) skipped(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.write("skipped", format, args...)
+}
+
+func (l *schemaChangelog) write(kind, format string, args ...interface{}) {
+	line := fmt.Sprintf("%s %-7s %s\n", time.Now().UTC().Format(time.RFC3339), kind, fmt.Sprintf(format, args...))
+	if _, err := l.f.WriteString(line); err != nil {
+		logf(levelWarn, "Error writing %s: %v", l.path, err)
+	}
+}
+
+// summary logs where the changelog went.
+func (l *schemaChangelog) summary() {
+	if l == nil {
+		return
+	}
+	logf(levelInfo, "%d schema change(s), see %s", l.changes, l.path)
+}
+
+func (l *schemaChangelog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// tableExists reports whether table is in the current database.
+func tableExists(mysqlDB *sql.DB, table string) (bool, error) {
+	var tables int
+	err := mysqlDB.QueryRow(
+		"SELECT count(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?",
+		table,
+	).Scan(&tables)
+	if err != nil {
+		return false, fmt.Errorf("error looking up table %s: %v", table, err)
+	}
+	return tables > 0, nil
+}
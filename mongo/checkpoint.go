@@ -0,0 +1,88 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointEvery is how many documents are processed between two writes of a
+// collection's checkpoint.
+const checkpointEvery = 1000
+
+// checkpoint records how far into a collection a run got: Position documents,
+// in the order the collection is read in, have been migrated, skipped or
+// quarantined. Reading resumes with the document after them.
+type checkpoint struct {
+	Collection string    `json:"collection"`
+	Position   int       `json:"position"`
+	Done       bool      `json:"done"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// readCheckpoints reads the checkpoints a run left in dir, by collection.
+func readCheckpoints(dir string) (map[string]checkpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no checkpoints in %s", dir)
+	}
+	checkpoints := map[string]checkpoint{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading checkpoint: %v", err)
+		}
+		var cp checkpoint
+		if err := json.Unmarshal(data, &cp); err != nil {
+			return nil, fmt.Errorf("error parsing checkpoint %s: %v", path, err)
+		}
+		checkpoints[cp.Collection] = cp
+	}
+	return checkpoints, nil
+}
+
+func checkpointPath(dir, collection string) string {
+	return filepath.Join(dir, collection+".json")
+}
+
+// writeCheckpoint replaces the collection's checkpoint file in dir. The new
+// file is renamed into place, so a crash never leaves a torn checkpoint.
+func writeCheckpoint(dir string, cp checkpoint) error {
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	path := checkpointPath(dir, cp.Collection)
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	return nil
+}
+
+// logResumeState tells the operator of a stopped run where each collection
+// stands, which is where migrate --resume picks them up.
+func logResumeState(run *migrator, selected []collectionMigration, stopped string) {
+	reached := true
+	for _, cm := range selected {
+		switch {
+		case cm.Name == stopped:
+			logf(levelInfo, "%s: stopped after document %d", cm.Name, run.position(cm.Name))
+			reached = false
+		case !reached:
+			logf(levelInfo, "%s: not started", cm.Name)
+		case run.aborted[cm.Name] != nil:
+			logf(levelInfo, "%s: aborted after document %d", cm.Name, run.position(cm.Name))
+		default:
+			logf(levelInfo, "%s: done", cm.Name)
+		}
+	}
+}
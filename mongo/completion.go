@@ -0,0 +1,147 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// completionScripts ask the binary itself what can follow the words typed so
+// far, using urfave/cli's --generate-bash-completion protocol, so they cover
+// every command and flag without being regenerated. %[1]s is the program name,
+// %[2]s the same usable in a shell function name.
+var completionScripts = map[string]string{
+	"bash": `_%[2]s_complete() {
+  local cur words
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  words=("${COMP_WORDS[@]:0:COMP_CWORD}")
+  if [[ "$cur" == -* ]]; then
+    words+=("$cur")
+  fi
+  COMPREPLY=($(compgen -W "$("${words[@]}" --generate-bash-completion 2>/dev/null)" -- "$cur"))
+}
+complete -o bashdefault -o default -F _%[2]s_complete %[1]s
+`,
+	"zsh": `#compdef %[1]s
+
+_%[2]s_complete() {
+  local -a opts
+  local cur=${words[-1]}
+  if [[ "$cur" == -* ]]; then
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} $cur --generate-bash-completion 2>/dev/null)}")
+  else
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} --generate-bash-completion 2>/dev/null)}")
+  fi
+  if [[ "${opts[1]}" != "" ]]; then
+    _describe 'values' opts
+  else
+    _files
+  fi
+}
+
+compdef _%[2]s_complete %[1]s
+`,
+	"fish": `function __%[2]s_complete
+    set -l words (commandline -opc)
+    set -l cur (commandline -ct)
+    if string match -q -- '-*' $cur
+        set words $words $cur
+    end
+    eval (string escape -- $words) --generate-bash-completion 2>/dev/null
+end
+complete -c %[1]s -f -a '(__%[2]s_complete)'
+`,
+	"powershell": `Register-ArgumentCompleter -Native -CommandName %[1]s -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '' -and -not $wordToComplete.StartsWith('-')) {
+        $words = @($words | Select-Object -SkipLast 1)
+    }
+    $rest = @($words | Select-Object -Skip 1)
+    & $words[0] @rest --generate-bash-completion 2>$null |
+        Where-Object { $_ -like "$wordToComplete*" } |
+        ForEach-Object { [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_) }
+}
+`,
+}
+
+var completionCommand = &cli.Command{
+	Name:      "completion",
+	Usage:     "Print the shell completion script for bash, zsh, fish or powershell",
+	ArgsUsage: "bash|zsh|fish|powershell",
+	Action: func(c *cli.Context) error {
+		shell := c.Args().First()
+		script, ok := completionScripts[shell]
+		if !ok {
+			return fmt.Errorf("unknown shell %q, expected bash, zsh, fish or powershell", shell)
+		}
+		name := c.App.Name
+		fmt.Fprintf(c.App.Writer, script, name, strings.ReplaceAll(name, "-", "_"))
+		return nil
+	},
+}
+
+// completeCollections completes the value of the named flags with collection
+// names and everything else like urfave/cli does. migratedOnly restricts the
+// names to collections the migration knows.
+func completeCollections(migratedOnly bool, flags ...string) cli.BashCompleteFunc {
+	return func(c *cli.Context) {
+		// The last argument is --generate-bash-completion itself
+		if len(os.Args) >= 3 {
+			prev := strings.TrimLeft(os.Args[len(os.Args)-2], "-")
+			for _, flag := range flags {
+				if prev == flag {
+					for _, name := range collectionNames(c, migratedOnly) {
+						fmt.Fprintln(c.App.Writer, name)
+					}
+					return
+				}
+			}
+		}
+		cli.DefaultCompleteWithFlags(c.Command)(c)
+	}
+}
+
+// collectionNames lists the collections of the configured MongoDB. When it
+// can't be reached in time the collections the migration knows are offered
+// instead.
+func collectionNames(c *cli.Context, migratedOnly bool) []string {
+	var known []string
+	if cfg, err := loadConfig(c.String("config")); err == nil {
+		for _, cm := range cfg.migrations() {
+			known = append(known, cm.Name)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	source, err := newMongoSource(ctx, c.String("mongodb-uri"), nil)
+	if err != nil {
+		return known
+	}
+	defer source.Close(context.Background())
+	names, err := source.db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return known
+	}
+	sort.Strings(names)
+	if !migratedOnly {
+		return names
+	}
+	var migrated []string
+	for _, name := range names {
+		for _, k := range known {
+			if name == k {
+				migrated = append(migrated, name)
+			}
+		}
+	}
+	return migrated
+}
@@ -0,0 +1,227 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// config is the optional JSON file passed with --config. Values may reference
+// ${NAME} or ${NAME:-default}, resolved from Vars first and then from the
+// environment, so one file can serve every environment.
+type config struct {
+	// Vars are substituted for ${name} references elsewhere in the file.
+	Vars map[string]string `json:"vars" doc:"values for ${name} references; may themselves reference environment variables"`
+	// Secrets read environment variables out of a secrets manager.
+	Secrets *secretStore `json:"secrets" doc:"AWS, GCP, Vault or Doppler secrets read into the environment variables the flags take, e.g. MYSQL_URI"`
+	// Profiles name the environments --profile switches between.
+	Profiles map[string]profile `json:"profiles" doc:"databases of each environment, e.g. dev, staging and prod, picked with --profile"`
+	// Assertions are run against MySQL after every migration.
+	Assertions []sqlAssertion `json:"assertions" commands:"migrate,assert" doc:"SQL checks run against MySQL after migrating"`
+	// Mappings migrate additional collections without a Go transfer function.
+	Mappings []*collectionMapping `json:"mappings" commands:"migrate" doc:"collections migrated without a Go transfer function"`
+	// Indexes replace defaultIndexes when set; an empty list builds none.
+	Indexes []indexDefinition `json:"indexes" commands:"migrate" default:"username, email, post created_at and author" doc:"secondary indexes built around the data load"`
+	// Badges replace defaultBadgeRules when set.
+	Badges []badgeRule `json:"badges" commands:"backfill-badges" default:"early-adopter and verified-developer" doc:"badges awarded to the migrated users"`
+	// DateLayouts replace defaultDateLayouts when set.
+	DateLayouts []string `json:"dateLayouts" commands:"migrate,import,export,diff" default:"RFC 3339, ISO dates and times, January 02, 2006 and its variants" doc:"Go time layouts tried in order on dates stored as text"`
+	// Validations replace defaultValidationRules when set; an empty list checks nothing.
+	Validations []*validationRule `json:"validations" commands:"migrate,import" default:"usernames, emails, avatar, banner and partner URLs, blog slugs" doc:"checks on column values before they are written"`
+	// Transforms rewrite column values on their way into MySQL.
+	Transforms []*fieldTransform `json:"transforms" commands:"migrate,import,export,diff" doc:"registered functions applied to column values, e.g. to move avatar URLs to a new CDN"`
+	// MediaHosts move media URLs off legacy hosts.
+	MediaHosts map[string]string `json:"mediaHosts" commands:"migrate,import,diff" doc:"legacy media hosts and the CDN base URL their avatar, banner, image and logo URLs move to"`
+	// MediaStore is where --upload-media copies media to.
+	MediaStore *objectStore `json:"mediaStore" commands:"migrate,import,diff" doc:"S3, R2 or MinIO bucket --upload-media copies the media files into"`
+	// Filters narrow down the documents read from each collection.
+	Filters map[string]queryFilter `json:"filters" commands:"migrate,diff,export" doc:"MongoDB query in extended JSON per collection, e.g. only recent posts; --filter overrides it"`
+	// Projections leave fields out of the documents read from each collection.
+	Projections map[string]fieldProjection `json:"projections" commands:"migrate,diff,export" doc:"fields excluded from, or the only ones included in, the documents read per collection"`
+	// Notifications tell chat channels and webhooks how runs go.
+	Notifications []notifierConfig `json:"notifications" commands:"migrate" doc:"Discord, Slack or webhook notifiers and the run events they get"`
+	// Sentry gets the panics, errors and failed documents of migrations.
+	Sentry *sentryConfig `json:"sentry" commands:"migrate" doc:"Sentry project migrate reports panics, errors and failed documents to"`
+}
+
+// indexes returns the configured indexes, or the defaults when the config lists none.
+func (cfg *config) indexes() []indexDefinition {
+	if cfg.Indexes == nil {
+		return defaultIndexes
+	}
+	return cfg.Indexes
+}
+
+// badges returns the configured badge rules, or the defaults when the config lists none.
+func (cfg *config) badges() []badgeRule {
+	if cfg.Badges == nil {
+		return defaultBadgeRules
+	}
+	return cfg.Badges
+}
+
+// validations returns the configured validation rules, or the defaults when the config lists none.
+func (cfg *config) validations() []*validationRule {
+	if cfg.Validations == nil {
+		return defaultValidationRules
+	}
+	return cfg.Validations
+}
+
+// dateLayouts returns the configured date layouts, or the defaults when the config lists none.
+func (cfg *config) dateLayouts() []string {
+	if cfg.DateLayouts == nil {
+		return defaultDateLayouts
+	}
+	return cfg.DateLayouts
+}
+
+// migrations returns the built-in collection migrations followed by the mapped ones.
+func (cfg *config) migrations() []collectionMigration {
+	all := append([]collectionMigration(nil), collectionMigrations...)
+	for _, mapping := range cfg.Mappings {
+		all = append(all, mapping.migration())
+	}
+	return all
+}
+
+// loadConfig reads the config file at path; an empty path yields the zero config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+	if data, err = expandConfig(data); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %v", path, err)
+	}
+	mapped := map[string]bool{}
+	for _, mapping := range cfg.Mappings {
+		if err := mapping.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+		if knownCollection(collectionMigrations, mapping.Collection) || mapped[mapping.Collection] {
+			return nil, fmt.Errorf("config %s: collection %s is already migrated", path, mapping.Collection)
+		}
+		mapped[mapping.Collection] = true
+	}
+	for _, idx := range cfg.Indexes {
+		if err := idx.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	for _, rule := range cfg.Badges {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	for _, rule := range cfg.Validations {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	for _, t := range cfg.Transforms {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	if err := validateMediaHosts(cfg.MediaHosts); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	if cfg.MediaStore != nil {
+		if err := cfg.MediaStore.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	if err := validateFilters(cfg.migrations(), cfg.Filters); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	if err := validateProjections(cfg.migrations(), cfg.Projections); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	if cfg.Secrets != nil {
+		if err := cfg.Secrets.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	for name, p := range cfg.Profiles {
+		if err := p.validate(name); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	for _, nc := range cfg.Notifications {
+		if err := nc.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	if cfg.Sentry != nil {
+		if err := cfg.Sentry.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// configReference matches ${NAME} and ${NAME:-default}; $${ escapes a literal ${.
+var configReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandConfig resolves the references in a config file. The vars section is
+// expanded against the environment first, then the whole file against the
+// vars and the environment. Substituted values are JSON-escaped so they can
+// appear anywhere inside a string.
+func expandConfig(data []byte) ([]byte, error) {
+	var head struct {
+		Vars map[string]string `json:"vars"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	for name, value := range head.Vars {
+		expanded, err := expandReferences(value, nil, false)
+		if err != nil {
+			return nil, fmt.Errorf("vars.%s: %v", name, err)
+		}
+		vars[name] = expanded
+	}
+	expanded, err := expandReferences(string(data), vars, true)
+	return []byte(expanded), err
+}
+
+func expandReferences(s string, vars map[string]string, escape bool) (string, error) {
+	var missing []string
+	out := configReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		m := configReference.FindStringSubmatch(ref)
+		value, ok := vars[m[1]]
+		if !ok {
+			value, ok = os.LookupEnv(m[1])
+		}
+		if !ok {
+			if m[2] == "" {
+				missing = append(missing, m[1])
+				return ref
+			}
+			value = m[3]
+		}
+		if escape {
+			quoted, _ := json.Marshal(value)
+			value = string(quoted[1 : len(quoted)-1])
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variable(s) %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
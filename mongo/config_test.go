@@ -0,0 +1,87 @@
+package mongo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandConfig(t *testing.T) {
+	t.Setenv("CLI_TOOLS_TEST_HOST", "db.internal")
+	t.Setenv("CLI_TOOLS_TEST_QUOTED", `pa"ss\word`)
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "environment",
+			config: `{"mysqlURI": "tcp(${CLI_TOOLS_TEST_HOST}:3306)/app"}`,
+			want:   `{"mysqlURI": "tcp(db.internal:3306)/app"}`,
+		},
+		{
+			name:   "default",
+			config: `{"bucket": "${CLI_TOOLS_TEST_UNSET:-media}"}`,
+			want:   `{"bucket": "media"}`,
+		},
+		{
+			name:   "empty default",
+			config: `{"prefix": "${CLI_TOOLS_TEST_UNSET:-}"}`,
+			want:   `{"prefix": ""}`,
+		},
+		{
+			name:   "vars",
+			config: `{"vars": {"env": "staging"}, "bucket": "media-${env}"}`,
+			want:   `{"vars": {"env": "staging"}, "bucket": "media-staging"}`,
+		},
+		{
+			name:   "vars from the environment",
+			config: `{"vars": {"host": "${CLI_TOOLS_TEST_HOST}"}, "uri": "mongodb://${host}"}`,
+			want:   `{"vars": {"host": "db.internal"}, "uri": "mongodb://db.internal"}`,
+		},
+		{
+			name:   "vars before the environment",
+			config: `{"vars": {"CLI_TOOLS_TEST_HOST": "other"}, "uri": "${CLI_TOOLS_TEST_HOST}"}`,
+			want:   `{"vars": {"CLI_TOOLS_TEST_HOST": "other"}, "uri": "other"}`,
+		},
+		{
+			name:   "escaped for JSON",
+			config: `{"password": "${CLI_TOOLS_TEST_QUOTED}"}`,
+			want:   `{"password": "pa\"ss\\word"}`,
+		},
+		{
+			name:   "literal",
+			config: `{"template": "$${CLI_TOOLS_TEST_HOST}"}`,
+			want:   `{"template": "${CLI_TOOLS_TEST_HOST}"}`,
+		},
+		{
+			name:    "undefined",
+			config:  `{"a": "${CLI_TOOLS_TEST_UNSET}", "b": "${CLI_TOOLS_TEST_UNSET_TOO}"}`,
+			wantErr: "undefined variable(s) CLI_TOOLS_TEST_UNSET, CLI_TOOLS_TEST_UNSET_TOO",
+		},
+		{
+			name:    "undefined in vars",
+			config:  `{"vars": {"host": "${CLI_TOOLS_TEST_UNSET}"}}`,
+			wantErr: "vars.host: undefined variable(s) CLI_TOOLS_TEST_UNSET",
+		},
+		{
+			name:    "not JSON",
+			config:  `{"vars": `,
+			wantErr: "unexpected end of JSON input",
+		},
+	}
+	for _, tt := range tests {
+		got, err := expandConfig([]byte(tt.config))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mongo
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Inspect the config file format",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "explain",
+			Usage: "List every config key with its type, default and the commands it affects",
+			Action: func(c *cli.Context) error {
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "KEY\tTYPE\tDEFAULT\tCOMMANDS\tDESCRIPTION")
+				for _, key := range explainConfig(reflect.TypeOf(config{}), "", "") {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.Path, key.Type, key.Default, key.Commands, key.Doc)
+				}
+				return w.Flush()
+			},
+		},
+	},
+}
+
+// configKey describes one key of the config file, read from the struct tags of
+// the config types so the listing can't drift from what loadConfig accepts.
+type configKey struct {
+	Path     string
+	Type     string
+	Default  string
+	Commands string
+	Doc      string
+}
+
+// explainConfig walks the fields of struct type t. Keys inherit the commands
+// of the key they are nested in unless they name their own.
+func explainConfig(t reflect.Type, prefix, commands string) []configKey {
+	var keys []configKey
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		cmds := field.Tag.Get("commands")
+		if cmds == "" {
+			cmds = commands
+		}
+		def := field.Tag.Get("default")
+		if def == "" {
+			def = "-"
+		}
+
+		path := prefix + name
+		elem := field.Type
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		keys = append(keys, configKey{Path: path, Type: configTypeName(elem), Default: def, Commands: cmds, Doc: field.Tag.Get("doc")})
+
+		switch elem.Kind() {
+		case reflect.Slice:
+			elem = elem.Elem()
+			path += "[]"
+		case reflect.Map:
+			elem = elem.Elem()
+			path += ".<name>"
+		}
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Struct {
+			keys = append(keys, explainConfig(elem, path+".", cmds)...)
+		}
+	}
+	return keys
+}
+
+func configTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Slice:
+		elem := t.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Struct {
+			return "list of objects"
+		}
+		return "list of " + configTypeName(elem)
+	case reflect.Struct:
+		return "object"
+	case reflect.Map:
+		return "map of " + configTypeName(t.Elem())
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Float64:
+		return "number"
+	default:
+		return t.Kind().String()
+	}
+}
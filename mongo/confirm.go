@@ -0,0 +1,201 @@
+package mongo
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// countTimeout bounds counting the documents of the collections for the plan.
+const countTimeout = 10 * time.Second
+
+// runPlan is what a migration is about to do, shown before it starts.
+type runPlan struct {
+	source      string
+	target      string
+	collections []plannedCollection
+	mode        []string
+	// overwrites are the options that change or replace data already in the target
+	overwrites []string
+	// resets is set when the target's tables are dropped, which needs
+	// confirming even without a terminal
+	resets bool
+}
+
+// plannedCollection is a collection of the plan with its estimated number of
+// documents, -1 when the source can't count them.
+type plannedCollection struct {
+	name     string
+	estimate int64
+}
+
+// newRunPlan describes the migration the flags of c ask for.
+func newRunPlan(c *cli.Context, source documentSource, selected []collectionMigration, filters map[string]bson.D, sample *documentSample) (*runPlan, error) {
+	plan := &runPlan{}
+	var err error
+	if plan.source, err = sourceDescription(c); err != nil {
+		return nil, err
+	}
+	plan.target = "none, a dry run doesn't connect to MySQL"
+	if !c.Bool("dry-run") {
+		dsn, err := mysql.ParseDSN(c.String("mysql-uri"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid MYSQL_URI: %v", err)
+		}
+		plan.target = dsn.Net + "(" + dsn.Addr + ")/" + dsn.DBName
+		if c.Bool("trial") {
+			plan.target = "a trial_<timestamp> database next to " + plan.target
+		}
+	}
+
+	counter, _ := source.(documentCounter)
+	for _, cm := range selected {
+		estimate := int64(-1)
+		if counter != nil {
+			ctx, cancel := context.WithTimeout(c.Context, countTimeout)
+			n, err := counter.Count(ctx, cm.Name, filters[cm.Name])
+			cancel()
+			if err != nil {
+				logf(levelWarn, "Couldn't count %s: %v", cm.Name, err)
+			} else {
+				estimate = sample.estimate(n)
+			}
+		}
+		plan.collections = append(plan.collections, plannedCollection{name: cm.Name, estimate: estimate})
+	}
+
+	switch {
+	case c.Bool("dry-run"):
+		plan.mode = append(plan.mode, "dry run: documents are read and transformed, nothing is written")
+	case c.Bool("trial"):
+		plan.mode = append(plan.mode, "trial: the throwaway database is compared with the real target, then dropped")
+	case c.Bool("upsert") || c.IsSet("resume"):
+		plan.mode = append(plan.mode, "upsert: existing rows are overwritten")
+	default:
+		plan.mode = append(plan.mode, "insert: documents whose rows already exist are quarantined")
+	}
+	if id := c.String("resume"); id != "" {
+		plan.mode = append(plan.mode, "resuming run "+id+" from its checkpoints")
+	}
+	if sample != nil {
+		plan.mode = append(plan.mode, "sample: "+sample.String())
+	}
+	for _, cm := range selected {
+		if doc, ok := filters[cm.Name]; ok {
+			plan.mode = append(plan.mode, fmt.Sprintf("filter: only the %s documents matching %s", cm.Name, filterText(doc)))
+		}
+	}
+	if !c.Bool("dry-run") {
+		plan.mode = append(plan.mode, "indexes: "+c.String("indexes"), "one transaction per "+c.String("tx-per"))
+		if c.Bool("foreign-keys") {
+			plan.mode = append(plan.mode, "foreign keys are added at the end")
+		}
+	}
+
+	// A trial or dry run leaves the real target alone
+	if !c.Bool("dry-run") && !c.Bool("trial") {
+		if c.Bool("reset-target") {
+			warning := "--reset-target DROPS every table of the target schema, including those of collections not selected, and recreates them empty"
+			if c.Bool("reset-id-map") {
+				warning += "; --reset-id-map DROPS id_map too, so every document gets a new UUID and references to the old ones break"
+			} else {
+				warning += ", except id_map, so documents keep their UUIDs"
+			}
+			plan.overwrites = append(plan.overwrites, warning)
+			plan.resets = true
+		}
+		if c.Bool("upsert") {
+			plan.overwrites = append(plan.overwrites, "--upsert overwrites the rows that already exist in the target")
+		}
+		if id := c.String("resume"); id != "" {
+			plan.overwrites = append(plan.overwrites, "--resume "+id+" overwrites the rows the resumed run may have written after its last checkpoint, partners by title and blog entries by position")
+		}
+		if c.Bool("accept-new-target") {
+			plan.overwrites = append(plan.overwrites, "--accept-new-target migrates into a target stamped by another environment and restamps it")
+		}
+	}
+	return plan, nil
+}
+
+// estimate is the number of the n documents of a collection the sample keeps.
+func (s *documentSample) estimate(n int64) int64 {
+	if s == nil {
+		return n
+	}
+	n = n * int64(s.share) / samplePrecision
+	if s.limit > 0 && n > int64(s.limit) {
+		n = int64(s.limit)
+	}
+	return n
+}
+
+// print writes the plan to w, the overwriting options in red when color is set.
+func (p *runPlan) print(w io.Writer, color bool) {
+	fmt.Fprintf(w, "Source: %s\nTarget: %s\n", p.source, p.target)
+	fmt.Fprintln(w, "Collections:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var total int64
+	counted := true
+	for _, cm := range p.collections {
+		estimate := "?"
+		if cm.estimate >= 0 {
+			estimate = fmt.Sprintf("~%d", cm.estimate)
+			total += cm.estimate
+		} else {
+			counted = false
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t\n", cm.name, estimate)
+	}
+	if counted && len(p.collections) > 1 {
+		fmt.Fprintf(tw, "  total\t~%d\t\n", total)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "Mode:")
+	for _, m := range p.mode {
+		fmt.Fprintf(w, "  %s\n", m)
+	}
+	for _, o := range p.overwrites {
+		if color {
+			fmt.Fprintf(w, "\033[1;31mWARNING: %s\033[0m\n", o)
+		} else {
+			fmt.Fprintf(w, "WARNING: %s\n", o)
+		}
+	}
+}
+
+// confirmRun shows the plan and asks whether to go ahead with it, unless
+// --yes is set. Without a terminal to ask on, as under cron or the daemon, the
+// plan is only logged, but dropping the target's tables still needs --yes.
+func confirmRun(c *cli.Context, plan *runPlan) error {
+	if !c.Bool("yes") && !isTerminal(os.Stdin) && plan.resets {
+		return fmt.Errorf("--reset-target drops the target's tables; pass --yes to confirm it without a terminal")
+	}
+	if c.Bool("yes") || !isTerminal(os.Stdin) {
+		var b strings.Builder
+		plan.print(&b, false)
+		for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+			logf(levelInfo, "%s", line)
+		}
+		return nil
+	}
+	plan.print(os.Stderr, isTerminal(os.Stderr))
+	fmt.Fprint(os.Stderr, "Proceed? [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("no answer; pass --yes to migrate without confirming")
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return cli.Exit("Not confirmed, nothing was done", 1)
+}
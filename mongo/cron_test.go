@@ -0,0 +1,80 @@
+package mongo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"0 3 * *", "expected 5 fields"},
+		{"0 3 * * * *", "expected 5 fields"},
+		{"60 * * * *", "minute: \"60\" is outside 0-59"},
+		{"* 24 * * *", "hour: \"24\" is outside 0-23"},
+		{"* * 0 * *", "day of month: \"0\" is outside 1-31"},
+		{"* * * 13 *", "month: \"13\" is outside 1-12"},
+		{"* * * * 8", "day of week: \"8\" is outside 0-7"},
+		{"*/0 * * * *", "invalid step"},
+		{"5-1 * * * *", "invalid range"},
+		{"a * * * *", "invalid value"},
+		{"60/5 * * * *", "minute: \"60\" is outside 0-59"},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseCron(%q) = %v, want an error containing %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 10, 14, 9, 25, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2026, 10, 14, 9, 26, 0, 0, time.UTC)},
+		{"0 3 * * *", from, time.Date(2026, 10, 15, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)},
+		{"*/15 8-18 * * 1-5", time.Date(2026, 10, 16, 18, 50, 0, 0, time.UTC), time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		// A step after a single value runs from it to the end of the field
+		{"5/20 * * * *", from, time.Date(2026, 10, 14, 9, 45, 0, 0, time.UTC)},
+		{"0 1/12 * * *", from, time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC)},
+		{"0,30 9 * * *", from, time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", from, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", from, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// 7 is Sunday like 0
+		{"0 12 * * 7", from, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		// Both days restricted: either one matching is enough
+		{"0 0 20 * 5", from, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 0", from, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		// A time on the minute still moves on to the next one
+		{"30 9 * * *", time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC), time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := s.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.from.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	s, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("February 31 = %s, want the zero time", got)
+	}
+}
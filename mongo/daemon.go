@@ -0,0 +1,307 @@
+package mongo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// daemonFlags locate the files a running daemon keeps.
+var daemonFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "pid-file",
+		Value: "cli-tools-daemon.pid",
+		Usage: "file the daemon's process ID is kept in while it runs",
+	},
+	&cli.StringFlag{
+		Name:  "status-file",
+		Value: "cli-tools-daemon.json",
+		Usage: "file the daemon records its schedule and runs in",
+	},
+}
+
+// defaultDaemonCommand is rerun when daemon run isn't given a command. Rows
+// from earlier runs are overwritten rather than quarantined as duplicates.
+var defaultDaemonCommand = []string{"migrate", "--upsert"}
+
+var daemonCommand = &cli.Command{
+	Name:  "daemon",
+	Usage: "Rerun a migration on a schedule",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "run",
+			Usage:     "Run a command (default: migrate --upsert) whenever the schedule matches, until SIGTERM",
+			ArgsUsage: "[command [flags]]",
+			Flags: withFlags(daemonFlags, []cli.Flag{
+				&cli.StringFlag{
+					Name:     "schedule",
+					Required: true,
+					Usage:    `cron expression in local time, e.g. "0 3 * * *" for 03:00 every day`,
+				},
+			}),
+			Action: runDaemon,
+		},
+		{
+			Name:   "status",
+			Usage:  "Show whether the daemon is running, its last run and its next",
+			Flags:  daemonFlags,
+			Action: daemonStatus,
+		},
+	},
+}
+
+// fromEnvironment reports whether f has the value its environment variable
+// gives it, which a child process inherits anyway.
+func fromEnvironment(c *cli.Context, f cli.Flag) bool {
+	envFlag, ok := f.(cli.DocGenerationFlag)
+	if !ok {
+		return false
+	}
+	for _, env := range envFlag.GetEnvVars() {
+		if value, ok := os.LookupEnv(env); ok {
+			return value == fmt.Sprint(c.Value(f.Names()[0]))
+		}
+	}
+	return false
+}
+
+// daemonState is what the status file holds.
+type daemonState struct {
+	PID       int        `json:"pid"`
+	Schedule  string     `json:"schedule"`
+	Command   []string   `json:"command"`
+	StartedAt time.Time  `json:"startedAt"`
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"`
+	NextRun   *time.Time `json:"nextRun,omitempty"`
+	Runs      int        `json:"runs"`
+	Current   *daemonRun `json:"current,omitempty"`
+	Last      *daemonRun `json:"last,omitempty"`
+}
+
+// daemonRun is one run of the scheduled command.
+type daemonRun struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExitCode   int        `json:"exitCode"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func runDaemon(c *cli.Context) error {
+	schedule, err := parseCron(c.String("schedule"))
+	if err != nil {
+		return err
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return fmt.Errorf("schedule %q never matches", c.String("schedule"))
+	}
+	command := c.Args().Slice()
+	if len(command) == 0 {
+		command = defaultDaemonCommand
+	}
+	// The runs see the same global flags as the daemon. They inherit its
+	// environment, so values it set are left to it rather than put where ps
+	// shows them, and the SSH key passphrase never goes on the command line.
+	var args []string
+	var forwarded []cli.Flag
+	for _, f := range c.App.Flags {
+		switch f.Names()[0] {
+		case "config", "log-level", "profile":
+			forwarded = append(forwarded, f)
+		}
+	}
+	for _, f := range withFlags(tlsFlags, sshFlags, poolFlags) {
+		if f.Names()[0] != "ssh-key-passphrase" {
+			forwarded = append(forwarded, f)
+		}
+	}
+	for _, f := range forwarded {
+		name := f.Names()[0]
+		if c.IsSet(name) && !fromEnvironment(c, f) {
+			args = append(args, fmt.Sprintf("--%s=%v", name, c.Value(name)))
+		}
+	}
+	// Confirming the daemon against a production profile confirms its runs
+	if productionConfirmed {
+		args = append(args, "--yes-i-mean-it")
+	}
+	// Nobody is there to confirm the migrations it starts
+	if command[0] == "migrate" {
+		command = append([]string{"migrate", "--yes"}, command[1:]...)
+	}
+	args = append(args, command...)
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	pidFile, statusFile := c.String("pid-file"), c.String("status-file")
+	if pid, ok := readPIDFile(pidFile); ok && processAlive(pid) {
+		return fmt.Errorf("daemon already running as pid %d (see %s)", pid, pidFile)
+	}
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return fmt.Errorf("error writing pid file: %v", err)
+	}
+	defer os.Remove(pidFile)
+
+	state := &daemonState{PID: os.Getpid(), Schedule: c.String("schedule"), Command: command, StartedAt: time.Now()}
+	save := func() {
+		if err := writeDaemonState(statusFile, state); err != nil {
+			logf(levelWarn, "%v", err)
+		}
+	}
+	defer func() {
+		now := time.Now()
+		state.StoppedAt, state.NextRun = &now, nil
+		save()
+	}()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	logf(levelInfo, "Daemon started as pid %d, running %s on %q", state.PID, strings.Join(command, " "), state.Schedule)
+	for {
+		next := schedule.next(time.Now())
+		state.NextRun = &next
+		save()
+		logf(levelInfo, "Next run at %s", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+		case sig := <-signals:
+			logf(levelInfo, "%v received, stopping", sig)
+			return nil
+		}
+
+		run := &daemonRun{StartedAt: time.Now()}
+		state.Current, state.NextRun = run, nil
+		state.Runs++
+		save()
+		cmd := exec.Command(exe, args...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		// The run only stops when the daemon passes a signal on below
+		ownProcessGroup(cmd)
+		stopping := false
+		if err = cmd.Start(); err == nil {
+			done := make(chan error, 1)
+			go func() { done <- cmd.Wait() }()
+			select {
+			case err = <-done:
+			case sig := <-signals:
+				// Stopping mid-run would leave a half-migrated collection behind
+				stopping = true
+				logf(levelInfo, "%v received, stopping once the current run finishes; send it again to stop the run too", sig)
+				select {
+				case err = <-done:
+				case <-signals:
+					cmd.Process.Signal(syscall.SIGTERM)
+					err = <-done
+				}
+			}
+		}
+
+		finished := time.Now()
+		run.FinishedAt = &finished
+		var exit *exec.ExitError
+		switch {
+		case errors.As(err, &exit):
+			run.ExitCode = exit.ExitCode()
+			logf(levelError, "Run %d failed with exit code %d", state.Runs, run.ExitCode)
+		case err != nil:
+			run.ExitCode, run.Error = -1, err.Error()
+			logf(levelError, "Run %d failed: %v", state.Runs, err)
+		default:
+			logf(levelInfo, "Run %d finished in %s", state.Runs, finished.Sub(run.StartedAt).Round(time.Second))
+		}
+		state.Current, state.Last = nil, run
+		if stopping {
+			return nil
+		}
+	}
+}
+
+func daemonStatus(c *cli.Context) error {
+	state := &daemonState{}
+	data, err := os.ReadFile(c.String("status-file"))
+	if err == nil {
+		err = json.Unmarshal(data, state)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading daemon status: %v", err)
+	}
+
+	pid, ok := readPIDFile(c.String("pid-file"))
+	running := ok && processAlive(pid)
+	if running {
+		fmt.Printf("Running as pid %d since %s\n", pid, state.StartedAt.Format(time.RFC3339))
+	} else if state.StoppedAt != nil {
+		fmt.Printf("Not running, stopped %s\n", state.StoppedAt.Format(time.RFC3339))
+	} else {
+		fmt.Println("Not running")
+	}
+	if state.Schedule != "" {
+		fmt.Printf("Schedule:  %s: %s\n", state.Schedule, strings.Join(state.Command, " "))
+		fmt.Printf("Runs:      %d\n", state.Runs)
+	}
+	if running && state.Current != nil {
+		fmt.Printf("Current:   started %s\n", state.Current.StartedAt.Format(time.RFC3339))
+	}
+	if running && state.NextRun != nil {
+		fmt.Printf("Next run:  %s\n", state.NextRun.Format(time.RFC3339))
+	}
+	if last := state.Last; last != nil {
+		outcome := "ok"
+		if last.Error != "" {
+			outcome = last.Error
+		} else if last.ExitCode != 0 {
+			outcome = fmt.Sprintf("exit code %d", last.ExitCode)
+		}
+		fmt.Printf("Last run:  started %s, took %s, %s\n", last.StartedAt.Format(time.RFC3339), last.FinishedAt.Sub(last.StartedAt).Round(time.Second), outcome)
+	}
+	if !running {
+		return cli.Exit("", 3)
+	}
+	return nil
+}
+
+// writeDaemonState replaces the status file, renaming the new one into place
+// so daemon status never reads a torn file.
+func writeDaemonState(path string, state *daemonState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing daemon status: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error writing daemon status: %v", err)
+	}
+	return nil
+}
+
+func readPIDFile(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil && pid > 0
+}
+
+// processAlive reports whether a process with the given ID exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
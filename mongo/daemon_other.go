@@ -0,0 +1,9 @@
+//go:build !unix
+
+package mongo
+
+import "os/exec"
+
+// ownProcessGroup leaves cmd in the daemon's process group where there are
+// no Unix process groups.
+func ownProcessGroup(cmd *exec.Cmd) {}
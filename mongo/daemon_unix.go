@@ -0,0 +1,14 @@
+//go:build unix
+
+package mongo
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// ownProcessGroup starts cmd in a process group of its own, so a Ctrl-C at
+// the daemon's terminal reaches the daemon but not the run it started.
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,239 @@
+package mongo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var publishCommand = &cli.Command{
+	Name:  "publish",
+	Usage: "Publish data from MySQL for outside use",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "dataset",
+			Usage: "Write the anonymized research dataset: posts, users, comments and hearts with hashed IDs, a license and a schema description",
+			Flags: withFlags(mysqlFlags, []cli.Flag{
+				&cli.StringFlag{Name: "out", Value: "dataset", Usage: "directory the dataset is written to"},
+				&cli.StringFlag{Name: "license", Value: "CC-BY-4.0", Usage: "SPDX ID of the dataset license: CC-BY-4.0, CC-BY-NC-4.0, CC0-1.0 or ODbL-1.0"},
+				&cli.StringFlag{Name: "attribution", Value: "NetSocial", Usage: "who the dataset is attributed to"},
+			}),
+			Action: publishDataset,
+		},
+	},
+}
+
+// datasetLicenses are the licenses a dataset may be published under.
+var datasetLicenses = map[string]struct{ Name, URL string }{
+	"CC-BY-4.0":    {"Creative Commons Attribution 4.0 International", "https://creativecommons.org/licenses/by/4.0/legalcode"},
+	"CC-BY-NC-4.0": {"Creative Commons Attribution-NonCommercial 4.0 International", "https://creativecommons.org/licenses/by-nc/4.0/legalcode"},
+	"CC0-1.0":      {"Creative Commons Zero v1.0 Universal", "https://creativecommons.org/publicdomain/zero/1.0/legalcode"},
+	"ODbL-1.0":     {"Open Data Commons Open Database License v1.0", "https://opendatacommons.org/licenses/odbl/1-0/"},
+}
+
+// datasetColumn is one published column. Kind says how the selected value is
+// published: "id" as a keyed pseudonym, "date" cut to the day, "plain" as is.
+type datasetColumn struct {
+	Name        string
+	Kind        string
+	Description string
+}
+
+// datasetTable is one CSV file of the dataset. Query selects the columns'
+// source values in order; free text, names, contact details and images are
+// never selected, only lengths and flags derived from them.
+type datasetTable struct {
+	File        string
+	Description string
+	Query       string
+	Columns     []datasetColumn
+}
+
+var datasetTables = []datasetTable{
+	{
+		File:        "users.csv",
+		Description: "One row per account.",
+		Query:       "SELECT id, created_at, is_verified, is_organisation FROM users",
+		Columns: []datasetColumn{
+			{"user", "id", "pseudonymous user ID"},
+			{"created", "date", "day the account was created (UTC)"},
+			{"verified", "plain", "1 if the account is verified"},
+			{"organisation", "plain", "1 if the account belongs to an organisation"},
+		},
+	},
+	{
+		File:        "posts.csv",
+		Description: "One row per post. Post text is not published, only its length.",
+		Query:       "SELECT id, author, created_at, CHAR_LENGTH(title), CHAR_LENGTH(content), COALESCE(image_url, '') <> '' OR COALESCE(image, '') <> '' FROM posts",
+		Columns: []datasetColumn{
+			{"post", "id", "pseudonymous post ID"},
+			{"author", "id", "user who wrote the post"},
+			{"created", "date", "day the post was created (UTC)"},
+			{"title_length", "plain", "length of the title in characters"},
+			{"content_length", "plain", "length of the content in characters"},
+			{"has_image", "plain", "1 if the post has an image"},
+		},
+	},
+	{
+		File:        "comments.csv",
+		Description: "One row per comment or reply. Comment text is not published, only its length.",
+		Query:       "SELECT id, post_id, parent_id, author, created_at, CHAR_LENGTH(content) FROM comments",
+		Columns: []datasetColumn{
+			{"comment", "id", "pseudonymous comment ID"},
+			{"post", "id", "post the comment is on"},
+			{"parent", "id", "comment this one replies to, empty for top-level comments"},
+			{"author", "id", "user who wrote the comment"},
+			{"created", "date", "day the comment was written (UTC), empty when unknown"},
+			{"content_length", "plain", "length of the comment in characters"},
+		},
+	},
+	{
+		File:        "hearts.csv",
+		Description: "One row per heart a user gave a post; together with authors and comments this is the interaction graph.",
+		Query:       "SELECT post_id, user_id FROM post_heart",
+		Columns: []datasetColumn{
+			{"post", "id", "post that was hearted"},
+			{"user", "id", "user who hearted it"},
+		},
+	},
+}
+
+func publishDataset(c *cli.Context) error {
+	license, ok := datasetLicenses[c.String("license")]
+	if !ok {
+		return fmt.Errorf("unknown --license %q, expected CC-BY-4.0, CC-BY-NC-4.0, CC0-1.0 or ODbL-1.0", c.String("license"))
+	}
+	// IDs are keyed so nobody can hash a known ObjectID and find its rows
+	key := os.Getenv("DATASET_HASH_KEY")
+	if key == "" {
+		return fmt.Errorf("publish dataset needs DATASET_HASH_KEY")
+	}
+	out := c.String("out")
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+
+	mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+	defer mysqlDB.Close()
+
+	counts := make([]int, len(datasetTables))
+	for i, table := range datasetTables {
+		n, err := writeDatasetTable(mysqlDB, filepath.Join(out, table.File), table, []byte(key))
+		if err != nil {
+			return err
+		}
+		counts[i] = n
+		logf(levelInfo, "%s: %d row(s)", table.File, n)
+	}
+
+	licenseText := fmt.Sprintf("This dataset by %s is licensed under the %s (SPDX: %s).\nFull text: %s\n",
+		c.String("attribution"), license.Name, c.String("license"), license.URL)
+	if err := os.WriteFile(filepath.Join(out, "LICENSE"), []byte(licenseText), 0o644); err != nil {
+		return err
+	}
+	schema := datasetSchema(counts, c.String("license"), time.Now().UTC())
+	if err := os.WriteFile(filepath.Join(out, "SCHEMA.md"), []byte(schema), 0o644); err != nil {
+		return err
+	}
+	logf(levelInfo, "Published dataset to %s", out)
+	return nil
+}
+
+// writeDatasetTable writes the rows of one dataset table to a CSV file.
+func writeDatasetTable(mysqlDB *sql.DB, path string, table datasetTable, key []byte) (int, error) {
+	rows, err := mysqlDB.Query(table.Query)
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s: %v", table.File, err)
+	}
+	defer rows.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	header := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		header[i] = col.Name
+	}
+	if err := w.Write(header); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	values := make([]sql.NullString, len(table.Columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(values))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, fmt.Errorf("error reading %s: %v", table.File, err)
+		}
+		for i, col := range table.Columns {
+			record[i] = datasetValue(col.Kind, values[i], key)
+		}
+		if err := w.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("error reading %s: %v", table.File, err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// datasetValue publishes one value according to its column kind. NULL is
+// published as an empty field.
+func datasetValue(kind string, v sql.NullString, key []byte) string {
+	if !v.Valid {
+		return ""
+	}
+	switch kind {
+	case "id":
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(v.String))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	case "date":
+		// DATETIME comes back as "2006-01-02 15:04:05" unless the DSN sets parseTime
+		if len(v.String) >= 10 {
+			return v.String[:10]
+		}
+	}
+	return v.String
+}
+
+// datasetSchema describes the files of the dataset for its readers.
+func datasetSchema(counts []int, license string, at time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# NetSocial research dataset\n\nGenerated %s, licensed under %s (see LICENSE).\n\n", at.Format("2006-01-02"), license)
+	b.WriteString("All files are UTF-8 CSV with a header row; empty fields are unknown or not applicable.\n")
+	b.WriteString("IDs are pseudonyms: 16 hex digits of a keyed hash of the platform ID. The same\n")
+	b.WriteString("account or post has the same pseudonym in every file, so files can be joined on\n")
+	b.WriteString("them, but pseudonyms can't be turned back into platform IDs. Times are cut to\n")
+	b.WriteString("the day. Names, contact details, profile texts, images, links and the text of\n")
+	b.WriteString("posts and comments are not included.\n")
+	for i, table := range datasetTables {
+		fmt.Fprintf(&b, "\n## %s\n\n%s %d row(s).\n\n| Column | Description |\n|---|---|\n", table.File, table.Description, counts[i])
+		for _, col := range table.Columns {
+			fmt.Fprintf(&b, "| `%s` | %s |\n", col.Name, col.Description)
+		}
+	}
+	return b.String()
+}
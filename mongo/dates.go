@@ -0,0 +1,52 @@
+package mongo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultDateLayouts are tried in order on dates stored as text when the
+// config lists no dateLayouts. Blog dates were typed in by hand, so both the
+// long and short month spellings appear, with and without a leading zero.
+var defaultDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"January 02, 2006",
+	"January 2, 2006",
+	"Jan 02, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+}
+
+// dateParser reads dates stored as text. Layouts without a zone are read in
+// loc. A nil *dateParser uses defaultDateLayouts in UTC.
+type dateParser struct {
+	layouts []string
+	loc     *time.Location
+}
+
+func newDateParser(layouts []string, timezone string) (*dateParser, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --default-timezone: %v", err)
+	}
+	return &dateParser{layouts: layouts, loc: loc}, nil
+}
+
+// parse returns the UTC time of the first layout s matches.
+func (p *dateParser) parse(s string) (time.Time, error) {
+	layouts, loc := defaultDateLayouts, time.UTC
+	if p != nil {
+		layouts, loc = p.layouts, p.loc
+	}
+	s = strings.TrimSpace(s)
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unparseable date %q", s)
+}
@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateParser(t *testing.T) {
+	berlin, err := newDateParser(defaultDateLayouts, "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		parser *dateParser
+		date   string
+		want   time.Time
+	}{
+		{nil, "2021-03-04T05:06:07.5Z", time.Date(2021, 3, 4, 5, 6, 7, 5e8, time.UTC)},
+		{nil, "2021-03-04T05:06:07+02:00", time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC)},
+		{nil, "2021-03-04T05:06:07", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{nil, "2021-03-04 05:06:07", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{nil, " 2021-03-04 ", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{nil, "March 04, 2021", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{nil, "March 4, 2021", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{nil, "Mar 04, 2021", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{nil, "Mar 4, 2021", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{nil, "4 March 2021", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		// Dates without a zone are in the parser's, with a zone in their own
+		{berlin, "2021-07-04 12:00:00", time.Date(2021, 7, 4, 10, 0, 0, 0, time.UTC)},
+		{berlin, "2021-01-04", time.Date(2021, 1, 3, 23, 0, 0, 0, time.UTC)},
+		{berlin, "2021-07-04T12:00:00Z", time.Date(2021, 7, 4, 12, 0, 0, 0, time.UTC)},
+		{&dateParser{layouts: []string{"02/01/2006"}, loc: time.UTC}, "04/03/2021", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := tt.parser.parse(tt.date)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.date, err)
+		} else if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("parse(%q) = %s, want %s", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestDateParserErrors(t *testing.T) {
+	tests := []struct {
+		parser *dateParser
+		date   string
+	}{
+		{nil, ""},
+		{nil, "yesterday"},
+		{nil, "2021-13-01"},
+		{nil, "04/03/2021"},
+		// Configured layouts replace the defaults
+		{&dateParser{layouts: []string{"02/01/2006"}, loc: time.UTC}, "2021-03-04"},
+	}
+	for _, tt := range tests {
+		if got, err := tt.parser.parse(tt.date); err == nil {
+			t.Errorf("parse(%q) = %s, want an error", tt.date, got)
+		}
+	}
+	if _, err := newDateParser(nil, "Nowhere/Special"); err == nil {
+		t.Error("newDateParser accepted an unknown timezone")
+	}
+}
@@ -0,0 +1,116 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// failedDocument is one line of a failed_<collection>.ndjson file.
+type failedDocument struct {
+	Error    string          `json:"error"`
+	Kind     failureKind     `json:"kind,omitempty"`
+	Document json.RawMessage `json:"document"`
+}
+
+// deadLetter collects documents that could not be transferred so the run can
+// carry on past them. Each collection gets its own failed_<collection>.ndjson
+// file in dir, created the first time one of its documents fails.
+type deadLetter struct {
+	dir    string
+	files  map[string]*os.File
+	counts map[string]int
+	// kinds are the categories of each collection's failures, in order
+	kinds map[string][]failureKind
+	// sizes are the bytes written to each file, for rewinding it
+	sizes map[string]int64
+}
+
+func newDeadLetter(dir string) *deadLetter {
+	return &deadLetter{dir: dir, files: map[string]*os.File{}, counts: map[string]int{}, kinds: map[string][]failureKind{}, sizes: map[string]int64{}}
+}
+
+func (d *deadLetter) path(collection string) string {
+	return filepath.Join(d.dir, "failed_"+collection+".ndjson")
+}
+
+// record appends doc and the error it failed with to the collection's file.
+func (d *deadLetter) record(collection string, doc json.RawMessage, cause error, kind failureKind) error {
+	f, ok := d.files[collection]
+	if !ok {
+		var err error
+		f, err = os.Create(d.path(collection))
+		if err != nil {
+			return fmt.Errorf("error creating dead-letter file: %v", err)
+		}
+		d.files[collection] = f
+	}
+	if len(doc) == 0 {
+		doc = json.RawMessage("null")
+	}
+	line, err := json.Marshal(failedDocument{Error: cause.Error(), Kind: kind, Document: doc})
+	if err != nil {
+		return err
+	}
+	d.counts[collection]++
+	d.kinds[collection] = append(d.kinds[collection], kind)
+	n, err := f.Write(append(line, '\n'))
+	d.sizes[collection] += int64(n)
+	return err
+}
+
+func (d *deadLetter) size(collection string) int64 {
+	return d.sizes[collection]
+}
+
+// rewind drops the documents recorded after the collection had count of them
+// in size bytes, when the transaction they failed in is rolled back and they
+// will be read again.
+func (d *deadLetter) rewind(collection string, count int, size int64) error {
+	d.counts[collection] = count
+	if len(d.kinds[collection]) > count {
+		d.kinds[collection] = d.kinds[collection][:count]
+	}
+	f, ok := d.files[collection]
+	if !ok || d.sizes[collection] == size {
+		return nil
+	}
+	d.sizes[collection] = size
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("error rewinding %s: %v", d.path(collection), err)
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return fmt.Errorf("error rewinding %s: %v", d.path(collection), err)
+	}
+	return nil
+}
+
+// kindCounts returns the number of failed documents of the collection by category.
+func (d *deadLetter) kindCounts(collection string) map[failureKind]int {
+	counts := map[failureKind]int{}
+	for _, kind := range d.kinds[collection] {
+		counts[kind]++
+	}
+	return counts
+}
+
+// total returns the number of failed documents across all collections.
+func (d *deadLetter) total() int {
+	n := 0
+	for _, c := range d.counts {
+		n += c
+	}
+	return n
+}
+
+func (d *deadLetter) Close() error {
+	var firstErr error
+	for _, f := range d.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
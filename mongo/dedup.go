@@ -0,0 +1,230 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errSkipped is returned by a transfer function for documents that are
+// deliberately left out of the target, such as merged duplicate users.
+var errSkipped = errors.New("skipped")
+
+// userAliases maps the ID of every merged-away user to the user that survived.
+type userAliases map[string]string
+
+// resolve returns the surviving user ID for id.
+func (a userAliases) resolve(id string) string {
+	if survivor, ok := a[id]; ok {
+		return survivor
+	}
+	return id
+}
+
+// userRules are the priority rules --dedupe-emails accepts. Each one reports
+// whether a should survive over b; rules are tried in order until one decides.
+var userRules = map[string]func(a, b *User) (better, decided bool){
+	"verified": func(a, b *User) (bool, bool) {
+		return a.IsVerified, a.IsVerified != b.IsVerified
+	},
+	"oldest": func(a, b *User) (bool, bool) {
+		return a.CreatedAt.Before(b.CreatedAt), !a.CreatedAt.Equal(b.CreatedAt)
+	},
+	"newest": func(a, b *User) (bool, bool) {
+		return a.CreatedAt.After(b.CreatedAt), !a.CreatedAt.Equal(b.CreatedAt)
+	},
+}
+
+func parseUserRules(list string) ([]string, error) {
+	var rules []string
+	for _, rule := range strings.Split(list, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if _, ok := userRules[rule]; !ok {
+			return nil, fmt.Errorf("unknown duplicate-email rule %q (known: verified, oldest, newest)", rule)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// userKeys are the fields --dedupe-by can match duplicate users on. Both are
+// compared case-insensitively.
+var userKeys = map[string]func(u *User) string{
+	"email":    func(u *User) string { return u.Email },
+	"username": func(u *User) string { return u.Username },
+}
+
+func parseUserKeys(list string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if _, ok := userKeys[key]; !ok {
+			return nil, fmt.Errorf("unknown --dedupe-by field %q (known: email, username)", key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("--dedupe-by needs at least one of email, username")
+	}
+	return keys, nil
+}
+
+// userMerge is one line of the merged-users report: the accounts folded into
+// the survivor and the values they shared.
+type userMerge struct {
+	Survivor string            `json:"survivor"`
+	Merged   []string          `json:"merged"`
+	Matched  map[string]string `json:"matched"`
+}
+
+// resolveDuplicateUsers reads every user up front and groups the ones sharing
+// a value of any of keys, so a user with the email of one and the username of
+// another joins both in one group. One survivor per group is picked using
+// rules; the other users of a group are merged into it: they are not migrated
+// and their posts are attributed to the survivor. Every merge is written to
+// the report at path.
+func resolveDuplicateUsers(ctx context.Context, source documentSource, rules, keys []string, path string) (userAliases, error) {
+	cursor, err := source.Open(ctx, "users", readOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*User
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			// Undecodable users end up in the dead-letter file during the migration itself
+			continue
+		}
+		users = append(users, &user)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error reading users: %v", err)
+	}
+
+	// parent links each user to another of its group, the root of which
+	// stands for the whole group
+	parent := make([]int, len(users))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for _, key := range keys {
+		first := map[string]int{}
+		for i, user := range users {
+			value := strings.ToLower(strings.TrimSpace(userKeys[key](user)))
+			if value == "" {
+				continue
+			}
+			if j, ok := first[value]; ok {
+				parent[root(i)] = root(j)
+			} else {
+				first[value] = i
+			}
+		}
+	}
+	groups := map[int][]*User{}
+	var roots []int
+	for i, user := range users {
+		r := root(i)
+		if len(groups[r]) == 0 {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], user)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating merged users file: %v", err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+
+	aliases := userAliases{}
+	for _, r := range roots {
+		group := groups[r]
+		if len(group) < 2 {
+			continue
+		}
+		survivor := group[0]
+		for _, candidate := range group[1:] {
+			if preferUser(candidate, survivor, rules) {
+				survivor = candidate
+			}
+		}
+		merge := userMerge{Survivor: string(survivor.ID), Matched: sharedUserKeys(group, keys)}
+		for _, user := range group {
+			if user != survivor {
+				aliases[string(user.ID)] = string(survivor.ID)
+				merge.Merged = append(merge.Merged, string(user.ID))
+			}
+		}
+		if err := enc.Encode(merge); err != nil {
+			return nil, fmt.Errorf("error writing %s: %v", path, err)
+		}
+		matched := make([]string, 0, len(merge.Matched))
+		for _, key := range keys {
+			if value, ok := merge.Matched[key]; ok {
+				matched = append(matched, key+" "+value)
+			}
+		}
+		logf(levelInfo, "Duplicate %s: keeping user %s, merging %s", strings.Join(matched, ", "), survivor.ID, strings.Join(merge.Merged, ", "))
+	}
+	if len(aliases) > 0 {
+		logf(levelInfo, "%d duplicate user(s) merged, see %s", len(aliases), path)
+	}
+	return aliases, nil
+}
+
+// sharedUserKeys returns, for each of keys, a value more than one user of
+// group has.
+func sharedUserKeys(group []*User, keys []string) map[string]string {
+	shared := map[string]string{}
+	for _, key := range keys {
+		seen := map[string]bool{}
+		var values []string
+		for _, user := range group {
+			value := strings.ToLower(strings.TrimSpace(userKeys[key](user)))
+			if value == "" {
+				continue
+			}
+			if seen[value] {
+				values = append(values, value)
+			}
+			seen[value] = true
+		}
+		if len(values) > 0 {
+			sort.Strings(values)
+			shared[key] = values[0]
+		}
+	}
+	return shared
+}
+
+// preferUser reports whether a should survive over b. When no rule decides,
+// the user seen first is kept.
+func preferUser(a, b *User, rules []string) bool {
+	for _, rule := range rules {
+		if better, decided := userRules[rule](a, b); decided {
+			return better
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package mongo
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dedupUsers are the users the duplicate tests read: alice signed up twice
+// with one email, the second account sharing its username with a third, and
+// two accounts without an email.
+var dedupUsers = []string{
+	`{"_id": "u1", "email": "alice@example.com", "username": "alice", "createdAt": {"$date": "2020-01-01T00:00:00Z"}}`,
+	`{"_id": "u2", "email": " Alice@Example.com", "username": "bob", "createdAt": {"$date": "2021-01-01T00:00:00Z"}}`,
+	`{"_id": "u3", "email": "bob@example.com", "username": "BOB", "createdAt": {"$date": "2022-01-01T00:00:00Z"}}`,
+	`{"_id": "u4", "email": "", "username": "carol", "createdAt": {"$date": "2020-06-01T00:00:00Z"}}`,
+	`{"_id": "u5", "email": "", "username": "dave", "createdAt": {"$date": "2020-07-01T00:00:00Z"}}`,
+}
+
+func TestResolveDuplicateUsers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "users.ndjson"), []byte(strings.Join(dedupUsers, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := newDirSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		rules   []string
+		keys    []string
+		want    userAliases
+		merges  int
+		matched string
+	}{
+		{
+			name:  "email",
+			rules: []string{"oldest"},
+			keys:  []string{"email"},
+			want:  userAliases{"u2": "u1"},
+			// Emails are compared trimmed and in lower case
+			merges:  1,
+			matched: `"matched":{"email":"alice@example.com"}`,
+		},
+		{
+			name:    "username",
+			rules:   []string{"oldest"},
+			keys:    []string{"username"},
+			want:    userAliases{"u3": "u2"},
+			merges:  1,
+			matched: `"matched":{"username":"bob"}`,
+		},
+		{
+			name:    "email and username join transitively",
+			rules:   []string{"oldest"},
+			keys:    []string{"email", "username"},
+			want:    userAliases{"u2": "u1", "u3": "u1"},
+			merges:  1,
+			matched: `"matched":{"email":"alice@example.com","username":"bob"}`,
+		},
+		{
+			name:   "newest survives",
+			rules:  []string{"newest"},
+			keys:   []string{"email", "username"},
+			want:   userAliases{"u1": "u3", "u2": "u3"},
+			merges: 1,
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".ndjson")
+		got, err := resolveDuplicateUsers(context.Background(), source, tt.rules, tt.keys, path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: aliases = %v, want %v", tt.name, got, tt.want)
+		}
+		lines := reportLines(t, path)
+		if len(lines) != tt.merges {
+			t.Errorf("%s: %d merge(s) reported, want %d: %v", tt.name, len(lines), tt.merges, lines)
+		} else if tt.matched != "" && !strings.Contains(lines[0], tt.matched) {
+			t.Errorf("%s: reported %s, want it to contain %s", tt.name, lines[0], tt.matched)
+		}
+	}
+}
+
+func reportLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestUserAliasesResolve(t *testing.T) {
+	aliases := userAliases{"u2": "u1"}
+	tests := []struct{ id, want string }{
+		{"u2", "u1"},
+		{"u1", "u1"},
+		{"u9", "u9"},
+	}
+	for _, tt := range tests {
+		if got := aliases.resolve(tt.id); got != tt.want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package mongo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var deliverCommand = &cli.Command{
+	Name:  "deliver",
+	Usage: "POST an export to a webhook, signed with HMAC-SHA256",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "path",
+			Usage: "export file or directory to deliver (directories are sent as .tar.gz)",
+		},
+		&cli.StringFlag{
+			Name:  "webhook",
+			Usage: "URL the export is POSTed to",
+		},
+		&cli.StringFlag{
+			Name:    "secret",
+			EnvVars: []string{"EXPORT_WEBHOOK_SECRET"},
+			Usage:   "shared secret used to sign the delivery",
+		},
+		&cli.DurationFlag{
+			Name:  "expires",
+			Value: 72 * time.Hour,
+			Usage: "how long the receiver should accept the delivery for",
+		},
+	},
+	Action: deliverExport,
+}
+
+// Headers sent with every delivery. The signature covers
+// "<timestamp>.<expires>.<body>" so neither the body nor the expiry can be
+// altered, and receivers should reject deliveries past X-NetSocial-Expires.
+const (
+	timestampHeader = "X-NetSocial-Timestamp"
+	expiresHeader   = "X-NetSocial-Expires"
+	signatureHeader = "X-NetSocial-Signature"
+)
+
+func deliverExport(c *cli.Context) error {
+	path, webhook, secret := c.String("path"), c.String("webhook"), c.String("secret")
+	if path == "" || webhook == "" {
+		return fmt.Errorf("--path and --webhook are required")
+	}
+	if secret == "" {
+		return fmt.Errorf("--secret or EXPORT_WEBHOOK_SECRET is required")
+	}
+
+	body, contentType, err := packageExport(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	expires := strconv.FormatInt(now.Add(c.Duration("expires")).Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", deliveryName(path)))
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(expiresHeader, expires)
+	req.Header.Set(signatureHeader, "sha256="+signDelivery(secret, timestamp, expires, body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error delivering export: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error delivering export: webhook answered %s", resp.Status)
+	}
+
+	logf(levelInfo, "Delivered %s (%d bytes) to %s", deliveryName(path), len(body), webhook)
+	return nil
+}
+
+// signDelivery returns the hex HMAC-SHA256 of "<timestamp>.<expires>.<body>".
+func signDelivery(secret, timestamp, expires string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + expires + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliveryName(path string) string {
+	name := filepath.Base(filepath.Clean(path))
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		name += ".tar.gz"
+	}
+	return name
+}
+
+// packageExport reads a single export file as is, or tars and gzips a directory.
+func packageExport(path string) ([]byte, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		return data, "application/octet-stream", err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "application/gzip", nil
+}
@@ -0,0 +1,524 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// diffKeys are the columns rows of the built-in tables are matched on, the
+// natural keys the migration upserts them by.
+var diffKeys = map[string][]string{
+	"posts":        {"id"},
+	"users":        {"id"},
+	"comments":     {"id"},
+	"post_heart":   {"post_id", "user_id"},
+	"user_links":   {"user_id", "position"},
+	"partners":     {"title"},
+	"blogs":        {"slug"},
+	"blog_entries": {"blog_slug", "position"},
+}
+
+var diffCommand = &cli.Command{
+	Name:  "diff",
+	Usage: "Compare the MongoDB collections with the migrated MySQL tables row by row",
+	Flags: withFlags(mongoFlags, mysqlFlags, sourceFlags, retryFlags, normalizeFlags, dateFlags, rewriteFlags, filterFlags, []cli.Flag{
+		&cli.StringFlag{
+			Name:  "collections",
+			Usage: "comma separated list of collections to compare (default: all)",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "comma separated table=column[+column] keys replacing the defaults, e.g. users=email",
+		},
+		&cli.StringFlag{
+			Name:  "ignore-columns",
+			Value: "users.password",
+			Usage: "comma separated table.column list left out of the comparison",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "report format: table or json",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "file the report is written to (default: stdout)",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Value: 20,
+			Usage: "rows listed per table and kind of difference in the table format, 0 for all",
+		},
+	}),
+	Action:       diffCollections,
+	BashComplete: completeCollections(true, "collections"),
+}
+
+// diffReport is what diff found, table by table. Keys are the key columns'
+// values joined with "/".
+type diffReport struct {
+	GeneratedAt time.Time    `json:"generatedAt"`
+	Tables      []*tableDiff `json:"tables"`
+}
+
+type tableDiff struct {
+	Table       string    `json:"table"`
+	Key         []string  `json:"key"`
+	MongoRows   int       `json:"mongoRows"`
+	MySQLRows   int       `json:"mysqlRows"`
+	Same        int       `json:"same"`
+	OnlyInMongo []string  `json:"onlyInMongo"`
+	OnlyInMySQL []string  `json:"onlyInMySQL"`
+	Differing   []rowDiff `json:"differing"`
+
+	columns []string
+	json    map[string]bool
+	// rows holds the MongoDB side's values by key until MySQL's rows are matched with them
+	rows map[string][][]*string
+}
+
+type rowDiff struct {
+	Key     string       `json:"key"`
+	Columns []columnDiff `json:"columns"`
+}
+
+// columnDiff holds both sides of a differing column, nil for NULL.
+type columnDiff struct {
+	Column string  `json:"column"`
+	Mongo  *string `json:"mongo"`
+	MySQL  *string `json:"mysql"`
+}
+
+func (t *tableDiff) differences() int {
+	return len(t.OnlyInMongo) + len(t.OnlyInMySQL) + len(t.Differing)
+}
+
+func diffCollections(c *cli.Context) error {
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown --format %q, expected table or json", format)
+	}
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	selected, err := selectCollections(cfg.migrations(), c.String("collections"), "")
+	if err != nil {
+		return err
+	}
+	filters, err := collectionFilters(cfg, selected, c.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+	projections := collectionProjections(cfg, selected)
+	dates, err := newDateParser(cfg.dateLayouts(), c.String("default-timezone"))
+	if err != nil {
+		return err
+	}
+	keys, err := diffTableKeys(cfg, c.String("key"))
+	if err != nil {
+		return err
+	}
+	ignored := map[string]bool{}
+	for _, col := range strings.Split(c.String("ignore-columns"), ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			ignored[col] = true
+		}
+	}
+
+	retry := newRetrier(c.Context, c.Int("max-retries"), c.Duration("retry-delay"))
+	source, err := openSource(c, retry)
+	if err != nil {
+		return err
+	}
+	defer source.Close(context.TODO())
+	mysqlDB := connectMySQL(c.String("mysql-uri"), retry)
+	defer mysqlDB.Close()
+
+	// Reports go nowhere: diff only needs the rewritten values
+	var ips *ipScrubber
+	if policy := c.String("scrub-ips"); policy != "" {
+		if ips, err = newIPScrubber(policy, os.DevNull); err != nil {
+			return err
+		}
+		defer ips.Close()
+	}
+	var store *objectStore
+	if c.Bool("upload-media") {
+		if store = cfg.MediaStore; store == nil {
+			return fmt.Errorf("--upload-media needs a mediaStore in the config file")
+		}
+	}
+	media, err := newMediaAuditor(cfg.MediaHosts, store, false, 1, 0, 0, os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer media.Close()
+	media.offline = true
+	ids, err := loadIDMap(mysqlDB, retry)
+	if err != nil {
+		return err
+	}
+	ids.frozen = true
+
+	// Rows are built exactly as the migration builds them
+	run := &migrator{
+		comments:   c.Bool("normalize-comments"),
+		hearts:     c.Bool("normalize-hearts"),
+		links:      c.Bool("normalize-links"),
+		ips:        ips,
+		media:      media,
+		ids:        ids,
+		dates:      dates,
+		transforms: cfg.Transforms,
+	}
+	report := &diffReport{GeneratedAt: time.Now().UTC()}
+	tables := map[string]*tableDiff{}
+	for _, cm := range selected {
+		cursor, err := source.Open(c.Context, cm.Name, readOptions{Filter: filters[cm.Name], Projection: projections[cm.Name]})
+		if err != nil {
+			return err
+		}
+		for cursor.Next(c.Context) {
+			// Nothing is counted or reported, so the ledger is dropped
+			rows, err := run.prepareRows(cm, cursor, &ledger{})
+			if errors.Is(err, errSkipped) {
+				continue
+			}
+			if err != nil {
+				logf(levelWarn, "Skipping %s document: %v", cm.Name, err)
+				continue
+			}
+			for _, row := range rows {
+				t, ok := tables[row.Table]
+				if !ok {
+					if t, err = newTableDiff(row, keys[row.Table], ignored); err != nil {
+						return err
+					}
+					tables[row.Table] = t
+					report.Tables = append(report.Tables, t)
+				}
+				if err := t.addMongo(row); err != nil {
+					return err
+				}
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("error reading %s: %v", cm.Name, err)
+		}
+		cursor.Close(context.TODO())
+	}
+	for _, t := range report.Tables {
+		if err := t.matchMySQL(mysqlDB); err != nil {
+			return err
+		}
+		logf(levelInfo, "%s: %d row(s) in MongoDB, %d in MySQL, %d difference(s)", t.Table, t.MongoRows, t.MySQLRows, t.differences())
+	}
+
+	out := io.Writer(os.Stdout)
+	if path := c.String("out"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeDiffTable(out, report, c.Int("limit"))
+	}
+	if err != nil {
+		return fmt.Errorf("error writing diff report: %v", err)
+	}
+
+	differences := 0
+	for _, t := range report.Tables {
+		differences += t.differences()
+	}
+	if differences > 0 {
+		return cli.Exit(fmt.Sprintf("%d difference(s) between MongoDB and MySQL", differences), 1)
+	}
+	return nil
+}
+
+// diffTableKeys returns the key columns of every table: the built-in keys,
+// those of the config's mappings and then the --key overrides.
+func diffTableKeys(cfg *config, overrides string) (map[string][]string, error) {
+	keys := map[string][]string{}
+	for table, key := range diffKeys {
+		keys[table] = key
+	}
+	for _, mapping := range cfg.Mappings {
+		var key []string
+		for _, f := range mapping.Fields {
+			if f.PrimaryKey {
+				key = append(key, f.Column)
+			}
+		}
+		if len(key) > 0 {
+			keys[mapping.Table] = key
+		}
+	}
+	for _, entry := range strings.Split(overrides, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		table, columns, ok := strings.Cut(entry, "=")
+		if !ok || !identifierPattern.MatchString(table) {
+			return nil, fmt.Errorf("invalid --key %q, expected table=column", entry)
+		}
+		key := strings.Split(columns, "+")
+		for _, col := range key {
+			if !identifierPattern.MatchString(col) {
+				return nil, fmt.Errorf("invalid --key %q, expected table=column", entry)
+			}
+		}
+		keys[table] = key
+	}
+	return keys, nil
+}
+
+func newTableDiff(row tableRow, key []string, ignored map[string]bool) (*tableDiff, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("no key to compare %s on, pass --key %s=column", row.Table, row.Table)
+	}
+	t := &tableDiff{Table: row.Table, Key: key, json: map[string]bool{}, rows: map[string][][]*string{}}
+	for i, col := range row.Columns {
+		if ignored[row.Table+"."+col] {
+			continue
+		}
+		if _, ok := row.Values[i].(jsonValue); ok {
+			t.json[col] = true
+		}
+		t.columns = append(t.columns, col)
+	}
+	for _, col := range key {
+		if t.index(col) < 0 {
+			return nil, fmt.Errorf("key column %s.%s isn't migrated", row.Table, col)
+		}
+	}
+	return t, nil
+}
+
+func (t *tableDiff) index(column string) int {
+	for i, col := range t.columns {
+		if col == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// key joins the key columns' values of one side's row.
+func (t *tableDiff) key(values []*string) string {
+	parts := make([]string, len(t.Key))
+	for i, col := range t.Key {
+		if v := values[t.index(col)]; v != nil {
+			parts[i] = *v
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (t *tableDiff) addMongo(row tableRow) error {
+	values := make([]*string, len(t.columns))
+	for i, col := range row.Columns {
+		j := t.index(col)
+		if j < 0 {
+			continue
+		}
+		v, err := diffValue(row.Values[i], t.json[col])
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Table, col, err)
+		}
+		values[j] = v
+	}
+	key := t.key(values)
+	t.rows[key] = append(t.rows[key], values)
+	t.MongoRows++
+	return nil
+}
+
+// matchMySQL reads the table back and sorts its rows into same, differing and
+// only in MySQL; the MongoDB rows left unmatched are only in MongoDB. Rows
+// sharing a key are matched in the order both sides list them.
+func (t *tableDiff) matchMySQL(mysqlDB *sql.DB) error {
+	columns := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		columns[i] = "`" + col + "`"
+	}
+	rows, err := mysqlDB.Query(fmt.Sprintf("SELECT %s FROM `%s`", strings.Join(columns, ", "), t.Table))
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", t.Table, err)
+	}
+	defer rows.Close()
+	raw := make([]interface{}, len(t.columns))
+	dest := make([]interface{}, len(t.columns))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("error reading %s: %v", t.Table, err)
+		}
+		values := make([]*string, len(raw))
+		for i, v := range raw {
+			if values[i], err = diffValue(v, t.json[t.columns[i]]); err != nil {
+				return fmt.Errorf("%s.%s: %v", t.Table, t.columns[i], err)
+			}
+		}
+		t.MySQLRows++
+
+		key := t.key(values)
+		pending := t.rows[key]
+		if len(pending) == 0 {
+			t.OnlyInMySQL = append(t.OnlyInMySQL, key)
+			continue
+		}
+		mongo := pending[0]
+		if len(pending) == 1 {
+			delete(t.rows, key)
+		} else {
+			t.rows[key] = pending[1:]
+		}
+		var differing []columnDiff
+		for i, col := range t.columns {
+			if !sameValue(mongo[i], values[i]) {
+				differing = append(differing, columnDiff{Column: col, Mongo: mongo[i], MySQL: values[i]})
+			}
+		}
+		if len(differing) == 0 {
+			t.Same++
+		} else {
+			t.Differing = append(t.Differing, rowDiff{Key: key, Columns: differing})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", t.Table, err)
+	}
+	for key, pending := range t.rows {
+		for range pending {
+			t.OnlyInMongo = append(t.OnlyInMongo, key)
+		}
+	}
+	t.rows = nil
+	sort.Strings(t.OnlyInMongo)
+	sort.Strings(t.OnlyInMySQL)
+	sort.Slice(t.Differing, func(i, j int) bool { return t.Differing[i].Key < t.Differing[j].Key })
+	return nil
+}
+
+// diffValue renders a value of either side as the text MySQL stores, nil for
+// NULL: timestamps to the second, booleans as 1 or 0 and JSON re-encoded so
+// key order and spacing don't count.
+func diffValue(v interface{}, isJSON bool) (*string, error) {
+	if t, ok := v.(time.Time); ok {
+		s := t.UTC().Round(time.Second).Format("2006-01-02 15:04:05")
+		return &s, nil
+	}
+	v, err := exportValue(v)
+	if err != nil {
+		return nil, err
+	}
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case bool:
+		s = "0"
+		if v {
+			s = "1"
+		}
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if isJSON {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(s), &parsed); err == nil {
+			data, _ := json.Marshal(parsed)
+			s = string(data)
+		}
+	}
+	return &s, nil
+}
+
+func sameValue(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// writeDiffTable writes the report as a summary table followed by up to limit
+// rows of every kind of difference per table.
+func writeDiffTable(out io.Writer, report *diffReport, limit int) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tMONGODB\tMYSQL\tSAME\tONLY MONGODB\tONLY MYSQL\tDIFFERING")
+	for _, t := range report.Tables {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", t.Table, t.MongoRows, t.MySQLRows, t.Same, len(t.OnlyInMongo), len(t.OnlyInMySQL), len(t.Differing))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, t := range report.Tables {
+		key := strings.Join(t.Key, "/")
+		for i, k := range t.OnlyInMongo {
+			if limit > 0 && i == limit {
+				fmt.Fprintf(out, "%s: %d more only in MongoDB\n", t.Table, len(t.OnlyInMongo)-limit)
+				break
+			}
+			fmt.Fprintf(out, "%s %s=%s: only in MongoDB\n", t.Table, key, k)
+		}
+		for i, k := range t.OnlyInMySQL {
+			if limit > 0 && i == limit {
+				fmt.Fprintf(out, "%s: %d more only in MySQL\n", t.Table, len(t.OnlyInMySQL)-limit)
+				break
+			}
+			fmt.Fprintf(out, "%s %s=%s: only in MySQL\n", t.Table, key, k)
+		}
+		for i, d := range t.Differing {
+			if limit > 0 && i == limit {
+				fmt.Fprintf(out, "%s: %d more differing\n", t.Table, len(t.Differing)-limit)
+				break
+			}
+			parts := make([]string, len(d.Columns))
+			for j, col := range d.Columns {
+				parts[j] = fmt.Sprintf("%s %s -> %s", col.Column, diffText(col.Mongo), diffText(col.MySQL))
+			}
+			fmt.Fprintf(out, "%s %s=%s: %s\n", t.Table, key, d.Key, strings.Join(parts, ", "))
+		}
+	}
+	return nil
+}
+
+// diffText quotes a value for the table format, shortening long text.
+func diffText(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	return strconv.Quote(truncate(*v, 60))
+}
@@ -0,0 +1,59 @@
+package mongo
+
+import (
+	"fmt"
+	"time"
+)
+
+// Discord embed colours and limits.
+const (
+	discordGreen       = 0x2ecc71
+	discordRed         = 0xe74c3c
+	discordMaxDescribe = 4096
+	discordMaxField    = 1024
+)
+
+// discordNotifier posts a summary embed to a Discord webhook, so ops hear how
+// a migration went without watching the terminal.
+type discordNotifier struct {
+	webhook string
+}
+
+type discordMessage struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+func (d *discordNotifier) notify(e runEvent) error {
+	migrated, failed := e.totals()
+	embed := discordEmbed{
+		Title:       e.title(),
+		Description: truncate(e.summary("**"), discordMaxDescribe),
+		Color:       discordGreen,
+		Fields: []discordField{
+			{Name: "Duration", Value: time.Since(e.Started).Round(time.Second).String(), Inline: true},
+			{Name: "Migrated", Value: fmt.Sprint(migrated), Inline: true},
+			{Name: "Failed", Value: fmt.Sprint(failed), Inline: true},
+		},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if e.Err != nil {
+		embed.Color = discordRed
+		embed.Fields = append(embed.Fields, discordField{Name: "Error", Value: truncate(e.Err.Error(), discordMaxField)})
+	}
+	return postJSON(d.webhook, discordMessage{Username: "cli-tools", Embeds: []discordEmbed{embed}})
+}
@@ -0,0 +1,149 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// doctorProbeTable is created and dropped again to check the MySQL user's privileges.
+const doctorProbeTable = "cli_tools_doctor_probe"
+
+var doctorCommand = &cli.Command{
+	Name:  "doctor",
+	Usage: "Check the config, both connections and the MySQL privileges a migration needs",
+	Flags: withFlags(mongoFlags, mysqlFlags, []cli.Flag{
+		&cli.DurationFlag{Name: "timeout", Value: 10 * time.Second, Usage: "how long each connection may take"},
+	}),
+	Action: doctor,
+}
+
+// checkup counts the failed checks of a doctor run.
+type checkup struct {
+	failed int
+}
+
+// check logs the outcome of one check and reports whether it passed.
+func (u *checkup) check(name string, err error) bool {
+	if err != nil {
+		u.failed++
+		logf(levelError, "FAIL %s: %v", name, err)
+		return false
+	}
+	logf(levelInfo, "ok   %s", name)
+	return true
+}
+
+func doctor(c *cli.Context) error {
+	u := &checkup{}
+	cfg, err := loadConfig(c.String("config"))
+	u.check("config", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Duration("timeout"))
+	defer cancel()
+	if u.check("MongoDB URI", requireSet("--mongodb-uri or MONGODB_URI", c.String("mongodb-uri"))) {
+		source, err := newMongoSource(ctx, c.String("mongodb-uri"), nil)
+		if u.check("MongoDB connection", err) {
+			defer source.Close(context.Background())
+			names, err := source.db.ListCollectionNames(ctx, bson.D{})
+			if u.check("MongoDB collections listed", err) && cfg != nil {
+				present := map[string]bool{}
+				for _, name := range names {
+					present[name] = true
+				}
+				for _, cm := range cfg.migrations() {
+					var missing error
+					if !present[cm.Name] {
+						missing = fmt.Errorf("not found in SocialFlux")
+					}
+					u.check("collection "+cm.Name, missing)
+				}
+			}
+		}
+	}
+
+	uri := c.String("mysql-uri")
+	ctx, cancel = context.WithTimeout(context.Background(), c.Duration("timeout"))
+	defer cancel()
+	if u.check("MySQL URI", mysqlURIError(uri)) {
+		mysqlDB, err := sqlOpen(uri)
+		if err == nil {
+			defer mysqlDB.Close()
+			err = mysqlDB.PingContext(ctx)
+		}
+		if u.check("MySQL connection", err) {
+			checkPrivileges(u, mysqlDB)
+			checkSchema(u, mysqlDB)
+		}
+	}
+
+	if u.failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d check(s) failed, fix them before migrating", u.failed), 1)
+	}
+	logf(levelInfo, "Ready to migrate")
+	return nil
+}
+
+func requireSet(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is not set", name)
+	}
+	return nil
+}
+
+// mysqlURIError checks that uri is a DSN naming the target database.
+func mysqlURIError(uri string) error {
+	if err := requireSet("--mysql-uri or MYSQL_URI", uri); err != nil {
+		return err
+	}
+	cfg, err := mysql.ParseDSN(uri)
+	if err != nil {
+		return err
+	}
+	if cfg.DBName == "" {
+		return fmt.Errorf("no database name")
+	}
+	return nil
+}
+
+// checkPrivileges creates, fills, indexes and drops a probe table, which is
+// what the schema migrations, inserts and index builds of a migration need.
+func checkPrivileges(u *checkup, mysqlDB *sql.DB) {
+	// A probe table left behind by an interrupted run would fail the CREATE
+	mysqlDB.Exec("DROP TABLE IF EXISTS " + doctorProbeTable)
+	_, err := mysqlDB.Exec("CREATE TABLE " + doctorProbeTable + " (id INT NOT NULL PRIMARY KEY, name VARCHAR(64))")
+	if !u.check("MySQL CREATE TABLE", err) {
+		return
+	}
+	_, err = mysqlDB.Exec("INSERT INTO "+doctorProbeTable+" (id, name) VALUES (?, ?)", 1, "probe")
+	u.check("MySQL INSERT", err)
+	_, err = mysqlDB.Exec("CREATE INDEX " + doctorProbeTable + "_name ON " + doctorProbeTable + " (name)")
+	u.check("MySQL CREATE INDEX", err)
+	_, err = mysqlDB.Exec("DROP TABLE " + doctorProbeTable)
+	u.check("MySQL DROP TABLE", err)
+}
+
+// checkSchema reports how many schema migrations the next run will apply.
+func checkSchema(u *checkup, mysqlDB *sql.DB) {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		u.check("schema", err)
+		return
+	}
+	applied, err := appliedVersions(mysqlDB)
+	if !u.check("schema", err) {
+		return
+	}
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	logf(levelInfo, "%d of %d schema migration(s) pending, migrate applies them first", pending, len(migrations))
+}
@@ -0,0 +1,259 @@
+package mongo
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongodump writes BSON documents back to back, each starting with its
+// length. Archives also hold terminators in place of a length, ending the
+// prelude and each block of a collection's documents.
+const (
+	// archiveMagic starts every mongodump --archive file.
+	archiveMagic = 0x8199e26d
+	// bsonTerminator is read where a length would end a sequence.
+	bsonTerminator = 0xffffffff
+	// maxDumpDocument is far above MongoDB's 16 MiB document limit, but
+	// keeps a corrupt length from allocating gigabytes
+	maxDumpDocument = 64 << 20
+)
+
+// readDumpDocument reads one BSON document from r, or reports a terminator.
+// A clean end of r before a document is io.EOF.
+func readDumpDocument(r io.Reader) (doc []byte, terminator bool, err error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated BSON document")
+		}
+		return nil, false, err
+	}
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length == bsonTerminator {
+		return nil, true, nil
+	}
+	if length < 5 || length > maxDumpDocument {
+		return nil, false, fmt.Errorf("invalid BSON document length %d", length)
+	}
+	doc = make([]byte, length)
+	copy(doc, prefix[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		return nil, false, fmt.Errorf("truncated BSON document")
+	}
+	return doc, false, nil
+}
+
+// openDumpFile opens a mongodump file, unpacking it when it was written with
+// --gzip, whatever its name.
+func openDumpFile(path string) (io.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(f)
+	if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("error unpacking %s: %v", path, err)
+		}
+		return bufio.NewReader(z), f, nil
+	}
+	return r, f, nil
+}
+
+// bsonCursor walks BSON documents produced by next, which returns io.EOF
+// after the last one.
+type bsonCursor struct {
+	next   func() ([]byte, error)
+	closer io.Closer
+	doc    []byte
+	err    error
+}
+
+// openBSONFile opens a <collection>.bson file of a mongodump directory.
+func openBSONFile(path string) (*bsonCursor, error) {
+	r, closer, err := openDumpFile(path)
+	if err != nil {
+		return nil, err
+	}
+	next := func() ([]byte, error) {
+		doc, terminator, err := readDumpDocument(r)
+		if terminator {
+			return nil, fmt.Errorf("unexpected terminator in %s", path)
+		}
+		return doc, err
+	}
+	return &bsonCursor{next: next, closer: closer}, nil
+}
+
+func (c *bsonCursor) Next(ctx context.Context) bool {
+	if c.err != nil || ctx.Err() != nil {
+		return false
+	}
+	doc, err := c.next()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			c.err = err
+		}
+		return false
+	}
+	c.doc = doc
+	return true
+}
+
+func (c *bsonCursor) Decode(v interface{}) error {
+	return bson.Unmarshal(c.doc, v)
+}
+
+func (c *bsonCursor) Raw() json.RawMessage {
+	data, err := bson.MarshalExtJSON(bson.Raw(c.doc), false, false)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Detach needs no copy: every document is read into a buffer of its own.
+func (c *bsonCursor) Detach() documentCursor {
+	return &document{data: c.doc, unmarshal: bson.Unmarshal, bson: true}
+}
+
+func (c *bsonCursor) Err() error {
+	return c.err
+}
+
+func (c *bsonCursor) Close(ctx context.Context) error {
+	return c.closer.Close()
+}
+
+// archiveSource reads collections from a mongodump --archive file, plain or
+// gzipped, so backups can be migrated without access to the cluster. The
+// archive interleaves blocks of every collection, so each collection opened
+// reads through the whole file and keeps its own blocks.
+type archiveSource struct {
+	path string
+}
+
+// archiveNamespace is the header of a block of an archive's body, or one
+// collection's entry in its prelude.
+type archiveNamespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+}
+
+func newArchiveSource(path string) (*archiveSource, error) {
+	if path == "" {
+		return nil, fmt.Errorf("--source archive needs --source-archive")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("error opening --source-archive: %v", err)
+	}
+	return &archiveSource{path: path}, nil
+}
+
+func (s *archiveSource) Open(ctx context.Context, collection string, opts readOptions) (documentCursor, error) {
+	if len(opts.Sort) > 0 {
+		return nil, fmt.Errorf("the archive source can't read %s sorted", collection)
+	}
+	if opts.Filter != nil || opts.Projection != nil {
+		return nil, fmt.Errorf("the archive source can't filter or project %s", collection)
+	}
+	r, closer, err := openDumpFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := readArchivePrelude(r)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("error reading %s: %v", s.path, err)
+	}
+	found := false
+	for _, ns := range namespaces {
+		found = found || (ns.Database == "SocialFlux" && ns.Collection == collection)
+	}
+	if !found {
+		closer.Close()
+		return nil, fmt.Errorf("no SocialFlux.%s in %s", collection, s.path)
+	}
+
+	// current is the namespace of the block being read, nil between blocks
+	var current *archiveNamespace
+	next := func() ([]byte, error) {
+		for {
+			doc, terminator, err := readDumpDocument(r)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case current == nil && terminator:
+				return nil, fmt.Errorf("empty block in %s", s.path)
+			case current == nil:
+				var ns archiveNamespace
+				if err := bson.Unmarshal(doc, &ns); err != nil {
+					return nil, fmt.Errorf("invalid block header in %s: %v", s.path, err)
+				}
+				current = &ns
+			case terminator:
+				done := current.EOF && current.Database == "SocialFlux" && current.Collection == collection
+				current = nil
+				if done {
+					return nil, io.EOF
+				}
+			case current.Database == "SocialFlux" && current.Collection == collection:
+				return doc, nil
+			}
+		}
+	}
+	cursor := &bsonCursor{next: next, closer: closer}
+	// Archives can't seek to a document, so a resumed collection reads up to it
+	for i := 0; i < opts.Skip && cursor.Next(ctx); i++ {
+	}
+	if err := cursor.Err(); err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("error reading %s: %v", collection, err)
+	}
+	return cursor, nil
+}
+
+// readArchivePrelude checks the magic number and returns the collections the
+// prelude lists.
+func readArchivePrelude(r io.Reader) ([]archiveNamespace, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || binary.LittleEndian.Uint32(magic[:]) != archiveMagic {
+		return nil, fmt.Errorf("not a mongodump archive")
+	}
+	// The first document is the archive's header, the others describe a collection each
+	if _, _, err := readDumpDocument(r); err != nil {
+		return nil, err
+	}
+	var namespaces []archiveNamespace
+	for {
+		doc, terminator, err := readDumpDocument(r)
+		if err != nil {
+			return nil, err
+		}
+		if terminator {
+			return namespaces, nil
+		}
+		var ns archiveNamespace
+		if err := bson.Unmarshal(doc, &ns); err != nil {
+			return nil, fmt.Errorf("invalid collection metadata: %v", err)
+		}
+		namespaces = append(namespaces, ns)
+	}
+}
+
+func (s *archiveSource) Close(ctx context.Context) error {
+	return nil
+}
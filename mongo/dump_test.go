@@ -0,0 +1,187 @@
+package mongo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func dumpBytes(t *testing.T, parts ...interface{}) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	for _, part := range parts {
+		switch p := part.(type) {
+		case bson.D:
+			data, err := bson.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Write(data)
+		case uint32:
+			binary.Write(&b, binary.LittleEndian, p)
+		case []byte:
+			b.Write(p)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestReadDumpDocument(t *testing.T) {
+	first := bson.D{{Key: "_id", Value: "a"}, {Key: "title", Value: "hello"}}
+	second := bson.D{{Key: "_id", Value: "b"}}
+	type read struct {
+		id         string
+		terminator bool
+	}
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []read
+		wantErr string
+	}{
+		{name: "empty", input: nil},
+		{name: "documents", input: dumpBytes(t, first, second), want: []read{{id: "a"}, {id: "b"}}},
+		{
+			name:  "archive blocks",
+			input: dumpBytes(t, first, uint32(bsonTerminator), second, uint32(bsonTerminator)),
+			want:  []read{{id: "a"}, {terminator: true}, {id: "b"}, {terminator: true}},
+		},
+		{name: "truncated length", input: []byte{0x10, 0x00}, wantErr: "truncated BSON document"},
+		{name: "truncated document", input: dumpBytes(t, first)[:10], wantErr: "truncated BSON document"},
+		{name: "length too small", input: dumpBytes(t, uint32(4)), wantErr: "invalid BSON document length 4"},
+		{name: "length too large", input: dumpBytes(t, uint32(maxDumpDocument+1)), wantErr: "invalid BSON document length"},
+		{name: "garbage after a document", input: dumpBytes(t, first, []byte{0x01}), want: []read{{id: "a"}}, wantErr: "truncated BSON document"},
+	}
+	for _, tt := range tests {
+		r := bytes.NewReader(tt.input)
+		var got []read
+		var err error
+		for {
+			var doc []byte
+			var terminator bool
+			doc, terminator, err = readDumpDocument(r)
+			if err != nil {
+				break
+			}
+			if terminator {
+				got = append(got, read{terminator: true})
+				continue
+			}
+			var v struct {
+				ID string `bson:"_id"`
+			}
+			if err := bson.Unmarshal(doc, &v); err != nil {
+				t.Fatalf("%s: document %d doesn't decode: %v", tt.name, len(got)+1, err)
+			}
+			got = append(got, read{id: v.ID})
+		}
+		if tt.wantErr == "" && err != io.EOF {
+			t.Errorf("%s: ended with %v, want io.EOF", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: ended with %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: read %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: read %+v, want %+v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// testArchive lays out a mongodump --archive of SocialFlux's posts and users,
+// their blocks interleaved the way mongodump writes them.
+func testArchive(t *testing.T) []byte {
+	t.Helper()
+	ns := func(collection string, eof bool) bson.D {
+		return bson.D{{Key: "db", Value: "SocialFlux"}, {Key: "collection", Value: collection}, {Key: "EOF", Value: eof}}
+	}
+	end := uint32(bsonTerminator)
+	return dumpBytes(t,
+		uint32(archiveMagic),
+		bson.D{{Key: "version", Value: "0.1"}},
+		ns("posts", false), ns("users", false), end,
+		ns("posts", false), bson.D{{Key: "_id", Value: "p1"}}, end,
+		ns("users", false), bson.D{{Key: "_id", Value: "u1"}}, end,
+		ns("posts", false), bson.D{{Key: "_id", Value: "p2"}}, bson.D{{Key: "_id", Value: "p3"}}, end,
+		ns("posts", true), end,
+		ns("users", true), end,
+	)
+}
+
+func TestArchiveSource(t *testing.T) {
+	dir := t.TempDir()
+	archive := testArchive(t)
+	var zipped bytes.Buffer
+	z := gzip.NewWriter(&zipped)
+	z.Write(archive)
+	z.Close()
+	files := map[string][]byte{"plain.archive": archive, "zipped.archive": zipped.Bytes(), "other": []byte("not an archive")}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file       string
+		collection string
+		skip       int
+		want       []string
+		wantErr    string
+	}{
+		{file: "plain.archive", collection: "posts", want: []string{"p1", "p2", "p3"}},
+		{file: "plain.archive", collection: "users", want: []string{"u1"}},
+		{file: "zipped.archive", collection: "posts", want: []string{"p1", "p2", "p3"}},
+		{file: "plain.archive", collection: "posts", skip: 2, want: []string{"p3"}},
+		{file: "plain.archive", collection: "blogs", wantErr: "no SocialFlux.blogs"},
+		{file: "other", collection: "posts", wantErr: "not a mongodump archive"},
+	}
+	for _, tt := range tests {
+		source, err := newArchiveSource(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, err := source.Open(context.Background(), tt.collection, readOptions{Skip: tt.skip})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s %s: error = %v, want one containing %q", tt.file, tt.collection, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.file, tt.collection, err)
+			continue
+		}
+		var got []string
+		for cursor.Next(context.Background()) {
+			var v struct {
+				ID string `bson:"_id"`
+			}
+			if err := cursor.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v.ID)
+		}
+		if err := cursor.Err(); err != nil {
+			t.Errorf("%s %s: %v", tt.file, tt.collection, err)
+		}
+		cursor.Close(context.Background())
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s %s (skip %d) = %v, want %v", tt.file, tt.collection, tt.skip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/urfave/cli/v2"
+)
+
+// environmentTable holds the single row stamping a target database with the
+// environment the first run into it came from. It sits outside the versioned
+// schema so schema down can't drop it.
+const environmentTable = "cli_tools_environment"
+
+// environment identifies where a run reads from and writes to. Hashes cover
+// the addresses without credentials, so changing a password doesn't count as
+// a new target.
+type environment struct {
+	Target string
+	Schema string
+	Source string
+}
+
+func hashProfile(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// runEnvironment describes the environment of the run about to start.
+func runEnvironment(c *cli.Context, uri string) (environment, error) {
+	cfg, err := mysql.ParseDSN(uri)
+	if err != nil {
+		return environment{}, fmt.Errorf("invalid MYSQL_URI: %v", err)
+	}
+	target := cfg.Net + "(" + cfg.Addr + ")/" + cfg.DBName
+	source, err := sourceDescription(c)
+	if err != nil {
+		return environment{}, err
+	}
+	return environment{Target: hashProfile(target), Schema: cfg.DBName, Source: hashProfile(source)}, nil
+}
+
+// sourceDescription names the source of the run, without credentials.
+func sourceDescription(c *cli.Context) (string, error) {
+	switch c.String("source") {
+	case "mongo":
+		u, err := url.Parse(c.String("mongodb-uri"))
+		if err != nil {
+			return "", fmt.Errorf("invalid MONGODB_URI: %v", err)
+		}
+		u.User = nil
+		return "mongo " + u.Host + u.Path, nil
+	case "dir":
+		dir, err := filepath.Abs(c.String("source-dir"))
+		if err != nil {
+			return "", fmt.Errorf("invalid --source-dir: %v", err)
+		}
+		return "dir " + dir, nil
+	case "archive":
+		path, err := filepath.Abs(c.String("source-archive"))
+		if err != nil {
+			return "", fmt.Errorf("invalid --source-archive: %v", err)
+		}
+		return "archive " + path, nil
+	}
+	return "api " + c.String("api-url"), nil
+}
+
+// checkEnvironment stamps a target database with env on the first run into
+// it and refuses later runs from anywhere else, so staging data can't end up
+// in production by a mixed-up URI. accept restamps the target instead.
+func checkEnvironment(mysqlDB *sql.DB, env environment, accept bool) error {
+	_, err := mysqlDB.Exec(`CREATE TABLE IF NOT EXISTS ` + environmentTable + ` (
+    id TINYINT NOT NULL PRIMARY KEY,
+    target_hash CHAR(64) NOT NULL,
+    schema_name VARCHAR(64) NOT NULL,
+    source_hash CHAR(64) NOT NULL,
+    stamped_at DATETIME NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", environmentTable, err)
+	}
+
+	var stamped environment
+	var stampedAt string
+	err = mysqlDB.QueryRow("SELECT target_hash, schema_name, source_hash, stamped_at FROM "+environmentTable+" WHERE id = 1").
+		Scan(&stamped.Target, &stamped.Schema, &stamped.Source, &stampedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		logf(levelInfo, "Stamping %s with this run's environment", env.Schema)
+	case err != nil:
+		return fmt.Errorf("error reading %s: %v", environmentTable, err)
+	case stamped == env:
+		return nil
+	case !accept:
+		return fmt.Errorf("%s was stamped on %s for %s, but this run is %s; pass --accept-new-target if this really is the right target",
+			env.Schema, stampedAt, stamped.describe(), env.describe())
+	default:
+		logf(levelWarn, "Restamping %s, was %s, now %s", env.Schema, stamped.describe(), env.describe())
+	}
+
+	_, err = mysqlDB.Exec("REPLACE INTO "+environmentTable+" (id, target_hash, schema_name, source_hash, stamped_at) VALUES (1, ?, ?, ?, ?)",
+		env.Target, env.Schema, env.Source, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("error stamping %s: %v", environmentTable, err)
+	}
+	return nil
+}
+
+func (e environment) describe() string {
+	return fmt.Sprintf("target %s, schema %s, source %s", shortHash(e.Target), e.Schema, shortHash(e.Source))
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
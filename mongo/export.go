@@ -0,0 +1,194 @@
+package mongo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var exportCommand = &cli.Command{
+	Name:  "export",
+	Usage: "Export data to files",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "site-content",
+			Usage: "Write the blog posts and partners bundle consumed by the static site",
+			Flags: withFlags(mysqlFlags, []cli.Flag{
+				&cli.StringFlag{
+					Name:  "out",
+					Value: "site-content",
+					Usage: "directory the bundle is written to",
+				},
+				&cli.StringSliceFlag{
+					Name:  "rewrite-image",
+					Usage: "rewrite image URLs starting with OLD to start with NEW instead (OLD=NEW, repeatable)",
+				},
+			}),
+			Action: exportSiteContent,
+		},
+		exportCollectionsCommand,
+		deliverCommand,
+	},
+}
+
+// siteContent is the bundle layout the static site reads from blogs.json and partners.json.
+type siteContent struct {
+	Blogs    []BlogPost
+	Partners []Partner
+}
+
+func exportSiteContent(c *cli.Context) error {
+	rewrites, err := parseImageRewrites(c.StringSlice("rewrite-image"))
+	if err != nil {
+		return err
+	}
+
+	mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+	defer mysqlDB.Close()
+
+	content, err := loadSiteContent(mysqlDB)
+	if err != nil {
+		return err
+	}
+
+	// Point every image at its new location before anything is written
+	for i := range content.Blogs {
+		content.Blogs[i].Authoravatar = rewrites.apply(content.Blogs[i].Authoravatar)
+	}
+	for i := range content.Partners {
+		content.Partners[i].Banner = rewrites.apply(content.Partners[i].Banner)
+		content.Partners[i].Logo = rewrites.apply(content.Partners[i].Logo)
+	}
+
+	out := c.String("out")
+	if err := os.MkdirAll(filepath.Join(out, "blog"), 0o755); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(out, "blogs.json"), content.Blogs); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(out, "partners.json"), content.Partners); err != nil {
+		return err
+	}
+	for _, blog := range content.Blogs {
+		path := filepath.Join(out, "blog", blog.Slug+".md")
+		if err := os.WriteFile(path, []byte(blogMarkdown(blog)), 0o644); err != nil {
+			return err
+		}
+	}
+
+	logf(levelInfo, "Exported %d blog posts and %d partners to %s", len(content.Blogs), len(content.Partners), out)
+	return nil
+}
+
+func loadSiteContent(mysqlDB *sql.DB) (*siteContent, error) {
+	content := &siteContent{}
+
+	rows, err := mysqlDB.Query("SELECT slug, title, date, author_name, overview, author_avatar FROM blogs")
+	if err != nil {
+		return nil, fmt.Errorf("error reading blogs: %v", err)
+	}
+	defer rows.Close()
+	bySlug := map[string]int{}
+	for rows.Next() {
+		var blog BlogPost
+		if err := rows.Scan(&blog.Slug, &blog.Title, &blog.Date, &blog.AuthorName, &blog.Overview, &blog.Authoravatar); err != nil {
+			return nil, fmt.Errorf("error reading blog: %v", err)
+		}
+		bySlug[blog.Slug] = len(content.Blogs)
+		content.Blogs = append(content.Blogs, blog)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := mysqlDB.Query("SELECT blog_slug, body FROM blog_entries ORDER BY blog_slug, position")
+	if err != nil {
+		return nil, fmt.Errorf("error reading blog entries: %v", err)
+	}
+	defer entries.Close()
+	for entries.Next() {
+		var slug string
+		var entry PostEntry
+		if err := entries.Scan(&slug, &entry.Body); err != nil {
+			return nil, fmt.Errorf("error reading blog entry: %v", err)
+		}
+		if i, ok := bySlug[slug]; ok {
+			content.Blogs[i].Content = append(content.Blogs[i].Content, entry)
+		}
+	}
+	if err := entries.Err(); err != nil {
+		return nil, err
+	}
+
+	partners, err := mysqlDB.Query("SELECT banner, logo, title, text, link FROM partners")
+	if err != nil {
+		return nil, fmt.Errorf("error reading partners: %v", err)
+	}
+	defer partners.Close()
+	for partners.Next() {
+		var partner Partner
+		if err := partners.Scan(&partner.Banner, &partner.Logo, &partner.Title, &partner.Text, &partner.Link); err != nil {
+			return nil, fmt.Errorf("error reading partner: %v", err)
+		}
+		content.Partners = append(content.Partners, partner)
+	}
+	return content, partners.Err()
+}
+
+// blogMarkdown renders a blog post as Markdown with the front matter the static site expects.
+func blogMarkdown(blog BlogPost) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "slug: %q\n", blog.Slug)
+	fmt.Fprintf(&b, "title: %q\n", blog.Title)
+	fmt.Fprintf(&b, "date: %q\n", blog.Date)
+	fmt.Fprintf(&b, "author: %q\n", blog.AuthorName)
+	fmt.Fprintf(&b, "authorAvatar: %q\n", blog.Authoravatar)
+	fmt.Fprintf(&b, "overview: %q\n", blog.Overview)
+	b.WriteString("---\n")
+	for _, entry := range blog.Content {
+		b.WriteString("\n")
+		b.WriteString(entry.Body)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// imageRewrites maps old URL prefixes to their replacements.
+type imageRewrites [][2]string
+
+func parseImageRewrites(values []string) (imageRewrites, error) {
+	var rewrites imageRewrites
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --rewrite-image %q, expected OLD=NEW", v)
+		}
+		rewrites = append(rewrites, [2]string{parts[0], parts[1]})
+	}
+	return rewrites, nil
+}
+
+// apply rewrites url using the first matching prefix.
+func (r imageRewrites) apply(url string) string {
+	for _, rw := range r {
+		if strings.HasPrefix(url, rw[0]) {
+			return rw[1] + strings.TrimPrefix(url, rw[0])
+		}
+	}
+	return url
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
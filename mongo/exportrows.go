@@ -0,0 +1,234 @@
+package mongo
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var exportCollectionsCommand = &cli.Command{
+	Name:  "collections",
+	Usage: "Dump collections to NDJSON or CSV, one file per MySQL table, using the migration's row mappings",
+	Flags: withFlags(mongoFlags, sourceFlags, retryFlags, normalizeFlags, dateFlags, filterFlags, []cli.Flag{
+		&cli.StringFlag{
+			Name:  "collections",
+			Usage: "comma separated list of collections to export (default: all)",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "ndjson",
+			Usage: "output format: ndjson or csv",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Value: "export",
+			Usage: "directory the table files are written to",
+		},
+	}),
+	Action:       exportCollections,
+	BashComplete: completeCollections(true, "collections"),
+}
+
+func exportCollections(c *cli.Context) error {
+	format := c.String("format")
+	if format != "ndjson" && format != "csv" {
+		return fmt.Errorf("unknown --format %q, expected ndjson or csv", format)
+	}
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	selected, err := selectCollections(cfg.migrations(), c.String("collections"), "")
+	if err != nil {
+		return err
+	}
+	filters, err := collectionFilters(cfg, selected, c.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+	projections := collectionProjections(cfg, selected)
+	dates, err := newDateParser(cfg.dateLayouts(), c.String("default-timezone"))
+	if err != nil {
+		return err
+	}
+
+	reads, err := newRateLimiter("read-rate", c.Float64("read-rate"))
+	if err != nil {
+		return err
+	}
+
+	out := c.String("out")
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+
+	retry := newRetrier(c.Context, c.Int("max-retries"), c.Duration("retry-delay"))
+	source, err := openSource(c, retry)
+	if err != nil {
+		return err
+	}
+	defer source.Close(context.TODO())
+
+	writers := map[string]rowWriter{}
+	defer func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}()
+
+	// Rows are built exactly as the migration builds them, only written to files instead of MySQL
+	run := &migrator{
+		comments:   c.Bool("normalize-comments"),
+		hearts:     c.Bool("normalize-hearts"),
+		links:      c.Bool("normalize-links"),
+		dates:      dates,
+		transforms: cfg.Transforms,
+	}
+	for _, cm := range selected {
+		cursor, err := source.Open(c.Context, cm.Name, readOptions{Filter: filters[cm.Name], Projection: projections[cm.Name]})
+		if err != nil {
+			return err
+		}
+		exported, failed := 0, 0
+		for reads.wait(c.Context) == nil && cursor.Next(c.Context) {
+			rows, err := cm.Rows(run, cursor)
+			if err == nil {
+				err = applyTransforms(run.transforms, rows)
+			}
+			if errors.Is(err, errSkipped) {
+				continue
+			}
+			if err != nil {
+				logf(levelWarn, "Skipping %s document: %v", cm.Name, err)
+				failed++
+				continue
+			}
+			for _, row := range rows {
+				w, ok := writers[row.Table]
+				if !ok {
+					path := filepath.Join(out, row.Table+"."+format)
+					if w, err = newRowWriter(path, format, row.Columns); err != nil {
+						return err
+					}
+					writers[row.Table] = w
+				}
+				if err := w.Write(row); err != nil {
+					return fmt.Errorf("error writing %s: %v", row.Table, err)
+				}
+			}
+			exported++
+		}
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("error reading %s: %v", cm.Name, err)
+		}
+		cursor.Close(context.TODO())
+		logf(levelInfo, "%s: %d exported, %d failed", cm.Name, exported, failed)
+	}
+	return nil
+}
+
+// rowWriter writes the rows of one table to a file.
+type rowWriter interface {
+	Write(row tableRow) error
+	Close() error
+}
+
+func newRowWriter(path, format string, columns []string) (rowWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "csv" {
+		w := &csvRowWriter{f: f, w: csv.NewWriter(f)}
+		if err := w.w.Write(columns); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+	return &ndjsonRowWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+type ndjsonRowWriter struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func (w *ndjsonRowWriter) Write(row tableRow) error {
+	record := make(map[string]interface{}, len(row.Columns))
+	for i, col := range row.Columns {
+		v, err := exportValue(row.Values[i])
+		if err != nil {
+			return err
+		}
+		if j, ok := row.Values[i].(jsonValue); ok {
+			// Keep JSON columns as nested JSON rather than an encoded string
+			raw, _ := j.Value()
+			v = json.RawMessage(raw.(string))
+		}
+		record[col] = v
+	}
+	return w.enc.Encode(record)
+}
+
+func (w *ndjsonRowWriter) Close() error {
+	return w.f.Close()
+}
+
+type csvRowWriter struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func (w *csvRowWriter) Write(row tableRow) error {
+	record := make([]string, len(row.Values))
+	for i, value := range row.Values {
+		v, err := exportValue(value)
+		if err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			record[i] = v
+		case bool:
+			record[i] = strconv.FormatBool(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.w.Write(record)
+}
+
+func (w *csvRowWriter) Close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// exportValue converts a row value the way the MySQL driver would see it,
+// with timestamps as RFC 3339 text.
+func exportValue(v interface{}) (interface{}, error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil {
+			return nil, err
+		}
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano), nil
+	}
+	return v, nil
+}
@@ -0,0 +1,155 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// failureKind is the category of what kept a document from transferring.
+type failureKind string
+
+const (
+	// failDecode is a document that doesn't decode into the collection's shape
+	failDecode failureKind = "decode"
+	// failTransform is a document refused by a transform, a validation rule
+	// or another step that turns it into rows
+	failTransform failureKind = "transform"
+	// failConstraint is a row MySQL refused, such as a duplicate key, a
+	// missing foreign key or a value that doesn't fit its column
+	failConstraint failureKind = "constraint"
+	// failWrite is any other error inserting the rows
+	failWrite failureKind = "write"
+	// failConnection is a connection that broke while migrating a collection,
+	// which is resumed rather than quarantining documents
+	failConnection failureKind = "connection"
+)
+
+// failureKinds are the categories in the order the summary lists them.
+var failureKinds = []failureKind{failDecode, failTransform, failConstraint, failWrite, failConnection}
+
+// constraintErrors are the MySQL error numbers of rows that break a
+// constraint of the target schema.
+var constraintErrors = map[uint16]bool{
+	1048: true, // ER_BAD_NULL_ERROR
+	1062: true, // ER_DUP_ENTRY
+	1264: true, // ER_WARN_DATA_OUT_OF_RANGE
+	1292: true, // ER_TRUNCATED_WRONG_VALUE
+	1364: true, // ER_NO_DEFAULT_FOR_FIELD
+	1366: true, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD
+	1406: true, // ER_DATA_TOO_LONG
+	1451: true, // ER_ROW_IS_REFERENCED_2
+	1452: true, // ER_NO_REFERENCED_ROW_2
+	3819: true, // ER_CHECK_CONSTRAINT_VIOLATED
+}
+
+// decodeError is an error preparing a document that couldn't be decoded.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// decodeTracker notes whether the document it wraps failed to decode, so the
+// error it ends up failing with can be told apart from a transform's.
+type decodeTracker struct {
+	documentCursor
+	failed bool
+}
+
+func (t *decodeTracker) Decode(v interface{}) error {
+	err := t.documentCursor.Decode(v)
+	if err != nil {
+		t.failed = true
+	}
+	return err
+}
+
+// classifyFailure returns the category of err, which a document failed with
+// while being prepared, or while its rows were written when prepared is set.
+func classifyFailure(err error, prepared bool) failureKind {
+	var decode *decodeError
+	if errors.As(err, &decode) {
+		return failDecode
+	}
+	if isTransient(err) {
+		return failConnection
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		if constraintErrors[mysqlErr.Number] {
+			return failConstraint
+		}
+		return failWrite
+	}
+	if !prepared {
+		return failTransform
+	}
+	return failWrite
+}
+
+// failureTable logs the failures of the selected collections by category,
+// with a total for each, when any of them had one.
+func (m *migrator) failureTable(selected []collectionMigration) {
+	totals := map[failureKind]int{}
+	var rows []string
+	for _, cm := range selected {
+		kinds := m.failureCounts(cm.Name)
+		if len(kinds) == 0 {
+			continue
+		}
+		row := cm.Name
+		for _, kind := range failureKinds {
+			row += fmt.Sprintf("\t%d", kinds[kind])
+			totals[kind] += kinds[kind]
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return
+	}
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	header := "failures"
+	total := "total"
+	for _, kind := range failureKinds {
+		header += "\t" + string(kind)
+		total += fmt.Sprintf("\t%d", totals[kind])
+	}
+	fmt.Fprintln(tw, header)
+	for _, row := range rows {
+		fmt.Fprintln(tw, row)
+	}
+	if len(rows) > 1 {
+		fmt.Fprintln(tw, total)
+	}
+	tw.Flush()
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		logf(levelInfo, "%s", line)
+	}
+}
+
+// failureCounts counts the failures of a collection by category: its
+// quarantined documents and the connection failures it was resumed after.
+func (m *migrator) failureCounts(collection string) map[failureKind]int {
+	kinds := m.failed.kindCounts(collection)
+	if n := m.interrupted[collection]; n > 0 {
+		kinds[failConnection] += n
+	}
+	return kinds
+}
+
+// failureText describes the categories of kinds, e.g. "2 decode, 1 constraint".
+func failureText(kinds map[failureKind]int) string {
+	var parts []string
+	for _, kind := range failureKinds {
+		if n := kinds[kind]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, kind))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,39 @@
+package mongo
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		prepared bool
+		want     failureKind
+	}{
+		{"decode", &decodeError{err: errors.New("cannot decode string into an int")}, false, failDecode},
+		{"wrapped decode", fmt.Errorf("users: %w", &decodeError{err: errors.New("bad")}), false, failDecode},
+		{"transform", errors.New("invalid value(s): users.email email"), false, failTransform},
+		{"duplicate key", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, true, failConstraint},
+		{"foreign key", fmt.Errorf("inserting posts: %w", &mysql.MySQLError{Number: 1452}), true, failConstraint},
+		{"too long", &mysql.MySQLError{Number: 1406}, true, failConstraint},
+		{"other MySQL error", &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}, true, failWrite},
+		// A MySQL error is a write error even while preparing, e.g. an id_map lookup
+		{"MySQL error preparing", &mysql.MySQLError{Number: 1146}, false, failWrite},
+		{"write", errors.New("sql: transaction has already been committed"), true, failWrite},
+		{"bad connection", fmt.Errorf("inserting users: %w", driver.ErrBadConn), true, failConnection},
+		{"invalid connection", mysql.ErrInvalidConn, true, failConnection},
+		{"unexpected EOF", io.ErrUnexpectedEOF, false, failConnection},
+	}
+	for _, tt := range tests {
+		if got := classifyFailure(tt.err, tt.prepared); got != tt.want {
+			t.Errorf("%s: classifyFailure = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
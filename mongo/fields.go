@@ -0,0 +1,194 @@
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ignoredFields are never reported as unknown: _id is deliberately replaced
+// by natural keys for some tables and __v is the Mongoose version counter.
+var ignoredFields = map[string]bool{"_id": true, "__v": true}
+
+// bsonFields returns the document keys the mongo driver decodes into the
+// fields of v, leaving out the ones listed in unmigrated: a field that is
+// decoded but never written anywhere is as lost as one that isn't decoded.
+func bsonFields(v interface{}, unmigrated ...string) []string {
+	skip := map[string]bool{}
+	for _, key := range unmigrated {
+		skip[key] = true
+	}
+	t := reflect.TypeOf(v)
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("bson"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			// The driver's default for untagged fields
+			key = strings.ToLower(field.Name)
+		}
+		if skip[key] {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// unknownFields returns the paths in doc that none of the covered paths
+// reach. A covered path such as "author.name" makes "author" a partially
+// covered document whose other keys are reported individually.
+func unknownFields(doc bson.M, covered []string) []string {
+	return unknownFieldsUnder(doc, "", covered)
+}
+
+func unknownFieldsUnder(doc map[string]interface{}, prefix string, covered []string) []string {
+	var unknown []string
+	for key, value := range doc {
+		path := prefix + key
+		if prefix == "" && ignoredFields[key] {
+			continue
+		}
+		full, partial := false, false
+		for _, c := range covered {
+			if c == path {
+				full = true
+				break
+			}
+			if strings.HasPrefix(c, path+".") {
+				partial = true
+			}
+		}
+		switch {
+		case full:
+		case partial:
+			if sub, ok := asMap(value); ok {
+				unknown = append(unknown, unknownFieldsUnder(sub, path+".", covered)...)
+			}
+		default:
+			unknown = append(unknown, path)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case map[string]interface{}:
+		return d, true
+	case bson.D:
+		return d.Map(), true
+	}
+	return nil, false
+}
+
+// checkFields records in l the fields of the current document that cm
+// doesn't migrate, and the type of its _id. In strict mode a document with
+// such fields is refused instead.
+func (m *migrator) checkFields(cm collectionMigration, cursor documentCursor, l *ledger) error {
+	var doc bson.M
+	if err := cursor.Decode(&doc); err != nil {
+		// Let the transfer function report the decode error
+		return nil
+	}
+	idType := idTypeName(doc["_id"])
+	l.add(func() error {
+		m.countIDType(cm.Name, idType)
+		return nil
+	})
+	unknown := unknownFields(doc, cm.Fields)
+	if len(unknown) == 0 {
+		return nil
+	}
+	l.add(func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.unknown[cm.Name] == nil {
+			m.unknown[cm.Name] = map[string]int{}
+		}
+		for _, path := range unknown {
+			m.unknown[cm.Name][path]++
+		}
+		return nil
+	})
+	if m.strict {
+		return fmt.Errorf("unknown field(s) not covered by the migration: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// countIDType records the type of a document's _id, as named by idTypeName.
+func (m *migrator) countIDType(collection, idType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.idTypes == nil {
+		m.idTypes = map[string]map[string]int{}
+	}
+	if m.idTypes[collection] == nil {
+		m.idTypes[collection] = map[string]int{}
+	}
+	m.idTypes[collection][idType]++
+}
+
+// idTypeSummary logs how many of a collection's documents had an _id of each
+// type. Collections whose IDs are all of one type only log it at debug level.
+func (m *migrator) idTypeSummary(cm collectionMigration) {
+	counts := m.idTypes[cm.Name]
+	if len(counts) == 0 {
+		return
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%s (%d)", t, counts[t])
+	}
+	level := levelInfo
+	if len(types) == 1 {
+		level = levelDebug
+	}
+	logf(level, "%s _id types: %s", cm.Name, strings.Join(parts, ", "))
+}
+
+// unknownSummary logs the fields each collection dropped because nothing migrates them.
+func (m *migrator) unknownSummary(cm collectionMigration) {
+	counts := m.unknown[cm.Name]
+	if len(counts) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(counts))
+	for path := range counts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	parts := make([]string, len(paths))
+	for i, path := range paths {
+		parts[i] = fmt.Sprintf("%s (%d)", path, counts[path])
+	}
+	logf(levelWarn, "%s: fields not migrated: %s", cm.Name, strings.Join(parts, ", "))
+}
+
+// coverFields adds fields to what a collection's migration covers, for data
+// that only opt-in modes such as --normalize-comments write anywhere.
+func coverFields(selected []collectionMigration, collection string, fields ...string) []collectionMigration {
+	out := make([]collectionMigration, len(selected))
+	for i, cm := range selected {
+		if cm.Name == collection {
+			cm.Fields = append(append([]string{}, cm.Fields...), fields...)
+		}
+		out[i] = cm
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     bson.M
+		covered []string
+		want    []string
+	}{
+		{
+			name:    "all covered",
+			doc:     bson.M{"_id": 1, "__v": 0, "title": "a", "content": "b"},
+			covered: []string{"title", "content"},
+		},
+		{
+			name:    "top-level",
+			doc:     bson.M{"title": "a", "views": 3, "flags": bson.M{"x": true}},
+			covered: []string{"title"},
+			want:    []string{"flags", "views"},
+		},
+		{
+			name:    "covered document",
+			doc:     bson.M{"author": bson.M{"name": "a", "id": 1}},
+			covered: []string{"author"},
+		},
+		{
+			name:    "partly covered document",
+			doc:     bson.M{"author": bson.M{"name": "a", "id": 1, "avatar": bson.M{"url": "u"}}},
+			covered: []string{"author.name"},
+			want:    []string{"author.avatar", "author.id"},
+		},
+		{
+			name:    "deeply covered",
+			doc:     bson.M{"author": bson.D{{Key: "avatar", Value: bson.D{{Key: "url", Value: "u"}, {Key: "size", Value: 2}}}}},
+			covered: []string{"author.avatar.url"},
+			want:    []string{"author.avatar.size"},
+		},
+		{
+			name:    "partly covered scalar",
+			doc:     bson.M{"author": "a"},
+			covered: []string{"author.name"},
+		},
+		{
+			// Only the top-level _id and __v are the driver's and Mongoose's
+			name:    "nested _id",
+			doc:     bson.M{"author": bson.M{"_id": 1, "name": "a"}},
+			covered: []string{"author.name"},
+			want:    []string{"author._id"},
+		},
+	}
+	for _, tt := range tests {
+		if got := unknownFields(tt.doc, tt.covered); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: unknownFields = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBSONFields(t *testing.T) {
+	type doc struct {
+		ID       string `bson:"_id"`
+		Title    string `bson:"title,omitempty"`
+		Internal string `bson:"-"`
+		Plain    string
+		Hearts   []string `bson:"hearts"`
+	}
+	tests := []struct {
+		unmigrated []string
+		want       []string
+	}{
+		{nil, []string{"_id", "title", "plain", "hearts"}},
+		{[]string{"hearts"}, []string{"_id", "title", "plain"}},
+	}
+	for _, tt := range tests {
+		if got := bsonFields(doc{}, tt.unmigrated...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bsonFields(%v) = %v, want %v", tt.unmigrated, got, tt.want)
+		}
+	}
+}
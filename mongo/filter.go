@@ -0,0 +1,148 @@
+package mongo
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// queryFilter is a MongoDB query in extended JSON, like
+// {"createdAt": {"$gte": {"$date": "2023-01-01T00:00:00Z"}}}, that picks the
+// documents of a collection to read.
+type queryFilter struct {
+	doc bson.D
+}
+
+func (f *queryFilter) UnmarshalJSON(data []byte) error {
+	doc, err := parseQueryFilter(string(data))
+	if err != nil {
+		return err
+	}
+	f.doc = doc
+	return nil
+}
+
+func parseQueryFilter(text string) (bson.D, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(text), false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid query filter %s: %v", text, err)
+	}
+	return doc, nil
+}
+
+// validateFilters checks that the config's filters name migrated collections.
+func validateFilters(available []collectionMigration, filters map[string]queryFilter) error {
+	for name := range filters {
+		if !knownCollection(available, name) {
+			return fmt.Errorf("filters: unknown collection %q", name)
+		}
+	}
+	return nil
+}
+
+// collectionFilters merges the config's filters with --filter's
+// collection:query values, which replace the config's for their collection.
+func collectionFilters(cfg *config, selected []collectionMigration, flags []string) (map[string]bson.D, error) {
+	available := cfg.migrations()
+	filters := map[string]bson.D{}
+	for name, f := range cfg.Filters {
+		filters[name] = f.doc
+	}
+	for _, item := range flags {
+		name, query, ok := strings.Cut(item, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !knownCollection(available, name) {
+			return nil, fmt.Errorf("invalid --filter %q, expected collection:query with a migrated collection", item)
+		}
+		doc, err := parseQueryFilter(query)
+		if err != nil {
+			return nil, fmt.Errorf("--filter %s: %v", name, err)
+		}
+		filters[name] = doc
+	}
+	for _, cm := range selected {
+		if doc, ok := filters[cm.Name]; ok {
+			logf(levelInfo, "Reading only the %s documents matching %s", cm.Name, filterText(doc))
+		}
+	}
+	return filters, nil
+}
+
+// filterText renders a filter as relaxed extended JSON for the logs.
+func filterText(doc bson.D) string {
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Sprint(doc)
+	}
+	return string(data)
+}
+
+// fieldProjection leaves fields of a collection's documents out of the reads,
+// such as secrets nothing needs in MySQL. Either fields are excluded, or only
+// the included ones and _id are read.
+type fieldProjection struct {
+	Exclude []string `json:"exclude" doc:"fields not read, e.g. password or token"`
+	Include []string `json:"include" doc:"the only fields read, besides _id"`
+}
+
+func (p fieldProjection) validate(collection string) error {
+	if len(p.Exclude) > 0 && len(p.Include) > 0 {
+		return fmt.Errorf("projections: %s can exclude or include fields, not both", collection)
+	}
+	for _, field := range append(append([]string{}, p.Exclude...), p.Include...) {
+		if field == "" || field == "_id" || strings.HasPrefix(field, "$") {
+			return fmt.Errorf("projections: %s: invalid field %q", collection, field)
+		}
+	}
+	return nil
+}
+
+func validateProjections(available []collectionMigration, projections map[string]fieldProjection) error {
+	for name, p := range projections {
+		if !knownCollection(available, name) {
+			return fmt.Errorf("projections: unknown collection %q", name)
+		}
+		if err := p.validate(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectionProjections turns the config's projections of the selected
+// collections into MongoDB projections, warning about fields the migration
+// reads that they leave out: their columns stay empty.
+func collectionProjections(cfg *config, selected []collectionMigration) map[string]bson.D {
+	projections := map[string]bson.D{}
+	for _, cm := range selected {
+		p, ok := cfg.Projections[cm.Name]
+		if !ok {
+			continue
+		}
+		doc := bson.D{}
+		kept := map[string]bool{"_id": true}
+		for _, field := range p.Include {
+			doc = append(doc, bson.E{Key: field, Value: 1})
+			kept[field] = true
+		}
+		dropped := map[string]bool{}
+		for _, field := range p.Exclude {
+			doc = append(doc, bson.E{Key: field, Value: 0})
+			dropped[field] = true
+		}
+		var lost []string
+		for _, field := range cm.Fields {
+			if dropped[field] || (len(p.Include) > 0 && !kept[field]) {
+				lost = append(lost, field)
+			}
+		}
+		if len(lost) > 0 {
+			logf(levelWarn, "%s: the projection leaves out %s, which the migration writes; their columns stay empty", cm.Name, strings.Join(lost, ", "))
+		}
+		if len(doc) > 0 {
+			projections[cm.Name] = doc
+		}
+	}
+	return projections
+}
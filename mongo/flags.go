@@ -0,0 +1,269 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Flags returns the global flags of the cli-tools app, which every command
+// sees. Pass them before the command: cli-tools --config config.json migrate.
+func Flags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "config",
+			Usage: "path to the JSON config file",
+		},
+		&cli.StringFlag{
+			Name:  "log-level",
+			Value: "info",
+			Usage: "least severe messages to log: debug, info, warn or error",
+		},
+		&cli.StringFlag{
+			Name:    "profile",
+			EnvVars: []string{"CLI_TOOLS_PROFILE"},
+			Usage:   "profile of the config file whose databases the command uses, e.g. staging",
+		},
+		&cli.BoolFlag{
+			Name:  "yes-i-mean-it",
+			Usage: "confirm a write to a production profile without being asked",
+		},
+	}, withFlags(tlsFlags, sshFlags, poolFlags)...)
+}
+
+// Before checks the operator roles, then applies the global flags,
+// including TLS, the SSH tunnel and the pool sizes, the secrets and the
+// profile before any command runs. A command the roles deny opens nothing.
+func Before(c *cli.Context) error {
+	if err := setLogLevel(c.String("log-level")); err != nil {
+		return err
+	}
+	if err := enforceRoles(c); err != nil {
+		return err
+	}
+	if err := applyTLS(c); err != nil {
+		return err
+	}
+	if err := applySSH(c); err != nil {
+		return err
+	}
+	if err := applyPool(c); err != nil {
+		return err
+	}
+	if err := applySecrets(c); err != nil {
+		return err
+	}
+	return applyProfile(c)
+}
+
+// The flags below are shared by the commands that need them, so for example
+// --source means the same for migrate and export collections.
+
+// mysqlFlags locate the target database.
+var mysqlFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "mysql-uri",
+		EnvVars: []string{"MYSQL_URI"},
+		Usage:   "DSN of the target MySQL database",
+	},
+}
+
+// mongoFlags locate the SocialFlux database.
+var mongoFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "mongodb-uri",
+		EnvVars: []string{"MONGODB_URI"},
+		Usage:   "connection string of the SocialFlux MongoDB cluster",
+	},
+}
+
+// sourceFlags choose where documents are read from. Commands taking them also
+// take mongoFlags for --source mongo.
+var sourceFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "source",
+		Value: "mongo",
+		Usage: "where documents are read from: mongo, api, dir or archive",
+	},
+	&cli.StringFlag{
+		Name:  "source-dir",
+		Usage: "directory of mongoexport files or of a mongodump database, one <collection>.json or .bson per collection (with --source dir)",
+	},
+	&cli.StringFlag{
+		Name:  "source-archive",
+		Usage: "mongodump --archive file, gzipped or not (with --source archive)",
+	},
+	&cli.StringFlag{
+		Name:    "api-url",
+		EnvVars: []string{"NETSOCIAL_API_URL"},
+		Usage:   "base URL of the NetSocial REST API (with --source api)",
+	},
+	&cli.StringFlag{
+		Name:    "api-token",
+		EnvVars: []string{"NETSOCIAL_API_TOKEN"},
+		Usage:   "bearer token for the NetSocial REST API",
+	},
+	&cli.IntFlag{
+		Name:  "api-page-size",
+		Value: 100,
+		Usage: "documents requested per API page",
+	},
+	&cli.Float64Flag{
+		Name:  "api-rate",
+		Value: 5,
+		Usage: "maximum API requests per second (0 for unlimited)",
+	},
+	&cli.IntFlag{
+		Name:  "batch-size",
+		Value: 1000,
+		Usage: "documents fetched from MongoDB per round trip",
+	},
+	&cli.StringFlag{
+		Name:  "read-preference",
+		Value: "primary",
+		Usage: "MongoDB members documents are read from: primary, primaryPreferred, secondary, secondaryPreferred or nearest",
+	},
+	&cli.BoolFlag{
+		Name:  "no-cursor-timeout",
+		Usage: "keep MongoDB from closing cursors left idle for 10 minutes during long scans",
+	},
+	&cli.Float64Flag{
+		Name:  "read-rate",
+		Usage: "maximum documents read per second, to spare a shared cluster (0 for unlimited)",
+	},
+}
+
+// retryFlags tune how transient failures are retried.
+var retryFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "max-retries",
+		Value: 5,
+		Usage: "times a failed connection, query or insert is retried before giving up",
+	},
+	&cli.DurationFlag{
+		Name:  "retry-delay",
+		Value: 500 * time.Millisecond,
+		Usage: "initial backoff between retries, doubled on every attempt",
+	},
+}
+
+// normalizeFlags move embedded arrays into tables of their own.
+var normalizeFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "normalize-comments",
+		Usage: "migrate post comments and their replies into the comments table",
+	},
+	&cli.BoolFlag{
+		Name:  "normalize-hearts",
+		Usage: "migrate post hearts into the post_heart table",
+	},
+	&cli.BoolFlag{
+		Name:  "normalize-links",
+		Usage: "migrate user links, cleaned up and tagged with their platform, into the user_links table",
+	},
+}
+
+// dateFlags tune how dates stored as text are read.
+var dateFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "default-timezone",
+		Value: "UTC",
+		Usage: "IANA time zone of text dates that don't name one, e.g. Europe/Berlin",
+	},
+}
+
+// rewriteFlags change the values of the rows written, so diff takes them
+// too to compare MongoDB with what the migration made of it.
+var rewriteFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "scrub-ips",
+		Usage: "look for IP addresses in text columns and report, hash, truncate (to /24, /48 for IPv6) or drop them",
+	},
+	&cli.BoolFlag{
+		Name:  "upload-media",
+		Usage: "download every media file into the config's mediaStore bucket and store the object URLs instead",
+	},
+}
+
+// transferFlags change or check documents on their way into MySQL.
+var transferFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "password-policy",
+		Usage: "detect password hash schemes and report weak ones (report) or also bcrypt-wrap them (rewrap)",
+	},
+	&cli.BoolFlag{
+		Name:  "uuid-ids",
+		Usage: "replace post, user and comment IDs with UUIDv7s, keeping the assignments in the id_map table",
+	},
+	&cli.BoolFlag{
+		Name:  "strict",
+		Usage: "refuse documents with fields the migration doesn't cover and exit non-zero when any document failed",
+	},
+	&cli.BoolFlag{
+		Name:  "fail-on-invalid",
+		Usage: "quarantine documents with values the validation rules reject and exit non-zero, instead of only reporting them",
+	},
+	&cli.IntFlag{
+		Name:  "max-in-flight",
+		Value: 64,
+		Usage: "documents each stage of the read, transform and write pipeline holds at most, bounding memory",
+	},
+	&cli.Float64Flag{
+		Name:  "write-rate",
+		Usage: "maximum rows written to MySQL per second (0 for unlimited)",
+	},
+	&cli.StringFlag{
+		Name:  "tx-per",
+		Value: "row",
+		Usage: "what one MySQL transaction covers: row, batch (the 1000 documents between two checkpoints) or collection",
+	},
+}
+
+// mediaFlags tune the checks on media URLs.
+var mediaFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "check-media",
+		Usage: "HEAD-request every avatar, banner, image and logo URL and report the ones that fail",
+	},
+	&cli.IntFlag{
+		Name:  "media-concurrency",
+		Value: 8,
+		Usage: "number of media URLs --check-media requests or --upload-media copies at once",
+	},
+	&cli.DurationFlag{
+		Name:  "media-timeout",
+		Value: 5 * time.Second,
+		Usage: "how long --check-media waits for one media URL",
+	},
+	&cli.DurationFlag{
+		Name:  "media-upload-timeout",
+		Value: 2 * time.Minute,
+		Usage: "how long --upload-media waits for one download or upload",
+	},
+}
+
+// filterFlags narrow down the documents read from the source.
+var filterFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "filter",
+		Usage: `read only the documents of a collection matching a MongoDB query in extended JSON, like 'posts:{"createdAt":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}' (repeatable)`,
+	},
+}
+
+// runsFlags locate the run directories.
+var runsFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "runs-dir",
+		Value: "runs",
+		Usage: "directory each run's checkpoints, quarantined documents and reports are kept in",
+	},
+}
+
+// withFlags concatenates flag groups into one command's flags.
+func withFlags(groups ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, group := range groups {
+		flags = append(flags, group...)
+	}
+	return flags
+}
@@ -0,0 +1,307 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// historyTable records every migration run into a database, for the audit
+// trail of who moved which user data when. Like the environment stamp it sits
+// outside the versioned schema, so schema down can't drop it.
+const historyTable = "migration_runs"
+
+// secretFlags have their values left out of the recorded flags.
+var secretFlags = map[string]bool{
+	"mysql-uri":          true,
+	"mongodb-uri":        true,
+	"api-token":          true,
+	"notify-discord":     true,
+	"push-gateway":       true,
+	"sentry-dsn":         true,
+	"ssh-key-passphrase": true,
+}
+
+// runRecord is what the history keeps of one run.
+type runRecord struct {
+	ID          string                       `json:"id"`
+	StartedAt   time.Time                    `json:"startedAt"`
+	EndedAt     *time.Time                   `json:"endedAt,omitempty"`
+	Status      string                       `json:"status"`
+	Version     string                       `json:"version"`
+	Operator    string                       `json:"operator"`
+	Collections []string                     `json:"collections"`
+	Counts      map[string]collectionOutcome `json:"counts,omitempty"`
+	Error       string                       `json:"error,omitempty"`
+	Flags       map[string]string            `json:"flags"`
+}
+
+// collectionOutcome is how one collection of a recorded run fared.
+type collectionOutcome struct {
+	Migrated int `json:"migrated"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// runHistory keeps the record of the current run up to date as its start,
+// finish and error events come in: in the run directory's reports/run.json
+// always, and in the target's migration_runs table once db is set. A record
+// that can't be written is logged, as with any notification.
+type runHistory struct {
+	record runRecord
+	path   string
+	// db is the real target, nil for dry and trial runs
+	db *sql.DB
+}
+
+func newRunHistory(c *cli.Context, dir *runDir, selected []collectionMigration, started time.Time) *runHistory {
+	h := &runHistory{
+		record: runRecord{
+			ID:        dir.ID,
+			StartedAt: started.UTC(),
+			Status:    "running",
+			Version:   c.App.Version,
+			Operator:  operatorName(),
+			Flags:     map[string]string{},
+		},
+		path: filepath.Join(dir.reports(), "run.json"),
+	}
+	for _, cm := range selected {
+		h.record.Collections = append(h.record.Collections, cm.Name)
+	}
+	for _, f := range append(append([]cli.Flag{}, c.App.Flags...), c.Command.Flags...) {
+		name := f.Names()[0]
+		if !c.IsSet(name) {
+			continue
+		}
+		value := fmt.Sprint(c.Value(name))
+		if secretFlags[name] {
+			value = "redacted"
+		}
+		h.record.Flags[name] = value
+	}
+	return h
+}
+
+// operatorName identifies who started the run: the OS user, plus the start
+// of the hash of CLI_TOOLS_TOKEN when it is set, as the roles file does.
+func operatorName() string {
+	who := "unknown user"
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+	}
+	if token := os.Getenv("CLI_TOOLS_TOKEN"); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		who += " (token " + hex.EncodeToString(sum[:])[:8] + ")"
+	}
+	return who
+}
+
+// useTable records the run into db from now on, creating the table if needed.
+func (h *runHistory) useTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + historyTable + ` (
+    run_id VARCHAR(64) NOT NULL PRIMARY KEY,
+    started_at DATETIME NOT NULL,
+    ended_at DATETIME NULL,
+    status VARCHAR(16) NOT NULL,
+    tool_version VARCHAR(32) NOT NULL,
+    operator VARCHAR(255) NOT NULL,
+    collections TEXT NOT NULL,
+    counts JSON NULL,
+    error TEXT NULL,
+    flags JSON NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", historyTable, err)
+	}
+	h.db = db
+	return h.write()
+}
+
+func (h *runHistory) notify(e runEvent) error {
+	switch {
+	case e.Kind == eventStart:
+		h.record.Status = "running"
+	case e.Kind == eventError && e.Aborted:
+		h.record.Status = "aborted"
+	case e.Kind == eventError:
+		h.record.Status = "failed"
+	default:
+		h.record.Status = "finished"
+	}
+	if e.Kind != eventStart {
+		now := time.Now().UTC()
+		h.record.EndedAt = &now
+	}
+	if e.Err != nil {
+		h.record.Error = e.Err.Error()
+	}
+	if e.Run != nil {
+		h.record.Counts = map[string]collectionOutcome{}
+		for _, cm := range e.Selected {
+			h.record.Counts[cm.Name] = collectionOutcome{
+				Migrated: e.Run.migrated[cm.Name],
+				Skipped:  e.Run.skipped[cm.Name],
+				Failed:   e.Run.failed.counts[cm.Name],
+			}
+		}
+	}
+	return h.write()
+}
+
+// write saves the record to run.json and, when set, the table.
+func (h *runHistory) write() error {
+	data, err := json.MarshalIndent(h.record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(h.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %v", h.path, err)
+	}
+	if h.db == nil {
+		return nil
+	}
+	var counts interface{}
+	if h.record.Counts != nil {
+		data, _ := json.Marshal(h.record.Counts)
+		counts = string(data)
+	}
+	var ended interface{}
+	if h.record.EndedAt != nil {
+		ended = h.record.EndedAt.Format("2006-01-02 15:04:05")
+	}
+	flags, _ := json.Marshal(h.record.Flags)
+	_, err = h.db.Exec("REPLACE INTO "+historyTable+" (run_id, started_at, ended_at, status, tool_version, operator, collections, counts, error, flags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		h.record.ID, h.record.StartedAt.Format("2006-01-02 15:04:05"), ended, h.record.Status, h.record.Version, h.record.Operator,
+		strings.Join(h.record.Collections, ","), counts, nullString(h.record.Error), string(flags))
+	if err != nil {
+		return fmt.Errorf("error recording the run in %s: %v", historyTable, err)
+	}
+	return nil
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var historyCommand = &cli.Command{
+	Name:  "history",
+	Usage: "List the migration runs recorded in the target's migration_runs table, newest first",
+	Flags: withFlags(mysqlFlags, []cli.Flag{
+		&cli.IntFlag{
+			Name:  "last",
+			Value: 20,
+			Usage: "number of runs listed, 0 for all",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "table, or json for the full records",
+		},
+	}),
+	Action: listHistory,
+}
+
+func listHistory(c *cli.Context) error {
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown --format %q, expected table or json", format)
+	}
+	mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+	defer mysqlDB.Close()
+	exists, err := tableExists(mysqlDB, historyTable)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		logf(levelInfo, "No run was recorded in this database yet")
+		return nil
+	}
+	records, err := readHistory(mysqlDB, c.Int("last"))
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tDURATION\tSTATUS\tOPERATOR\tVERSION\tMIGRATED\tFAILED\tCOLLECTIONS")
+	for _, r := range records {
+		duration := "-"
+		if r.EndedAt != nil {
+			duration = r.EndedAt.Sub(r.StartedAt).String()
+		}
+		migrated, failed := 0, 0
+		for _, outcome := range r.Counts {
+			migrated += outcome.Migrated
+			failed += outcome.Failed
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", r.ID, r.StartedAt.Format(time.RFC3339), duration, r.Status,
+			r.Operator, r.Version, migrated, failed, strings.Join(r.Collections, ","))
+	}
+	return w.Flush()
+}
+
+// readHistory returns the last recorded runs, newest first; last 0 returns all.
+func readHistory(mysqlDB *sql.DB, last int) ([]runRecord, error) {
+	// Formatted in SQL so the scan works whether or not the DSN sets parseTime
+	query := "SELECT run_id, DATE_FORMAT(started_at, '%Y-%m-%d %H:%i:%s'), DATE_FORMAT(ended_at, '%Y-%m-%d %H:%i:%s'), status, tool_version, operator, collections, counts, error, flags FROM " + historyTable + " ORDER BY started_at DESC, run_id DESC"
+	if last > 0 {
+		query += fmt.Sprintf(" LIMIT %d", last)
+	}
+	rows, err := mysqlDB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", historyTable, err)
+	}
+	defer rows.Close()
+	var records []runRecord
+	for rows.Next() {
+		var r runRecord
+		var started string
+		var ended, counts, runErr sql.NullString
+		var collections, flags string
+		if err := rows.Scan(&r.ID, &started, &ended, &r.Status, &r.Version, &r.Operator, &collections, &counts, &runErr, &flags); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", historyTable, err)
+		}
+		if r.StartedAt, err = time.Parse("2006-01-02 15:04:05", started); err != nil {
+			return nil, fmt.Errorf("error reading %s: run %s: %v", historyTable, r.ID, err)
+		}
+		if ended.Valid {
+			t, err := time.Parse("2006-01-02 15:04:05", ended.String)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: run %s: %v", historyTable, r.ID, err)
+			}
+			r.EndedAt = &t
+		}
+		if collections != "" {
+			r.Collections = strings.Split(collections, ",")
+		}
+		if counts.Valid {
+			if err := json.Unmarshal([]byte(counts.String), &r.Counts); err != nil {
+				return nil, fmt.Errorf("error reading %s: run %s: %v", historyTable, r.ID, err)
+			}
+		}
+		r.Error = runErr.String
+		if err := json.Unmarshal([]byte(flags), &r.Flags); err != nil {
+			return nil, fmt.Errorf("error reading %s: run %s: %v", historyTable, r.ID, err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
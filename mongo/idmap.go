@@ -0,0 +1,158 @@
+package mongo
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idColumns lists, per table, the columns holding document IDs and the
+// collection each ID belongs to. With --uuid-ids every one of them is
+// rewritten, so a reference always carries the same UUID as its target.
+var idColumns = map[string]map[string]string{
+	"posts":      {"id": "posts", "author": "users"},
+	"users":      {"id": "users"},
+	"comments":   {"id": "comments", "post_id": "posts", "parent_id": "comments", "author": "users"},
+	"post_heart": {"post_id": "posts", "user_id": "users"},
+	"user_links": {"user_id": "users"},
+}
+
+// idMap replaces document IDs with UUIDv7s. Every assignment is stored in the
+// id_map table before the first rows using it are written, so later runs,
+// resumed runs and imports hand out the same UUID for the same document. A
+// nil *idMap leaves IDs untouched.
+type idMap struct {
+	// mu guards the connection and assignments, which rows are rewritten
+	// with outside the goroutine that reconnects
+	mu      sync.Mutex
+	mysqlDB *sql.DB
+	retry   *retrier
+	uuids   map[string]string
+	// unstored are the keys of uuids not in id_map yet
+	unstored map[string]bool
+	// frozen translates only the IDs id_map holds and leaves the others as
+	// they are, for diff
+	frozen bool
+}
+
+// loadIDMap reads the assignments earlier runs made.
+func loadIDMap(mysqlDB *sql.DB, retry *retrier) (*idMap, error) {
+	rows, err := mysqlDB.Query("SELECT collection, object_id, uuid FROM id_map")
+	if err != nil {
+		return nil, fmt.Errorf("error loading id_map: %v", err)
+	}
+	defer rows.Close()
+	ids := &idMap{mysqlDB: mysqlDB, retry: retry, uuids: map[string]string{}, unstored: map[string]bool{}}
+	for rows.Next() {
+		var collection, objectID, uuid string
+		if err := rows.Scan(&collection, &objectID, &uuid); err != nil {
+			return nil, fmt.Errorf("error loading id_map: %v", err)
+		}
+		ids.uuids[collection+"/"+objectID] = uuid
+	}
+	return ids, rows.Err()
+}
+
+// rewrite replaces the IDs in rows with their UUIDs, assigning new ones as
+// needed. The assignments still to be stored are added to l.
+func (ids *idMap) rewrite(rows []tableRow, l *ledger) ([]tableRow, error) {
+	if ids == nil {
+		return rows, nil
+	}
+	for _, row := range rows {
+		columns := idColumns[row.Table]
+		for i, col := range row.Columns {
+			collection, ok := columns[col]
+			if !ok {
+				continue
+			}
+			id, ok := row.Values[i].(string)
+			if !ok || id == "" {
+				continue
+			}
+			uuid, err := ids.uuid(collection, id, l)
+			if err != nil {
+				return nil, err
+			}
+			row.Values[i] = uuid
+		}
+	}
+	return rows, nil
+}
+
+// uuid returns the UUID of a document, assigning one if it has none yet.
+// The assignment is only kept in memory until store writes it.
+func (ids *idMap) uuid(collection, objectID string, l *ledger) (string, error) {
+	key := collection + "/" + objectID
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	uuid, ok := ids.uuids[key]
+	if !ok && ids.frozen {
+		return objectID, nil
+	}
+	if !ok {
+		var err error
+		if uuid, err = newUUIDv7(objectID); err != nil {
+			return "", err
+		}
+		ids.uuids[key] = uuid
+		ids.unstored[key] = true
+	}
+	if ids.unstored[key] {
+		l.assigned = append(l.assigned, key)
+	}
+	return uuid, nil
+}
+
+// store writes the assignments of keys that aren't in id_map yet.
+func (ids *idMap) store(keys []string) error {
+	if ids == nil {
+		return nil
+	}
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	for _, key := range keys {
+		if !ids.unstored[key] {
+			continue
+		}
+		collection, objectID, _ := strings.Cut(key, "/")
+		err := ids.retry.do("insert", func() error {
+			_, err := ids.mysqlDB.Exec("INSERT INTO id_map (collection, object_id, uuid) VALUES (?, ?, ?)", collection, objectID, ids.uuids[key])
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error recording UUID of %s %s: %w", collection, objectID, err)
+		}
+		delete(ids.unstored, key)
+	}
+	return nil
+}
+
+// newUUIDv7 returns a random UUIDv7. When objectID is an ObjectID its
+// creation time is used as the UUID's timestamp, so UUIDs sort like the
+// documents were created.
+func newUUIDv7(objectID string) (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		return "", err
+	}
+	created := time.Now()
+	if oid, err := primitive.ObjectIDFromHex(objectID); err == nil {
+		created = oid.Timestamp()
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(created.UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
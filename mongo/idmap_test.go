@@ -0,0 +1,91 @@
+package mongo
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewUUIDv7(t *testing.T) {
+	created := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	tests := []struct {
+		name     string
+		objectID string
+		// prefix is the hex of the 48-bit millisecond timestamp, "" when
+		// it is the current time
+		prefix string
+	}{
+		{name: "ObjectID", objectID: primitive.NewObjectIDFromTimestamp(created).Hex(), prefix: "01875006-aee0"},
+		{name: "epoch ObjectID", objectID: "000000000000000000000000", prefix: "00000000-0000"},
+		{name: "string ID", objectID: "user-1"},
+		{name: "numeric ID", objectID: "42"},
+	}
+	for _, tt := range tests {
+		u, err := newUUIDv7(tt.objectID)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !uuidV7Pattern.MatchString(u) {
+			t.Errorf("%s: %s isn't a UUIDv7 with the RFC 9562 variant", tt.name, u)
+		}
+		if tt.prefix != "" && u[:13] != tt.prefix {
+			t.Errorf("%s: %s doesn't start with the ObjectID's time %s", tt.name, u, tt.prefix)
+		}
+		if other, _ := newUUIDv7(tt.objectID); other == u {
+			t.Errorf("%s: two UUIDs for the same ID are both %s", tt.name, u)
+		}
+	}
+}
+
+func TestNewUUIDv7Ordering(t *testing.T) {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ids, uuids []string
+	for _, offset := range []time.Duration{0, time.Second, time.Minute, time.Hour, 24 * time.Hour, 365 * 24 * time.Hour} {
+		ids = append(ids, primitive.NewObjectIDFromTimestamp(base.Add(offset)).Hex())
+	}
+	for _, id := range ids {
+		u, err := newUUIDv7(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uuids = append(uuids, u)
+	}
+	if !sort.StringsAreSorted(uuids) {
+		t.Errorf("UUIDs of ObjectIDs created one after the other don't sort in that order: %v", uuids)
+	}
+}
+
+func TestIDMapRewriteFrozen(t *testing.T) {
+	ids := &idMap{
+		uuids:    map[string]string{"users/u1": "0190b0a1-0000-7000-8000-000000000001", "posts/p1": "0190b0a1-0000-7000-8000-000000000002"},
+		unstored: map[string]bool{},
+		frozen:   true,
+	}
+	rows := []tableRow{
+		{Table: "posts", Columns: []string{"id", "author", "content"}, Values: []interface{}{"p1", "u2", "u1"}},
+		{Table: "post_heart", Columns: []string{"post_id", "user_id"}, Values: []interface{}{"p1", "u1"}},
+	}
+	l := &ledger{}
+	if _, err := ids.rewrite(rows, l); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		// Only ID columns are rewritten, and IDs id_map doesn't hold are left alone
+		{"0190b0a1-0000-7000-8000-000000000002", "u2", "u1"},
+		{"0190b0a1-0000-7000-8000-000000000002", "0190b0a1-0000-7000-8000-000000000001"},
+	}
+	for i, row := range rows {
+		if !reflect.DeepEqual(row.Values, want[i]) {
+			t.Errorf("%s = %v, want %v", row.Table, row.Values, want[i])
+		}
+	}
+	if len(ids.uuids) != 2 || len(l.assigned) != 0 {
+		t.Errorf("a frozen id_map assigned %v", l.assigned)
+	}
+}
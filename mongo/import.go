@@ -0,0 +1,356 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var importCommand = &cli.Command{
+	Name:  "import",
+	Usage: "Load files into MySQL, upserting rows that already exist",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "documents",
+			Usage: "Migrate an NDJSON file of source documents, such as mongoexport output",
+			Flags: withFlags(mysqlFlags, retryFlags, normalizeFlags, dateFlags, rewriteFlags, transferFlags, mediaFlags, runsFlags, []cli.Flag{
+				&cli.StringFlag{Name: "collection", Usage: "collection the documents belong to"},
+				&cli.StringFlag{Name: "file", Usage: "NDJSON file with one extended JSON document per line"},
+			}),
+			Action:       importDocuments,
+			BashComplete: completeCollections(true, "collection"),
+		},
+		{
+			Name:  "rows",
+			Usage: "Load a table file written by export collections",
+			Flags: withFlags(mysqlFlags, retryFlags, []cli.Flag{
+				&cli.StringFlag{Name: "table", Usage: "target table (default: the file name without extension)"},
+				&cli.StringFlag{Name: "file", Usage: "NDJSON or CSV table file"},
+			}),
+			Action: importRows,
+		},
+	},
+}
+
+// importDocuments runs the documents of a file through the same checks,
+// transfer function and dead-letter handling as the live migration.
+func importDocuments(c *cli.Context) error {
+	path := c.String("file")
+	if path == "" || c.String("collection") == "" {
+		return fmt.Errorf("--collection and --file are required")
+	}
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	selected, err := selectCollections(cfg.migrations(), c.String("collection"), "")
+	if err != nil {
+		return err
+	}
+	if c.Bool("normalize-comments") {
+		selected = coverFields(selected, "posts", "comments")
+	}
+	if c.Bool("normalize-hearts") {
+		selected = coverFields(selected, "posts", "hearts")
+	}
+	if c.Bool("normalize-links") {
+		selected = coverFields(selected, "users", "links")
+	}
+	cm := selected[0]
+	dates, err := newDateParser(cfg.dateLayouts(), c.String("default-timezone"))
+	if err != nil {
+		return err
+	}
+	if err := validTxPer(c.String("tx-per")); err != nil {
+		return err
+	}
+	if c.Int("max-in-flight") < 1 {
+		return fmt.Errorf("--max-in-flight must be at least 1")
+	}
+	writes, err := newRateLimiter("write-rate", c.Float64("write-rate"))
+	if err != nil {
+		return err
+	}
+
+	cursor, err := openFileCursor(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer cursor.Close(context.TODO())
+
+	retry := newRetrier(c.Context, c.Int("max-retries"), c.Duration("retry-delay"))
+	defer retry.summary()
+
+	mysqlDB := connectMySQL(c.String("mysql-uri"), retry)
+	defer mysqlDB.Close()
+	target := &mysqlTarget{db: mysqlDB}
+	if err := target.CreateSchema(nil); err != nil {
+		return err
+	}
+	for _, mapping := range cfg.Mappings {
+		if mapping.CreateTable && mapping.Collection == cm.Name {
+			if _, err := mysqlDB.Exec(mapping.createTableStatement()); err != nil {
+				return fmt.Errorf("error creating table %s: %v", mapping.Table, err)
+			}
+		}
+	}
+
+	dir, err := newRunDir(c.String("runs-dir"))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	var passwords *passwordPolicy
+	if policy := c.String("password-policy"); policy != "" {
+		if passwords, err = newPasswordPolicy(policy, filepath.Join(dir.reports(), "passwords.ndjson")); err != nil {
+			return err
+		}
+		defer passwords.Close()
+	}
+
+	valid, err := newValidator(cfg.validations(), c.Bool("fail-on-invalid"), filepath.Join(dir.reports(), "invalid_values.ndjson"))
+	if err != nil {
+		return err
+	}
+	defer valid.Close()
+
+	var store *objectStore
+	if c.Bool("upload-media") {
+		if store = cfg.MediaStore; store == nil {
+			return fmt.Errorf("--upload-media needs a mediaStore in the config file")
+		}
+	}
+	media, err := newMediaAuditor(cfg.MediaHosts, store, c.Bool("check-media"), c.Int("media-concurrency"), c.Duration("media-timeout"), c.Duration("media-upload-timeout"), filepath.Join(dir.reports(), "broken_media.ndjson"))
+	if err != nil {
+		return err
+	}
+	defer media.Close()
+
+	var ips *ipScrubber
+	if policy := c.String("scrub-ips"); policy != "" {
+		if ips, err = newIPScrubber(policy, filepath.Join(dir.reports(), "ip_findings.ndjson")); err != nil {
+			return err
+		}
+		defer ips.Close()
+	}
+
+	failed := newDeadLetter(dir.quarantine())
+	defer failed.Close()
+
+	run := &migrator{
+		mysqlDB:    mysqlDB,
+		target:     target,
+		retry:      retry,
+		failed:     failed,
+		migrated:   map[string]int{},
+		skipped:    map[string]int{},
+		unknown:    map[string]map[string]int{},
+		strict:     c.Bool("strict"),
+		comments:   c.Bool("normalize-comments"),
+		hearts:     c.Bool("normalize-hearts"),
+		links:      c.Bool("normalize-links"),
+		ips:        ips,
+		valid:      valid,
+		media:      media,
+		dates:      dates,
+		transforms: cfg.Transforms,
+		passwords:  passwords,
+		upsert:     true,
+		txPer:      c.String("tx-per"),
+		writes:     writes,
+
+		maxInFlight: c.Int("max-in-flight"),
+	}
+	if c.Bool("uuid-ids") {
+		if run.ids, err = loadIDMap(mysqlDB, retry); err != nil {
+			return err
+		}
+	}
+	logf(levelInfo, "Importing %s from %s", cm.Name, path)
+	if err := run.migrateCollection(c.Context, cm, cursor); err != nil {
+		return fmt.Errorf("error importing %s: %v", path, err)
+	}
+	run.summary(selected)
+	media.summary()
+	valid.summary()
+	ips.summary()
+	passwords.summary()
+
+	if n := failed.total(); n > 0 && c.Bool("strict") {
+		return cli.Exit(fmt.Sprintf("%d document(s) failed to transfer", n), 1)
+	}
+	if n := valid.refusedCount(); n > 0 {
+		return cli.Exit(fmt.Sprintf("%d document(s) had invalid values", n), 1)
+	}
+	return nil
+}
+
+// importRows loads a table file row by row. Files come from export
+// collections, so column names are the table's own and values need no
+// transfer function.
+func importRows(c *cli.Context) error {
+	path := c.String("file")
+	if path == "" {
+		return fmt.Errorf("--file is required")
+	}
+	ext := filepath.Ext(path)
+	if ext != ".ndjson" && ext != ".csv" {
+		return fmt.Errorf("can't tell the format of %s, expected a .ndjson or .csv file", path)
+	}
+	table := c.String("table")
+	if table == "" {
+		table = strings.TrimSuffix(filepath.Base(path), ext)
+	}
+	if !identifierPattern.MatchString(table) {
+		return fmt.Errorf("invalid table name %q", table)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer f.Close()
+
+	retry := newRetrier(c.Context, c.Int("max-retries"), c.Duration("retry-delay"))
+	defer retry.summary()
+
+	mysqlDB := connectMySQL(c.String("mysql-uri"), retry)
+	defer mysqlDB.Close()
+	target := &mysqlTarget{db: mysqlDB}
+	if err := target.CreateSchema(nil); err != nil {
+		return err
+	}
+
+	run := &migrator{mysqlDB: mysqlDB, target: target, retry: retry, upsert: true}
+	read := readNDJSONRows
+	if ext == ".csv" {
+		read = readCSVRows
+	}
+	imported := 0
+	err = read(f, table, func(row tableRow) error {
+		positioned := false
+		for _, col := range row.Columns {
+			if !identifierPattern.MatchString(col) {
+				return fmt.Errorf("invalid column name %q", col)
+			}
+			positioned = positioned || col == "position"
+		}
+		// Files exported before entries had a position would upsert every
+		// entry of a blog over its first one
+		if table == "blog_entries" && !positioned {
+			return fmt.Errorf("no position column; export the blog entries again")
+		}
+		if err := run.insertRows(c.Context, []tableRow{row}); err != nil {
+			return err
+		}
+		imported++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error importing %s row %d: %v", path, imported+1, err)
+	}
+	logf(levelInfo, "%s: %d imported", table, imported)
+	return nil
+}
+
+// readNDJSONRows reads one JSON object per line. Nested objects and arrays
+// are JSON columns and are stored as their encoding.
+func readNDJSONRows(r io.Reader, table string, fn func(tableRow) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		row := tableRow{Table: table}
+		for col := range record {
+			row.Columns = append(row.Columns, col)
+		}
+		sort.Strings(row.Columns)
+		for _, col := range row.Columns {
+			value, err := importJSONValue(record[col])
+			if err != nil {
+				return fmt.Errorf("column %s: %v", col, err)
+			}
+			row.Values = append(row.Values, value)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func importJSONValue(raw json.RawMessage) (interface{}, error) {
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return nil, nil
+	case raw[0] == '{' || raw[0] == '[':
+		return string(raw), nil
+	case raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return importText(s), nil
+	case string(raw) == "true" || string(raw) == "false":
+		return string(raw) == "true", nil
+	default:
+		// Numbers are passed on as written so large IDs keep their precision
+		return string(raw), nil
+	}
+}
+
+// readCSVRows reads a header line of column names followed by the rows.
+// Empty cells load as NULL, matching how the exporter writes them.
+func readCSVRows(r io.Reader, table string, fn func(tableRow) error) error {
+	reader := csv.NewReader(r)
+	columns, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("error reading header: %v", err)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := tableRow{Table: table, Columns: columns, Values: make([]interface{}, len(record))}
+		for i, cell := range record {
+			if cell != "" {
+				row.Values[i] = importText(cell)
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// importText turns the RFC 3339 timestamps the exporter writes back into
+// times, since MySQL won't take them as DATETIME text.
+func importText(s string) interface{} {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC()
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// indexDefinition is a secondary index on a migrated table.
+type indexDefinition struct {
+	Name    string   `json:"name" doc:"index name, unique per table"`
+	Table   string   `json:"table" doc:"table the index is built on"`
+	Columns []string `json:"columns" doc:"indexed columns, optionally with a prefix length such as bio(100)"`
+	Unique  bool     `json:"unique" default:"false" doc:"create a UNIQUE index"`
+}
+
+// defaultIndexes are built when the config doesn't list its own indexes.
+var defaultIndexes = []indexDefinition{
+	{Name: "users_username_idx", Table: "users", Columns: []string{"username"}},
+	{Name: "users_email_idx", Table: "users", Columns: []string{"email"}},
+	{Name: "posts_created_at_idx", Table: "posts", Columns: []string{"created_at"}},
+	{Name: "posts_author_idx", Table: "posts", Columns: []string{"author"}},
+}
+
+var indexColumnPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(\(\d+\))?$`)
+
+func (idx indexDefinition) validate() error {
+	if !identifierPattern.MatchString(idx.Name) || !identifierPattern.MatchString(idx.Table) {
+		return fmt.Errorf("index %q on %q: invalid name", idx.Name, idx.Table)
+	}
+	if len(idx.Columns) == 0 {
+		return fmt.Errorf("index %s: no columns", idx.Name)
+	}
+	for _, col := range idx.Columns {
+		if !indexColumnPattern.MatchString(col) {
+			return fmt.Errorf("index %s: invalid column %q", idx.Name, col)
+		}
+	}
+	return nil
+}
+
+func (idx indexDefinition) createStatement() string {
+	columns := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		m := indexColumnPattern.FindStringSubmatch(col)
+		columns[i] = "`" + m[1] + "`" + m[2]
+	}
+	kind := "INDEX"
+	if idx.Unique {
+		kind = "UNIQUE INDEX"
+	}
+	return fmt.Sprintf("CREATE %s `%s` ON `%s` (%s)", kind, idx.Name, idx.Table, strings.Join(columns, ", "))
+}
+
+// createIndexes builds the indexes that don't exist yet. Indexes on tables
+// that aren't there (a mapped collection that was never migrated) are skipped.
+func createIndexes(mysqlDB *sql.DB, indexes []indexDefinition, changes *schemaChangelog) error {
+	for _, idx := range indexes {
+		exists, err := tableExists(mysqlDB, idx.Table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			logf(levelWarn, "Not creating index %s: table %s doesn't exist", idx.Name, idx.Table)
+			changes.skipped("index %s: table %s doesn't exist", idx.Name, idx.Table)
+			continue
+		}
+		var existing int
+		err = mysqlDB.QueryRow(
+			"SELECT count(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?",
+			idx.Table, idx.Name,
+		).Scan(&existing)
+		if err != nil {
+			return fmt.Errorf("error looking up index %s: %v", idx.Name, err)
+		}
+		if existing > 0 {
+			changes.kept("index %s on %s already existed", idx.Name, idx.Table)
+			continue
+		}
+
+		logf(levelInfo, "Creating index %s on %s (%s)", idx.Name, idx.Table, strings.Join(idx.Columns, ", "))
+		if _, err := mysqlDB.Exec(idx.createStatement()); err != nil {
+			return fmt.Errorf("error creating index %s: %v", idx.Name, err)
+		}
+		changes.changed("created index %s on %s (%s)", idx.Name, idx.Table, strings.Join(idx.Columns, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var inferSchemaCommand = &cli.Command{
+	Name:  "infer-schema",
+	Usage: "Sample a MongoDB collection and print a CREATE TABLE statement and transfer function stub for it",
+	Flags: withFlags(mongoFlags, []cli.Flag{
+		&cli.StringFlag{Name: "collection", Usage: "collection to sample"},
+		&cli.StringFlag{Name: "table", Usage: "MySQL table name (default: the collection name)"},
+		&cli.IntFlag{Name: "sample", Value: 500, Usage: "number of documents to sample"},
+	}),
+	Action:       inferSchema,
+	BashComplete: completeCollections(false, "collection"),
+}
+
+// inferredField accumulates what the sampled documents told us about one top-level field.
+type inferredField struct {
+	Name    string
+	Types   map[bsontype.Type]bool
+	Seen    int
+	Nulls   int
+	MaxText int
+}
+
+func inferSchema(c *cli.Context) error {
+	collection := c.String("collection")
+	if collection == "" {
+		return fmt.Errorf("--collection is required")
+	}
+	table := c.String("table")
+	if table == "" {
+		table = collection
+	}
+
+	source, err := newMongoSource(c.Context, c.String("mongodb-uri"), nil)
+	if err != nil {
+		return err
+	}
+	defer source.Close(context.TODO())
+
+	fields, sampled, err := sampleFields(c.Context, source.db.Collection(collection), c.Int("sample"))
+	if err != nil {
+		return err
+	}
+	if sampled == 0 {
+		return fmt.Errorf("collection %s is empty", collection)
+	}
+
+	fmt.Printf("-- Inferred from %d sampled document(s) of %s\n", sampled, collection)
+	fmt.Println(createTableStatement(table, fields, sampled))
+	fmt.Println()
+	fmt.Print(transferStub(collection, table, fields))
+	return nil
+}
+
+// sampleFields reads up to n random documents and records the types seen for each top-level field.
+func sampleFields(ctx context.Context, coll *mongo.Collection, n int) ([]*inferredField, int, error) {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$sample", Value: bson.D{{Key: "size", Value: n}}}}})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error sampling %s: %v", coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	byName := map[string]*inferredField{}
+	var order []string
+	sampled := 0
+	for cursor.Next(ctx) {
+		sampled++
+		elems, err := cursor.Current.Elements()
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading document: %v", err)
+		}
+		for _, elem := range elems {
+			f, ok := byName[elem.Key()]
+			if !ok {
+				f = &inferredField{Name: elem.Key(), Types: map[bsontype.Type]bool{}}
+				byName[elem.Key()] = f
+				order = append(order, elem.Key())
+			}
+			f.Seen++
+			v := elem.Value()
+			switch v.Type {
+			case bsontype.Null, bsontype.Undefined:
+				f.Nulls++
+				continue
+			case bsontype.String:
+				if l := len(v.StringValue()); l > f.MaxText {
+					f.MaxText = l
+				}
+			}
+			f.Types[v.Type] = true
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Keep _id first and the rest in the order they were first seen
+	fields := make([]*inferredField, 0, len(order))
+	for _, name := range order {
+		fields = append(fields, byName[name])
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Name == "_id" && fields[j].Name != "_id" })
+	return fields, sampled, nil
+}
+
+// kind collapses the BSON types seen for a field into one of the column kinds below.
+func (f *inferredField) kind() string {
+	var kinds []string
+	seen := map[string]bool{}
+	for t := range f.Types {
+		k := "mixed"
+		switch t {
+		case bsontype.String:
+			k = "string"
+		case bsontype.ObjectID:
+			k = "objectid"
+		case bsontype.Int32:
+			k = "int32"
+		case bsontype.Int64:
+			k = "int64"
+		case bsontype.Double, bsontype.Decimal128:
+			k = "double"
+		case bsontype.Boolean:
+			k = "bool"
+		case bsontype.DateTime, bsontype.Timestamp:
+			k = "datetime"
+		case bsontype.Array, bsontype.EmbeddedDocument:
+			k = "json"
+		}
+		if !seen[k] {
+			seen[k] = true
+			kinds = append(kinds, k)
+		}
+	}
+	switch {
+	case len(kinds) == 0:
+		return "mixed"
+	case len(kinds) == 1:
+		return kinds[0]
+	case len(kinds) == 2 && seen["int32"] && seen["int64"]:
+		return "int64"
+	case len(kinds) == 2 && seen["double"] && (seen["int32"] || seen["int64"]):
+		return "double"
+	default:
+		return "mixed"
+	}
+}
+
+func (f *inferredField) column() string {
+	if f.Name == "_id" {
+		return "id"
+	}
+	return snakeCase(f.Name)
+}
+
+func (f *inferredField) sqlType() string {
+	if f.Name == "_id" && f.kind() == "mixed" {
+		// mixedID stores any _id as text
+		return "VARCHAR(64)"
+	}
+	switch f.kind() {
+	case "string":
+		if f.MaxText <= 255 {
+			return "VARCHAR(255)"
+		}
+		return "TEXT"
+	case "objectid":
+		return "VARCHAR(24)"
+	case "int32":
+		return "INT"
+	case "int64":
+		return "BIGINT"
+	case "double":
+		return "DOUBLE"
+	case "bool":
+		return "BOOLEAN"
+	case "datetime":
+		return "DATETIME"
+	case "json":
+		return "JSON"
+	default:
+		// Mixed types are stored as their JSON encoding
+		return "JSON"
+	}
+}
+
+func (f *inferredField) goType() string {
+	if f.Name == "_id" && f.kind() == "mixed" {
+		return "mixedID"
+	}
+	switch f.kind() {
+	case "string":
+		return "string"
+	case "objectid":
+		return "primitive.ObjectID"
+	case "int32":
+		return "int32"
+	case "int64":
+		return "int64"
+	case "double":
+		return "float64"
+	case "bool":
+		return "bool"
+	case "datetime":
+		return "time.Time"
+	default:
+		return "interface{}"
+	}
+}
+
+func createTableStatement(table string, fields []*inferredField, sampled int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (\n", table)
+	for i, f := range fields {
+		fmt.Fprintf(&b, "    %s %s", f.column(), f.sqlType())
+		switch {
+		case f.Name == "_id":
+			b.WriteString(" NOT NULL PRIMARY KEY")
+		case f.Seen == sampled && f.Nulls == 0:
+			b.WriteString(" NOT NULL")
+		}
+		if i < len(fields)-1 {
+			b.WriteString(",")
+		}
+		if f.kind() == "mixed" {
+			b.WriteString(" -- mixed types in sample")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(");")
+	return b.String()
+}
+
+// transferStub generates a struct and rows function in the style of mongotomysql.go.
+func transferStub(collection, table string, fields []*inferredField) string {
+	typeName := goName(strings.TrimSuffix(collection, "s"))
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "type %s struct {\n", typeName)
+	for _, f := range fields {
+		fmt.Fprintf(&b, "\t%s %s `bson:\"%s\" json:\"%s\"`\n", fieldGoName(f), f.goType(), f.Name, f.Name)
+	}
+	b.WriteString("}\n\n")
+
+	var columns, args []string
+	for _, f := range fields {
+		columns = append(columns, fmt.Sprintf("%q", f.column()))
+		switch f.goType() {
+		case "primitive.ObjectID":
+			args = append(args, "doc."+fieldGoName(f)+".Hex()")
+		case "mixedID":
+			args = append(args, "string(doc."+fieldGoName(f)+")")
+		case "interface{}":
+			args = append(args, "jsonValue{doc."+fieldGoName(f)+"}")
+		default:
+			args = append(args, "doc."+fieldGoName(f))
+		}
+	}
+
+	label := strings.ToLower(typeName)
+	fmt.Fprintf(&b, "func (m *migrator) %sRows(cursor documentCursor) ([]tableRow, error) {\n", label)
+	fmt.Fprintf(&b, "\tvar doc %s\n", typeName)
+	b.WriteString("\tif err := cursor.Decode(&doc); err != nil {\n")
+	fmt.Fprintf(&b, "\t\treturn nil, fmt.Errorf(\"error decoding %s: %%v\", err)\n", label)
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn []tableRow{{\n")
+	fmt.Fprintf(&b, "\t\tTable:   %q,\n", table)
+	fmt.Fprintf(&b, "\t\tColumns: []string{%s},\n", strings.Join(columns, ", "))
+	fmt.Fprintf(&b, "\t\tValues:  []interface{}{%s},\n", strings.Join(args, ", "))
+	b.WriteString("\t}}, nil\n")
+	b.WriteString("}\n")
+
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return b.String()
+	}
+	return string(formatted)
+}
+
+func fieldGoName(f *inferredField) string {
+	if f.Name == "_id" {
+		return "ID"
+	}
+	return goName(f.Name)
+}
+
+// goName turns a field name like "profile_picture" or "imageUrl" into "ProfilePicture" / "ImageURL".
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' || r == '-' || r == ' ' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	for _, initialism := range []string{"Id", "Url"} {
+		if strings.HasSuffix(s, initialism) {
+			s = strings.TrimSuffix(s, initialism) + strings.ToUpper(initialism)
+		}
+	}
+	return s
+}
+
+// snakeCase turns "profilePicture" into "profile_picture".
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		if r == '-' || r == ' ' {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
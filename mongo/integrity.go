@@ -0,0 +1,132 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// foreignKey is a reference between two migrated tables.
+type foreignKey struct {
+	Name      string
+	Table     string
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+// foreignKeys lists the references between migrated tables. They are checked
+// by check-integrity and created as constraints with --foreign-keys.
+var foreignKeys = []foreignKey{
+	{Name: "posts_author_fk", Table: "posts", Column: "author", RefTable: "users", RefColumn: "id"},
+	{Name: "comments_post_fk", Table: "comments", Column: "post_id", RefTable: "posts", RefColumn: "id"},
+	{Name: "comments_author_fk", Table: "comments", Column: "author", RefTable: "users", RefColumn: "id"},
+	{Name: "post_heart_post_fk", Table: "post_heart", Column: "post_id", RefTable: "posts", RefColumn: "id"},
+	{Name: "post_heart_user_fk", Table: "post_heart", Column: "user_id", RefTable: "users", RefColumn: "id"},
+	{Name: "user_links_user_fk", Table: "user_links", Column: "user_id", RefTable: "users", RefColumn: "id"},
+	{Name: "blog_entries_blog_fk", Table: "blog_entries", Column: "blog_slug", RefTable: "blogs", RefColumn: "slug"},
+}
+
+func (fk foreignKey) String() string {
+	return fmt.Sprintf("%s.%s -> %s.%s", fk.Table, fk.Column, fk.RefTable, fk.RefColumn)
+}
+
+var checkIntegrityCommand = &cli.Command{
+	Name:  "check-integrity",
+	Usage: "Report rows whose references point at missing rows",
+	Flags: withFlags(mysqlFlags, []cli.Flag{
+		&cli.IntFlag{Name: "examples", Value: 10, Usage: "number of orphaned values listed per reference"},
+	}),
+	Action: func(c *cli.Context) error {
+		mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+		defer mysqlDB.Close()
+
+		broken := 0
+		for _, fk := range foreignKeys {
+			count, examples, err := orphans(mysqlDB, fk, c.Int("examples"))
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				logf(levelInfo, "ok   %s", fk)
+				continue
+			}
+			broken++
+			logf(levelError, "FAIL %s: %d orphaned row(s), e.g. %s", fk, count, strings.Join(examples, ", "))
+		}
+		if broken > 0 {
+			return cli.Exit(fmt.Sprintf("%d reference(s) have orphaned rows", broken), 1)
+		}
+		return nil
+	},
+}
+
+// orphans counts the rows of fk.Table whose fk.Column matches no row of fk.RefTable,
+// returning up to limit of the dangling values.
+func orphans(mysqlDB *sql.DB, fk foreignKey, limit int) (int, []string, error) {
+	from := fmt.Sprintf("FROM %s t LEFT JOIN %s r ON r.%s = t.%s WHERE t.%s IS NOT NULL AND r.%s IS NULL",
+		fk.Table, fk.RefTable, fk.RefColumn, fk.Column, fk.Column, fk.RefColumn)
+
+	var count int
+	if err := mysqlDB.QueryRow("SELECT count(*) " + from).Scan(&count); err != nil {
+		return 0, nil, fmt.Errorf("error checking %s: %v", fk, err)
+	}
+	if count == 0 || limit <= 0 {
+		return count, nil, nil
+	}
+
+	rows, err := mysqlDB.Query(fmt.Sprintf("SELECT DISTINCT t.%s %s LIMIT %d", fk.Column, from, limit))
+	if err != nil {
+		return 0, nil, fmt.Errorf("error checking %s: %v", fk, err)
+	}
+	defer rows.Close()
+	var examples []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return 0, nil, err
+		}
+		examples = append(examples, fmt.Sprintf("%q", value))
+	}
+	return count, examples, rows.Err()
+}
+
+// addForeignKeys creates the constraints in foreignKeys that don't exist yet.
+// References with orphaned rows can't be constrained and are skipped with a warning.
+func addForeignKeys(mysqlDB *sql.DB, changes *schemaChangelog) error {
+	for _, fk := range foreignKeys {
+		var exists int
+		err := mysqlDB.QueryRow(
+			"SELECT count(*) FROM information_schema.TABLE_CONSTRAINTS WHERE CONSTRAINT_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = ?",
+			fk.Table, fk.Name,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error looking up constraint %s: %v", fk.Name, err)
+		}
+		if exists > 0 {
+			changes.kept("foreign key %s (%s) already existed", fk.Name, fk)
+			continue
+		}
+
+		count, _, err := orphans(mysqlDB, fk, 0)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			logf(levelWarn, "Not adding foreign key %s: %d orphaned row(s), see check-integrity", fk, count)
+			changes.skipped("foreign key %s (%s): %d orphaned row(s)", fk.Name, fk, count)
+			continue
+		}
+
+		logf(levelInfo, "Adding foreign key %s", fk)
+		_, err = mysqlDB.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+			fk.Table, fk.Name, fk.Column, fk.RefTable, fk.RefColumn))
+		if err != nil {
+			return fmt.Errorf("error adding foreign key %s: %v", fk, err)
+		}
+		changes.changed("added foreign key %s (%s)", fk.Name, fk)
+	}
+	return nil
+}
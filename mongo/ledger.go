@@ -0,0 +1,49 @@
+package mongo
+
+// ledger holds what preparing one document found out about it until the
+// writer knows what became of the document. Documents are prepared ahead of
+// being written, so one may be prepared again after a drain or a rollback,
+// or not written at all once its collection reached --limit. The counts and
+// report lines queued here are applied once the document is committed or
+// quarantined, and dropped with it otherwise, so every document is accounted
+// for once.
+type ledger struct {
+	entries []func() error
+	// assigned are the id_map keys of new UUIDs the document's rows use,
+	// stored before the rows are written
+	assigned []string
+	// copies are the media copies the rows wait for before they are written
+	copies []*mediaCopy
+}
+
+// add queues f. A nil ledger, as outside a migration, runs it right away.
+func (l *ledger) add(f func() error) error {
+	if l == nil {
+		return f()
+	}
+	l.entries = append(l.entries, f)
+	return nil
+}
+
+// settle takes the ledger of a document the writer is done with. Its
+// entries apply at the next commit, or right away when no transaction can
+// roll the document back.
+func (m *migrator) settle(l *ledger) error {
+	m.unsettled = append(m.unsettled, l.entries...)
+	if m.transactional() {
+		return nil
+	}
+	return m.applyLedger()
+}
+
+// applyLedger applies the entries of the documents settled since the last commit.
+func (m *migrator) applyLedger() error {
+	entries := m.unsettled
+	m.unsettled = nil
+	for _, f := range entries {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
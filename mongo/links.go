@@ -0,0 +1,87 @@
+package mongo
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters that only identify where a click came
+// from; they are stripped from user links. Keys ending in a "*" match by prefix.
+var trackingParams = []string{"utm_*", "fbclid", "gclid", "igshid", "mc_eid", "ref_src", "si"}
+
+// linkPlatforms maps the hosts of profile sites to the platform the UI shows
+// an icon for. Any other host is a "website".
+var linkPlatforms = map[string]string{
+	"twitter.com":   "twitter",
+	"x.com":         "twitter",
+	"github.com":    "github",
+	"gitlab.com":    "gitlab",
+	"linkedin.com":  "linkedin",
+	"youtube.com":   "youtube",
+	"youtu.be":      "youtube",
+	"instagram.com": "instagram",
+	"twitch.tv":     "twitch",
+	"discord.gg":    "discord",
+	"discord.com":   "discord",
+}
+
+// normalizeLink cleans up a link as users typed it: a missing or http scheme
+// becomes https, the host is lowercased and tracking parameters are removed.
+// It reports the platform of the link, or false when raw isn't a usable URL.
+func normalizeLink(raw string) (string, string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", "", false
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + strings.TrimPrefix(raw, "//")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", false
+	}
+	u.Scheme = "https"
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+
+	q := u.Query()
+	for key := range q {
+		for _, p := range trackingParams {
+			if key == p || (strings.HasSuffix(p, "*") && strings.HasPrefix(key, strings.TrimSuffix(p, "*"))) {
+				q.Del(key)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Hostname(), "www."), "m.")
+	platform, ok := linkPlatforms[host]
+	if !ok {
+		platform = "website"
+	}
+	return u.String(), platform, true
+}
+
+// linkRows turns a user's links into user_links rows, in the user's order and
+// without duplicates. Links that aren't usable URLs are kept as typed, with
+// platform "unknown", so nothing a user entered is lost.
+func (m *migrator) linkRows(userID string, links []string) []tableRow {
+	var rows []tableRow
+	seen := map[string]bool{}
+	for _, raw := range links {
+		link, platform, ok := normalizeLink(raw)
+		if !ok {
+			link, platform = strings.TrimSpace(raw), "unknown"
+		}
+		if link == "" || seen[link] {
+			continue
+		}
+		seen[link] = true
+		rows = append(rows, tableRow{
+			Table:   "user_links",
+			Columns: []string{"user_id", "position", "url", "platform"},
+			Values:  []interface{}{userID, len(rows), link, platform},
+		})
+	}
+	return rows
+}
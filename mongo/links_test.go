@@ -0,0 +1,51 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeLink(t *testing.T) {
+	tests := []struct {
+		raw      string
+		want     string
+		platform string
+		ok       bool
+	}{
+		{"https://github.com/netsocial", "https://github.com/netsocial", "github", true},
+		{"  github.com/netsocial ", "https://github.com/netsocial", "github", true},
+		{"//gitlab.com/netsocial", "https://gitlab.com/netsocial", "gitlab", true},
+		{"http://WWW.YouTube.com/@netsocial", "https://www.youtube.com/@netsocial", "youtube", true},
+		{"https://m.twitch.tv/netsocial", "https://m.twitch.tv/netsocial", "twitch", true},
+		{"https://x.com/netsocial?s=20&utm_source=share&utm_medium=web", "https://x.com/netsocial?s=20", "twitter", true},
+		{"https://instagram.com/netsocial?igshid=abc#top", "https://instagram.com/netsocial", "instagram", true},
+		{"https://example.com/about?fbclid=1&gclid=2&page=3", "https://example.com/about?page=3", "website", true},
+		{"", "", "", false},
+		{"   ", "", "", false},
+		{"ftp://example.com/file", "", "", false},
+		{"https://", "", "", false},
+	}
+	for _, tt := range tests {
+		got, platform, ok := normalizeLink(tt.raw)
+		if got != tt.want || platform != tt.platform || ok != tt.ok {
+			t.Errorf("normalizeLink(%q) = %q, %q, %v, want %q, %q, %v", tt.raw, got, platform, ok, tt.want, tt.platform, tt.ok)
+		}
+	}
+}
+
+func TestLinkRows(t *testing.T) {
+	m := &migrator{}
+	rows := m.linkRows("u1", []string{"github.com/a", "https://github.com/a?utm_source=x", "not a link", "", "https://example.com"})
+	var got [][]interface{}
+	for _, row := range rows {
+		got = append(got, row.Values)
+	}
+	want := [][]interface{}{
+		{"u1", 0, "https://github.com/a", "github"},
+		{"u1", 1, "not a link", "unknown"},
+		{"u1", 2, "https://example.com", "website"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("linkRows = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package mongo
+
+import (
+	"fmt"
+	"log"
+)
+
+// logLevel orders log messages by severity; --log-level hides the ones below
+// the chosen level. Fatal errors are always logged.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// minLogLevel is the least severe level that is logged.
+var minLogLevel = levelInfo
+
+func setLogLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("unknown --log-level %q, expected debug, info, warn or error", name)
+	}
+	minLogLevel = level
+	return nil
+}
+
+// logf logs a message at level.
+func logf(level logLevel, format string, args ...interface{}) {
+	if level >= minLogLevel {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,218 @@
+package mongo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// collectionMapping declares how a collection without a hand-written transfer
+// function is copied into MySQL: which document fields land in which columns,
+// converted how.
+type collectionMapping struct {
+	Collection  string         `json:"collection" doc:"source MongoDB collection"`
+	Table       string         `json:"table" default:"the collection name" doc:"target MySQL table"`
+	CreateTable bool           `json:"createTable" default:"false" doc:"create the table from the field types if it doesn't exist"`
+	Fields      []fieldMapping `json:"fields" doc:"columns of the target table"`
+}
+
+// fieldMapping copies one (possibly dotted, e.g. "author.name") source field into a column.
+type fieldMapping struct {
+	Source     string `json:"source" doc:"document field, dots reach into nested documents"`
+	Column     string `json:"column" default:"snake_case of source" doc:"target column"`
+	Type       string `json:"type" default:"text" doc:"conversion: text, objectid, timestamp, json, int, float or bool"`
+	PrimaryKey bool   `json:"primaryKey" default:"false" doc:"part of the table's primary key"`
+}
+
+// fieldConversions are the values a fieldMapping's type may take, with the
+// column type used when the engine creates the table itself.
+var fieldConversions = map[string]string{
+	"text":      "TEXT",
+	"objectid":  "VARCHAR(24)",
+	"timestamp": "DATETIME",
+	"json":      "JSON",
+	"int":       "BIGINT",
+	"float":     "DOUBLE",
+	"bool":      "BOOLEAN",
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validate checks a mapping before anything is read or written.
+func (cm *collectionMapping) validate() error {
+	if cm.Collection == "" {
+		return fmt.Errorf("mapping without a collection")
+	}
+	if cm.Table == "" {
+		cm.Table = cm.Collection
+	}
+	if !identifierPattern.MatchString(cm.Table) {
+		return fmt.Errorf("mapping %s: invalid table name %q", cm.Collection, cm.Table)
+	}
+	if len(cm.Fields) == 0 {
+		return fmt.Errorf("mapping %s: no fields", cm.Collection)
+	}
+	for i := range cm.Fields {
+		f := &cm.Fields[i]
+		if f.Source == "" {
+			return fmt.Errorf("mapping %s: field without a source", cm.Collection)
+		}
+		if f.Column == "" {
+			f.Column = snakeCase(strings.ReplaceAll(f.Source, ".", "_"))
+		}
+		if !identifierPattern.MatchString(f.Column) {
+			return fmt.Errorf("mapping %s: invalid column name %q", cm.Collection, f.Column)
+		}
+		if f.Type == "" {
+			f.Type = "text"
+		}
+		if _, ok := fieldConversions[f.Type]; !ok {
+			return fmt.Errorf("mapping %s: field %s has unknown type %q", cm.Collection, f.Source, f.Type)
+		}
+	}
+	return nil
+}
+
+// createTableStatement returns the CREATE TABLE IF NOT EXISTS statement for the mapping.
+func (cm *collectionMapping) createTableStatement() string {
+	var columns, keys []string
+	for _, f := range cm.Fields {
+		col := fmt.Sprintf("    `%s` %s", f.Column, fieldConversions[f.Type])
+		if f.PrimaryKey {
+			col += " NOT NULL"
+			keys = append(keys, "`"+f.Column+"`")
+		}
+		columns = append(columns, col)
+	}
+	if len(keys) > 0 {
+		columns = append(columns, "    PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (\n%s\n)", cm.Table, strings.Join(columns, ",\n"))
+}
+
+// migration wraps the mapping so it can be selected and run like the built-in collections.
+func (cm *collectionMapping) migration() collectionMigration {
+	fields := make([]string, len(cm.Fields))
+	for i, f := range cm.Fields {
+		fields[i] = f.Source
+	}
+	return collectionMigration{
+		Name: cm.Collection,
+		Rows: func(m *migrator, cursor documentCursor) ([]tableRow, error) {
+			return m.mappedRows(cm, cursor)
+		},
+		Fields: fields,
+	}
+}
+
+func (m *migrator) mappedRows(cm *collectionMapping, cursor documentCursor) ([]tableRow, error) {
+	var doc bson.M
+	if err := cursor.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", cm.Collection, err)
+	}
+	row := tableRow{Table: cm.Table}
+	for _, f := range cm.Fields {
+		value, err := convertField(lookupField(doc, f.Source), f.Type, m.dates)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Source, err)
+		}
+		row.Columns = append(row.Columns, f.Column)
+		row.Values = append(row.Values, value)
+	}
+	return []tableRow{row}, nil
+}
+
+// lookupField follows a dotted path through nested documents; missing fields are nil.
+func lookupField(doc map[string]interface{}, path string) interface{} {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch d := current.(type) {
+		case map[string]interface{}:
+			current = d[key]
+		case bson.M:
+			current = d[key]
+		case bson.D:
+			current = nil
+			for _, e := range d {
+				if e.Key == key {
+					current = e.Value
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+// convertField turns a decoded document value into the value stored for a
+// field of type kind, reading timestamps stored as text with dates.
+func convertField(v interface{}, kind string, dates *dateParser) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch kind {
+	case "objectid":
+		return idText(v)
+	case "timestamp":
+		switch t := v.(type) {
+		case primitive.DateTime:
+			return t.Time().UTC(), nil
+		case time.Time:
+			return t.UTC(), nil
+		case primitive.Timestamp:
+			return time.Unix(int64(t.T), 0).UTC(), nil
+		case string:
+			return dates.parse(t)
+		}
+		return nil, fmt.Errorf("expected a timestamp, got %T", v)
+	case "json":
+		return jsonValue{v}, nil
+	case "int":
+		switch n := v.(type) {
+		case int32:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		case float64:
+			return int64(n), nil
+		case string:
+			return strconv.ParseInt(n, 10, 64)
+		}
+		return nil, fmt.Errorf("expected an integer, got %T", v)
+	case "float":
+		switch n := v.(type) {
+		case int32:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		case string:
+			return strconv.ParseFloat(n, 64)
+		}
+		return nil, fmt.Errorf("expected a number, got %T", v)
+	case "bool":
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			return strconv.ParseBool(b)
+		}
+		return nil, fmt.Errorf("expected a boolean, got %T", v)
+	default:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case primitive.ObjectID:
+			return s.Hex(), nil
+		}
+		return fmt.Sprint(v), nil
+	}
+}
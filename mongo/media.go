@@ -0,0 +1,401 @@
+package mongo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mediaColumns are the columns holding links to images: avatars, banners,
+// post images and partner logos.
+var mediaColumns = map[string]bool{
+	"users.profile_picture": true,
+	"users.profile_banner":  true,
+	"posts.image_url":       true,
+	"posts.image":           true,
+	"partners.banner":       true,
+	"partners.logo":         true,
+	"blogs.author_avatar":   true,
+}
+
+// maxMediaSize is the largest file copied into the object store.
+const maxMediaSize = 32 << 20
+
+// mediaAuditor looks at every media URL about to be written: it moves URLs of
+// the legacy hosts in hosts to their new CDN base and lists the ones that
+// can't be valid, or with check set don't answer a HEAD request, in a broken
+// media file. Checks run on concurrency workers in the background so they
+// don't hold up the inserts, and each URL is requested once. With store set
+// the files are instead downloaded and copied into it by the workers, and the
+// rows get the object URLs once their copies are done. A nil *mediaAuditor
+// leaves media alone.
+type mediaAuditor struct {
+	hosts  map[string]string
+	check  bool
+	store  *objectStore
+	client *http.Client
+	// uploadClient downloads and uploads the copies, having a timeout of its
+	// own as files take longer than checks
+	uploadClient *http.Client
+	path         string
+	report       *os.File
+	enc          *json.Encoder
+
+	jobs    chan brokenMedia
+	uploads chan *upload
+	wg      sync.WaitGroup
+	done    sync.Once
+	// mu guards the report, the counters and uploaded, written to by the workers
+	mu        sync.Mutex
+	checked   map[string]bool
+	rewritten map[string]int
+	broken    map[string]int
+	// uploaded are the copies of every URL queued, done or not
+	uploaded map[string]*upload
+	copied   map[string]int
+	// offline gives media the object URLs copies into the store would get,
+	// without downloading anything, for diff
+	offline bool
+}
+
+// upload is the copy of one URL into the store, shared by every row using it.
+// done is closed once err is set.
+type upload struct {
+	src  string
+	done chan struct{}
+	err  error
+}
+
+// mediaCopy is a media column of a document's row waiting for its copy. The
+// row keeps its old URL when the copy fails.
+type mediaCopy struct {
+	row    tableRow
+	index  int
+	media  brokenMedia
+	upload *upload
+}
+
+// brokenMedia is one line of the broken media file.
+type brokenMedia struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Row    string `json:"row"`
+	URL    string `json:"url"`
+	Error  string `json:"error"`
+}
+
+func validateMediaHosts(hosts map[string]string) error {
+	for from, to := range hosts {
+		if from == "" || strings.ContainsAny(from, "/:") {
+			return fmt.Errorf("media host %q: expected a bare host name, e.g. old-cdn.example.com", from)
+		}
+		u, err := url.Parse(to)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("media host %s: %q is not an http(s) base URL", from, to)
+		}
+	}
+	return nil
+}
+
+func newMediaAuditor(hosts map[string]string, store *objectStore, check bool, concurrency int, timeout, uploadTimeout time.Duration, path string) (*mediaAuditor, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("--media-concurrency must be at least 1")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating broken media file: %v", err)
+	}
+	a := &mediaAuditor{
+		hosts:        map[string]string{},
+		check:        check,
+		store:        store,
+		client:       &http.Client{Timeout: timeout},
+		uploadClient: &http.Client{Timeout: uploadTimeout},
+		path:         path,
+		report:       f,
+		enc:          json.NewEncoder(f),
+		jobs:         make(chan brokenMedia, concurrency),
+		uploads:      make(chan *upload, concurrency),
+		checked:      map[string]bool{},
+		rewritten:    map[string]int{},
+		broken:       map[string]int{},
+		uploaded:     map[string]*upload{},
+		copied:       map[string]int{},
+	}
+	for from, to := range hosts {
+		a.hosts[strings.ToLower(from)] = strings.TrimSuffix(to, "/")
+	}
+	for i := 0; i < concurrency; i++ {
+		switch {
+		case store != nil:
+			a.wg.Add(1)
+			go a.uploader()
+		case check:
+			a.wg.Add(1)
+			go a.worker()
+		}
+	}
+	return a, nil
+}
+
+// audit rewrites the legacy media URLs of rows in place and queues a copy
+// of, or reports or queues a check for, every media URL, recording what it
+// found in l. Copied media get their object URLs right away; finish waits for
+// the copies.
+func (a *mediaAuditor) audit(rows []tableRow, l *ledger) error {
+	if a == nil {
+		return nil
+	}
+	for _, row := range rows {
+		for i, col := range row.Columns {
+			name := row.Table + "." + col
+			text, ok := row.Values[i].(string)
+			if !ok || text == "" || strings.HasPrefix(text, "data:") || !mediaColumns[name] {
+				continue
+			}
+			media := brokenMedia{Table: row.Table, Column: col, Row: rowKey(row), URL: text}
+			u, err := url.Parse(text)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				media.Error = "not an http(s) URL"
+				l.add(func() error { return a.record(media) })
+				continue
+			}
+			if base, ok := a.hosts[strings.ToLower(u.Host)]; ok {
+				text = base + u.RequestURI()
+				row.Values[i], media.URL = text, text
+				l.add(func() error { return a.count(a.rewritten, name) })
+			}
+			if a.store != nil {
+				row.Values[i] = a.store.objectURL(a.objectKey(text))
+				if !a.offline {
+					l.copies = append(l.copies, &mediaCopy{row: row, index: i, media: media, upload: a.upload(text)})
+				}
+				continue
+			}
+			if !a.check {
+				continue
+			}
+			l.add(func() error {
+				a.mu.Lock()
+				seen := a.checked[media.URL]
+				a.checked[media.URL] = true
+				a.mu.Unlock()
+				if !seen {
+					a.jobs <- media
+				}
+				return nil
+			})
+		}
+	}
+	return nil
+}
+
+// count adds one to the count of column in counts.
+func (a *mediaAuditor) count(counts map[string]int, column string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts[column]++
+	return nil
+}
+
+// upload queues the copy of src for the workers, once per URL, waiting while
+// all of them are busy.
+func (a *mediaAuditor) upload(src string) *upload {
+	a.mu.Lock()
+	u, ok := a.uploaded[src]
+	if !ok {
+		u = &upload{src: src, done: make(chan struct{})}
+		a.uploaded[src] = u
+	}
+	a.mu.Unlock()
+	if !ok {
+		a.uploads <- u
+	}
+	return u
+}
+
+// finish waits for the copies the rows of l's document use and records how
+// they went in l. Rows whose copy failed get their old URL back.
+func (a *mediaAuditor) finish(l *ledger) {
+	if a == nil || l == nil {
+		return
+	}
+	for _, c := range l.copies {
+		<-c.upload.done
+		media := c.media
+		if c.upload.err != nil {
+			c.row.Values[c.index] = media.URL
+			media.Error = c.upload.err.Error()
+			l.add(func() error { return a.record(media) })
+			continue
+		}
+		l.add(func() error { return a.count(a.copied, media.Table+"."+media.Column) })
+	}
+	l.copies = nil
+}
+
+func (a *mediaAuditor) uploader() {
+	defer a.wg.Done()
+	for u := range a.uploads {
+		u.err = a.copy(u.src)
+		close(u.done)
+	}
+}
+
+// copy downloads the file at src into the store. The key is derived from src,
+// so a resumed run overwrites the objects it already wrote instead of
+// duplicating them.
+func (a *mediaAuditor) copy(src string) error {
+	resp, err := a.uploadClient.Get(src)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaSize+1))
+	if err != nil {
+		return fmt.Errorf("error downloading: %v", err)
+	}
+	if len(body) > maxMediaSize {
+		return fmt.Errorf("larger than %d MiB", maxMediaSize>>20)
+	}
+	return a.store.put(context.Background(), a.uploadClient, a.objectKey(src), body, resp.Header.Get("Content-Type"))
+}
+
+// objectKey is the key the copy of src is stored under.
+func (a *mediaAuditor) objectKey(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return a.store.Prefix + hex.EncodeToString(sum[:16]) + mediaExtension(src)
+}
+
+// mediaExtensionPattern matches the file extensions kept on object keys.
+var mediaExtensionPattern = regexp.MustCompile(`^\.[a-z0-9]{1,5}$`)
+
+// mediaExtension returns the extension of the file src names, "" if it has
+// none worth keeping.
+func mediaExtension(src string) string {
+	u, err := url.Parse(src)
+	if err != nil {
+		return ""
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if !mediaExtensionPattern.MatchString(ext) {
+		return ""
+	}
+	return ext
+}
+
+func (a *mediaAuditor) worker() {
+	defer a.wg.Done()
+	for job := range a.jobs {
+		if err := a.fetch(job.URL); err != nil {
+			job.Error = err.Error()
+			if err := a.record(job); err != nil {
+				logf(levelError, "%v", err)
+			}
+		}
+	}
+}
+
+// fetch requests rawURL, falling back to GET for servers that don't take HEAD.
+func (a *mediaAuditor) fetch(rawURL string) error {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(context.Background(), method, rawURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	if status >= 400 {
+		return fmt.Errorf("status %d", status)
+	}
+	return nil
+}
+
+func (a *mediaAuditor) record(b brokenMedia) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.broken[b.Table+"."+b.Column]++
+	if err := a.enc.Encode(b); err != nil {
+		return fmt.Errorf("error writing %s: %v", a.path, err)
+	}
+	return nil
+}
+
+// wait lets the queued checks and copies finish.
+func (a *mediaAuditor) wait() {
+	a.done.Do(func() {
+		close(a.jobs)
+		close(a.uploads)
+		a.wg.Wait()
+	})
+}
+
+// summary waits for the checks and logs how many URLs were rewritten and
+// found broken per column.
+func (a *mediaAuditor) summary() {
+	if a == nil {
+		return
+	}
+	a.wait()
+	if len(a.rewritten) > 0 {
+		logf(levelInfo, "Media URLs moved to the new CDN: %s", columnCounts(a.rewritten))
+	}
+	if len(a.copied) > 0 {
+		files := 0
+		for _, u := range a.uploaded {
+			if u.err == nil {
+				files++
+			}
+		}
+		logf(levelInfo, "Media copied to %s (%d file(s)): %s", a.store.Bucket, files, columnCounts(a.copied))
+	}
+	if len(a.broken) > 0 {
+		logf(levelWarn, "Broken media URLs: %s, see %s", columnCounts(a.broken), a.path)
+	}
+}
+
+// columnCounts lists counts per column as "table.column (n)", sorted.
+func columnCounts(counts map[string]int) string {
+	columns := make([]string, 0, len(counts))
+	for col := range counts {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	parts := make([]string, len(columns))
+	for i, col := range columns {
+		parts[i] = fmt.Sprintf("%s (%d)", col, counts[col])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (a *mediaAuditor) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.wait()
+	return a.report.Close()
+}
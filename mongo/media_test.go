@@ -0,0 +1,120 @@
+package mongo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMediaAuditOffline(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	store := &objectStore{Endpoint: server.URL, Bucket: "media", Prefix: "m/", PublicURL: "https://cdn.example.com/"}
+	a, err := newMediaAuditor(map[string]string{"old-cdn.example.com": "https://new-cdn.example.com"}, store, false, 1, 0, 0, os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	a.offline = true
+
+	rows := []tableRow{{
+		Table:   "users",
+		Columns: []string{"id", "profile_picture", "profile_banner", "bio"},
+		Values:  []interface{}{"u1", "http://old-cdn.example.com/a/avatar.PNG?v=2", server.URL + "/banner", "http://old-cdn.example.com/bio"},
+	}}
+	if err := a.audit(rows, &ledger{}); err != nil {
+		t.Fatal(err)
+	}
+	avatar := regexp.MustCompile(`^https://cdn\.example\.com/m/[0-9a-f]{32}\.png$`)
+	banner := regexp.MustCompile(`^https://cdn\.example\.com/m/[0-9a-f]{32}$`)
+	if got := rows[0].Values[1].(string); !avatar.MatchString(got) {
+		t.Errorf("profile_picture = %q, want an object URL", got)
+	}
+	if got := rows[0].Values[2].(string); !banner.MatchString(got) {
+		t.Errorf("profile_banner = %q, want an object URL", got)
+	}
+	if got := rows[0].Values[3]; got != "http://old-cdn.example.com/bio" {
+		t.Errorf("bio = %q, want it left alone", got)
+	}
+	// The avatar's key is that of its URL on the new host, as a migration copies it from there
+	if want := store.objectURL(a.objectKey("https://new-cdn.example.com/a/avatar.PNG?v=2")); rows[0].Values[1] != want {
+		t.Errorf("profile_picture = %q, want %q", rows[0].Values[1], want)
+	}
+	if requests != 0 {
+		t.Errorf("made %d request(s) offline", requests)
+	}
+}
+
+func TestMediaUpload(t *testing.T) {
+	var mu sync.Mutex
+	puts := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			mu.Lock()
+			puts[r.URL.Path] = r.Header.Get("Content-Type")
+			mu.Unlock()
+		case r.URL.Path == "/avatar.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		case r.URL.Path == "/slow.png":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	store := &objectStore{Endpoint: server.URL, Bucket: "media", AccessKey: "key", SecretKey: "secret"}
+	a, err := newMediaAuditor(nil, store, false, 2, time.Millisecond, 50*time.Millisecond, os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	avatar, missing, slow := server.URL+"/avatar.png", server.URL+"/missing.png", server.URL+"/slow.png"
+	rows := []tableRow{
+		{Table: "users", Columns: []string{"id", "profile_picture", "profile_banner"}, Values: []interface{}{"u1", avatar, missing}},
+		{Table: "users", Columns: []string{"id", "profile_picture", "profile_banner"}, Values: []interface{}{"u2", avatar, slow}},
+	}
+	l := &ledger{}
+	if err := a.audit(rows, l); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.copies) != 4 {
+		t.Fatalf("%d copies waited for, want 4", len(l.copies))
+	}
+	a.finish(l)
+
+	// --media-timeout only applies to checks, so the avatar is copied while
+	// the slow banner runs out of --media-upload-timeout
+	want := [][]interface{}{
+		{"u1", store.objectURL(a.objectKey(avatar)), missing},
+		{"u2", store.objectURL(a.objectKey(avatar)), slow},
+	}
+	for i, row := range rows {
+		for j := range row.Values {
+			if row.Values[j] != want[i][j] {
+				t.Errorf("%s = %v, want %v", row.Columns[j], row.Values[j], want[i][j])
+			}
+		}
+	}
+	if err := (&migrator{}).settle(l); err != nil {
+		t.Fatal(err)
+	}
+	if a.copied["users.profile_picture"] != 2 || a.broken["users.profile_banner"] != 2 {
+		t.Errorf("copied %v and broken %v, want 2 avatars and 2 banners", a.copied, a.broken)
+	}
+	// Each URL is copied once however many rows use it
+	if len(puts) != 1 || puts["/media/"+a.objectKey(avatar)] != "image/png" {
+		t.Errorf("uploaded %v, want only the avatar", puts)
+	}
+}
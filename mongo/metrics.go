@@ -0,0 +1,150 @@
+package mongo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushMetrics sends the run's final counters to a Prometheus Pushgateway.
+// Migrations finish long before a scrape would catch them, so the gateway
+// holds the last run's numbers under job until the next push replaces them.
+func pushMetrics(gateway, job string, run *migrator, selected []collectionMigration, retry *retrier, started time.Time) error {
+	var b bytes.Buffer
+	writeRunMetrics(&b, run, selected, retry, started)
+	writeMetric(&b, "mongotomysql_finished_timestamp_seconds", "Unix time the run finished.", func(emit func(labels string, v float64)) {
+		emit("", float64(time.Now().Unix()))
+	})
+
+	target := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequest(http.MethodPut, target, &b)
+	if err != nil {
+		return fmt.Errorf("error pushing metrics: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("error pushing metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("error pushing metrics: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// serveMetrics serves the run's counters on addr for Prometheus to scrape
+// while the migration is still going. The server stops with the process.
+func serveMetrics(addr string, run *migrator, selected []collectionMigration, retry *retrier, started time.Time) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error serving metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		writeRunMetrics(&b, run, selected, retry, started)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(b.Bytes())
+	})
+	logf(levelInfo, "Serving metrics on http://%s/metrics", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logf(levelError, "error serving metrics: %v", err)
+		}
+	}()
+	return nil
+}
+
+// writeRunMetrics writes the run's counters so far.
+func writeRunMetrics(b *bytes.Buffer, run *migrator, selected []collectionMigration, retry *retrier, started time.Time) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	writeMetric(b, "mongotomysql_documents_read", "Documents read per collection, whatever became of them.", func(emit func(labels string, v float64)) {
+		for _, cm := range selected {
+			emit(collectionLabel(cm.Name), float64(run.position(cm.Name)))
+		}
+	})
+	writeMetric(b, "mongotomysql_documents_migrated", "Documents migrated per collection.", func(emit func(labels string, v float64)) {
+		for _, cm := range selected {
+			emit(collectionLabel(cm.Name), float64(run.migrated[cm.Name]))
+		}
+	})
+	writeMetric(b, "mongotomysql_documents_skipped", "Documents deliberately skipped per collection.", func(emit func(labels string, v float64)) {
+		for _, cm := range selected {
+			emit(collectionLabel(cm.Name), float64(run.skipped[cm.Name]))
+		}
+	})
+	writeMetric(b, "mongotomysql_documents_failed", "Documents sent to the dead-letter file per collection.", func(emit func(labels string, v float64)) {
+		for _, cm := range selected {
+			emit(collectionLabel(cm.Name), float64(run.failed.counts[cm.Name]))
+		}
+	})
+	writeMetric(b, "mongotomysql_collection_aborted", "1 for collections given up on after repeated connection failures.", func(emit func(labels string, v float64)) {
+		for _, cm := range selected {
+			v := 0.0
+			if run.aborted[cm.Name] != nil {
+				v = 1
+			}
+			emit(collectionLabel(cm.Name), v)
+		}
+	})
+	writeMetric(b, "mongotomysql_rows_written", "Rows written to MySQL per table.", func(emit func(labels string, v float64)) {
+		tables := make([]string, 0, len(run.rows))
+		for table := range run.rows {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			emit(fmt.Sprintf(`{table=%q}`, table), float64(run.rows[table]))
+		}
+	})
+	writeMetric(b, "mongotomysql_collection_duration_seconds", "Time spent on each collection so far.", func(emit func(labels string, v float64)) {
+		for _, cm := range selected {
+			began, ok := run.began[cm.Name]
+			if !ok {
+				continue
+			}
+			ended, ok := run.ended[cm.Name]
+			if !ok {
+				ended = time.Now()
+			}
+			emit(collectionLabel(cm.Name), ended.Sub(began).Seconds())
+		}
+	})
+	writeMetric(b, "mongotomysql_retries", "Retries needed per kind of operation.", func(emit func(labels string, v float64)) {
+		retry.mu.Lock()
+		defer retry.mu.Unlock()
+		ops := make([]string, 0, len(retry.retried))
+		for op := range retry.retried {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			emit(fmt.Sprintf(`{operation=%q}`, op), float64(retry.retried[op]))
+		}
+	})
+	writeMetric(b, "mongotomysql_duration_seconds", "Wall time of the run so far.", func(emit func(labels string, v float64)) {
+		emit("", time.Since(started).Seconds())
+	})
+}
+
+// writeMetric writes one gauge in the Prometheus text format.
+func writeMetric(b *bytes.Buffer, name, help string, samples func(emit func(labels string, v float64))) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	samples(func(labels string, v float64) {
+		fmt.Fprintf(b, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'f', -1, 64))
+	})
+}
+
+func collectionLabel(name string) string {
+	return fmt.Sprintf(`{collection=%q}`, name)
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS blog_entries;
+DROP TABLE IF EXISTS blogs;
+DROP TABLE IF EXISTS partners;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS posts;
@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS posts (
+    id VARCHAR(64) NOT NULL PRIMARY KEY,
+    title TEXT,
+    content TEXT,
+    author VARCHAR(64),
+    image_url TEXT,
+    image TEXT,
+    created_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(64) NOT NULL PRIMARY KEY,
+    username VARCHAR(255),
+    display_name VARCHAR(255),
+    user_id BIGINT,
+    email VARCHAR(255),
+    created_at DATETIME,
+    profile_picture TEXT,
+    profile_banner TEXT,
+    bio TEXT,
+    is_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    is_organisation BOOLEAN NOT NULL DEFAULT FALSE,
+    is_developer BOOLEAN NOT NULL DEFAULT FALSE,
+    is_partner BOOLEAN NOT NULL DEFAULT FALSE,
+    is_owner BOOLEAN NOT NULL DEFAULT FALSE,
+    password VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS partners (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    banner TEXT,
+    logo TEXT,
+    title VARCHAR(255),
+    text TEXT,
+    link TEXT
+);
+
+CREATE TABLE IF NOT EXISTS blogs (
+    slug VARCHAR(255) NOT NULL PRIMARY KEY,
+    title VARCHAR(255),
+    date VARCHAR(64),
+    author_name VARCHAR(255),
+    overview TEXT,
+    author_avatar TEXT
+);
+
+CREATE TABLE IF NOT EXISTS blog_entries (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    blog_slug VARCHAR(255) NOT NULL,
+    body MEDIUMTEXT,
+    INDEX blog_entries_blog_slug (blog_slug)
+);
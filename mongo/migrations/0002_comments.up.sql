@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id VARCHAR(64) NOT NULL PRIMARY KEY,
+    post_id VARCHAR(64) NOT NULL,
+    parent_id VARCHAR(64),
+    author VARCHAR(64),
+    content TEXT,
+    created_at DATETIME,
+    INDEX comments_post_id (post_id),
+    INDEX comments_author (author)
+);
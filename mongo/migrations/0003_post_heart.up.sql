@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS post_heart (
+    post_id VARCHAR(64) NOT NULL,
+    user_id VARCHAR(64) NOT NULL,
+    PRIMARY KEY (post_id, user_id),
+    INDEX post_heart_user_id (user_id)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS id_map (
+    collection VARCHAR(64) NOT NULL,
+    object_id VARCHAR(64) NOT NULL,
+    uuid CHAR(36) NOT NULL,
+    PRIMARY KEY (collection, object_id),
+    UNIQUE INDEX id_map_uuid (uuid)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_links (
+    user_id VARCHAR(64) NOT NULL,
+    position INT NOT NULL,
+    url TEXT NOT NULL,
+    platform VARCHAR(32) NOT NULL,
+    PRIMARY KEY (user_id, position)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_badge (
+    user_id VARCHAR(64) NOT NULL,
+    badge VARCHAR(64) NOT NULL,
+    awarded_at DATETIME,
+    PRIMARY KEY (user_id, badge),
+    INDEX user_badge_badge (badge)
+);
@@ -0,0 +1 @@
+ALTER TABLE blogs DROP COLUMN published_at;
@@ -0,0 +1 @@
+ALTER TABLE blogs ADD COLUMN published_at DATETIME AFTER date;
@@ -0,0 +1,3 @@
+ALTER TABLE blog_entries DROP INDEX blog_entries_blog_slug_position;
+ALTER TABLE blog_entries DROP COLUMN position;
+ALTER TABLE partners DROP INDEX partners_title;
@@ -0,0 +1,11 @@
+DELETE later FROM partners later JOIN partners earlier ON earlier.title = later.title AND earlier.id < later.id;
+
+ALTER TABLE partners ADD UNIQUE INDEX partners_title (title);
+
+ALTER TABLE blog_entries ADD COLUMN position INT NOT NULL DEFAULT 0 AFTER blog_slug;
+
+UPDATE blog_entries e JOIN (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY blog_slug ORDER BY id) - 1 AS position FROM blog_entries
+) numbered ON numbered.id = e.id SET e.position = numbered.position;
+
+ALTER TABLE blog_entries ADD UNIQUE INDEX blog_entries_blog_slug_position (blog_slug, position);
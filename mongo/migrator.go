@@ -0,0 +1,548 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tableRow is one row a document turns into. The same rows are inserted by
+// the migration and written out by export, so both always agree.
+type tableRow struct {
+	Table   string
+	Columns []string
+	Values  []interface{}
+}
+
+// sameShape reports whether r and o have the same table and columns, so they
+// can be written in one statement.
+func (r tableRow) sameShape(o tableRow) bool {
+	if r.Table != o.Table || len(r.Columns) != len(o.Columns) {
+		return false
+	}
+	for i, col := range r.Columns {
+		if o.Columns[i] != col {
+			return false
+		}
+	}
+	return true
+}
+
+// migrator carries the state shared by the transfer functions during one run.
+type migrator struct {
+	mysqlDB  *sql.DB
+	target   targetDB
+	retry    *retrier
+	failed   *deadLetter
+	migrated map[string]int
+	skipped  map[string]int
+	unknown  map[string]map[string]int
+	aliases  userAliases
+	strict   bool
+	// idTypes counts the _id types each collection's documents had
+	idTypes map[string]map[string]int
+	// comments writes post comments to the comments table
+	comments bool
+	// hearts writes post hearts to the post_heart table
+	hearts bool
+	// links writes user links to the user_links table
+	links bool
+	// passwords applies --password-policy to user passwords when set
+	passwords *passwordPolicy
+	// dates reads dates stored as text
+	dates *dateParser
+	// transforms rewrite column values as the config's transforms say
+	transforms []*fieldTransform
+	// media rewrites and checks media URLs
+	media *mediaAuditor
+	// valid checks column values against the validation rules
+	valid *validator
+	// ips scrubs IP addresses out of text columns when set
+	ips *ipScrubber
+	// ids replaces document IDs with UUIDs when set
+	ids *idMap
+	// upsert overwrites rows whose key already exists instead of failing on them
+	upsert bool
+	// dryRun transforms documents without inserting the rows
+	dryRun bool
+	// sample limits each collection to the documents a trial migration keeps
+	sample *documentSample
+	// checkpoints is the directory each collection's position is recorded
+	// in, "" for none
+	checkpoints string
+	// aborted holds the error each collection that couldn't be finished stopped with
+	aborted map[string]error
+	// interrupted counts the connection failures each collection was
+	// resumed or given up after
+	interrupted map[string]int
+	// offset is where each collection was picked up in a resumed run
+	offset map[string]int
+	// redo upserts the rows of a document that a connection failure
+	// interrupted, as some of them may already be in; every built-in table
+	// has a key the rows carry, so none of them is written twice
+	redo bool
+	// notify gets an event for every checkpoint written
+	notify *notifications
+	// txPer is what one MySQL transaction covers: every row on its own
+	// ("row"), the documents between two checkpoints ("batch") or a whole
+	// collection ("collection")
+	txPer string
+	tx    *sql.Tx
+	// committed is where each collection stood at its last commit
+	committed map[string]txMark
+	// unsettled are the ledger entries of the documents written since it
+	unsettled []func() error
+	// reads and writes throttle documents read and rows written
+	reads, writes *rateLimiter
+	// maxInFlight is how many documents each stage of a collection's
+	// pipeline holds at most
+	maxInFlight int
+
+	// mu guards the counters while --metrics-addr serves them from another
+	// goroutine; the migration itself writes them under it and reads them freely
+	mu sync.Mutex
+	// rows counts the rows written per table
+	rows map[string]int
+	// began and ended time each collection, ended staying unset while it runs
+	began, ended map[string]time.Time
+	// peakHeap is the most heap memory seen in use while collections ran
+	peakHeap uint64
+}
+
+// jsonValue stores its value in a MySQL JSON column.
+type jsonValue struct {
+	v interface{}
+}
+
+func (j jsonValue) Value() (driver.Value, error) {
+	data, err := json.Marshal(plainJSON(j.v))
+	return string(data), err
+}
+
+// plainJSON converts decoded BSON documents and arrays into maps and slices,
+// so they encode as JSON objects rather than lists of key/value pairs.
+func plainJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = plainJSON(e.Value)
+		}
+		return m
+	case primitive.M:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = plainJSON(e)
+		}
+		return m
+	case primitive.A:
+		return plainJSON([]interface{}(v))
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = plainJSON(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// readCollection opens cm on source and migrates its documents.
+func (m *migrator) readCollection(ctx context.Context, source documentSource, cm collectionMigration, opts readOptions) error {
+	var cursor documentCursor
+	err := m.retry.do("find", func() (err error) {
+		cursor, err = source.Open(ctx, cm.Name, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+	if err := m.migrateCollection(ctx, cm, cursor); err != nil {
+		return fmt.Errorf("error migrating %s: %w", cm.Name, err)
+	}
+	return nil
+}
+
+// migrateCollection transfers every document behind cursor, sending the ones
+// that fail to the dead-letter file instead of aborting the run. A connection
+// failure stops it and is returned with the document it happened on left
+// unprocessed, so the collection can be resumed at m.position. Cancelling
+// ctx stops it the same way, but only between two documents, so none is left
+// half-written.
+//
+// Documents stream through three stages: one goroutine reads them, another
+// turns them into rows and this one writes those. Each hands over through a
+// channel of m.maxInFlight documents, so no more than about twice that many
+// are held at once however big the collection is.
+func (m *migrator) migrateCollection(ctx context.Context, cm collectionMigration, cursor documentCursor) error {
+	m.mark(cm.Name)
+	defer m.sampleMemory()()
+	inFlight := m.maxInFlight
+	if inFlight < 1 {
+		inFlight = 1
+	}
+	stages, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// running tracks the reader and the preparer, which drain waits for so
+	// neither is left using the cursor or the migrator
+	var running sync.WaitGroup
+	running.Add(2)
+	docs := make(chan documentCursor, inFlight)
+	go func() {
+		defer running.Done()
+		defer close(docs)
+		defer reportPanic(cm.Name, nil)
+		for stages.Err() == nil && m.reads.wait(stages) == nil && cursor.Next(stages) {
+			select {
+			case docs <- cursor.Detach():
+			case <-stages.Done():
+				return
+			}
+		}
+	}()
+	ready := make(chan preparedDocument, inFlight)
+	// taken counts the documents that will be migrated or quarantined, so
+	// none past --limit is prepared
+	taken := m.migrated[cm.Name] + m.failed.counts[cm.Name]
+	go func() {
+		defer running.Done()
+		defer close(ready)
+		for doc := range docs {
+			if stages.Err() != nil || m.sample.full(taken) {
+				return
+			}
+			rows, l, err := m.prepare(cm, doc)
+			if !errors.Is(err, errSkipped) {
+				taken++
+			}
+			select {
+			case ready <- preparedDocument{doc: doc, rows: rows, ledger: l, err: err}:
+			case <-stages.Done():
+				return
+			}
+		}
+	}()
+	// drain stops the other stages and waits for them to let go of the
+	// migrator; the documents they read ahead are read again on resume
+	drain := func() {
+		stop()
+		for range ready {
+		}
+		running.Wait()
+	}
+
+	// full is set once the collection reached --limit, which ends it early
+	full := false
+	for p := range ready {
+		if ctx.Err() != nil {
+			break
+		}
+		if m.sample.full(m.migrated[cm.Name] + m.failed.counts[cm.Name]) {
+			full = true
+			break
+		}
+		err := p.err
+		if err == nil {
+			m.media.finish(p.ledger)
+			err = m.ids.store(p.ledger.assigned)
+		}
+		if err == nil {
+			err = m.insertDocument(ctx, p.rows)
+		}
+		if err != nil && ctx.Err() != nil && !isTransient(err) && m.transactional() && m.txPer == "batch" {
+			// Stopped mid-document: its rows went back to the savepoint, so
+			// stopping below keeps the batch's whole documents only
+			break
+		}
+		switch {
+		case errors.Is(err, errSkipped):
+			m.mu.Lock()
+			m.skipped[cm.Name]++
+			m.mu.Unlock()
+		case err != nil && (isTransient(err) || ctx.Err() != nil):
+			drain()
+			m.redo = true
+			m.rollback(cm.Name)
+			m.checkpoint(cm.Name, false)
+			return err
+		case err != nil:
+			kind := classifyFailure(err, p.err == nil)
+			logf(levelDebug, "%s document failed to transfer (%s): %v", cm.Name, kind, err)
+			m.mu.Lock()
+			err = m.failed.record(cm.Name, p.doc.Raw(), err, kind)
+			m.mu.Unlock()
+			if err != nil {
+				drain()
+				m.rollback(cm.Name)
+				return fmt.Errorf("error writing %s: %v", m.failed.path(cm.Name), err)
+			}
+		default:
+			m.mu.Lock()
+			m.migrated[cm.Name]++
+			m.mu.Unlock()
+		}
+		if err := m.settle(p.ledger); err != nil {
+			drain()
+			m.rollback(cm.Name)
+			return err
+		}
+		m.redo = false
+		// Checkpoints only ever record committed documents
+		if m.position(cm.Name)%checkpointEvery == 0 && m.txPer != "collection" {
+			err := m.commit(cm.Name)
+			m.checkpoint(cm.Name, false)
+			if err != nil {
+				drain()
+				return err
+			}
+		}
+	}
+	drain()
+	full = full || m.sample.full(m.migrated[cm.Name]+m.failed.counts[cm.Name])
+	err := ctx.Err()
+	if err == nil && !full {
+		err = cursor.Err()
+	}
+	switch {
+	case err == nil:
+		err = m.commit(cm.Name)
+	case ctx.Err() != nil && m.txPer == "batch":
+		// Stopping keeps the current batch like reaching a checkpoint would,
+		// without the document it stopped in
+		if cerr := m.commit(cm.Name); cerr != nil {
+			err = cerr
+		}
+	default:
+		m.rollback(cm.Name)
+	}
+	m.checkpoint(cm.Name, err == nil)
+	return err
+}
+
+// preparedDocument is a document with the rows it turned into, or the error
+// that kept it from turning into any, and the ledger of either.
+type preparedDocument struct {
+	doc    documentCursor
+	rows   []tableRow
+	ledger *ledger
+	err    error
+}
+
+// memorySampleEvery is how often the heap is measured while a collection runs.
+const memorySampleEvery = 100 * time.Millisecond
+
+// sampleMemory keeps m.peakHeap up to date until the returned func is called.
+func (m *migrator) sampleMemory() func() {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(memorySampleEvery)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			m.mu.Lock()
+			if stats.HeapInuse > m.peakHeap {
+				m.peakHeap = stats.HeapInuse
+			}
+			m.mu.Unlock()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// position returns the number of documents of a collection processed so far,
+// counting from the start of the collection in resumed runs.
+func (m *migrator) position(collection string) int {
+	return m.offset[collection] + m.migrated[collection] + m.skipped[collection] + m.failed.counts[collection]
+}
+
+// checkpoint records the collection's position. Checkpoints only help the
+// operator pick up after a crash, so failing to write one isn't fatal.
+func (m *migrator) checkpoint(collection string, done bool) {
+	if m.checkpoints == "" {
+		return
+	}
+	cp := checkpoint{Collection: collection, Position: m.position(collection), Done: done, UpdatedAt: time.Now().UTC()}
+	if err := writeCheckpoint(m.checkpoints, cp); err != nil {
+		logf(levelWarn, "%v", err)
+	}
+	m.notify.send(runEvent{Kind: eventCheckpoint, Run: m, Checkpoint: cp})
+}
+
+// timeCollection records that a collection started or, with done, ended.
+func (m *migrator) timeCollection(collection string, done bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.began == nil {
+		m.began, m.ended = map[string]time.Time{}, map[string]time.Time{}
+	}
+	if done {
+		m.ended[collection] = time.Now()
+	} else {
+		m.began[collection] = time.Now()
+	}
+}
+
+// reconnect makes the migrator write through a fresh MySQL connection pool.
+func (m *migrator) reconnect(mysqlDB *sql.DB) {
+	m.mysqlDB = mysqlDB
+	m.target = &mysqlTarget{db: mysqlDB}
+	if m.ids != nil {
+		m.ids.mu.Lock()
+		m.ids.mysqlDB = mysqlDB
+		m.ids.mu.Unlock()
+	}
+}
+
+// prepare turns the current document into the rows to insert, with the
+// ledger of what it found along the way. The error of a document that didn't
+// decode is a *decodeError.
+func (m *migrator) prepare(cm collectionMigration, doc documentCursor) ([]tableRow, *ledger, error) {
+	defer reportPanic(cm.Name, doc)
+	cursor := &decodeTracker{documentCursor: doc}
+	l := &ledger{}
+	rows, err := m.prepareRows(cm, cursor, l)
+	if err != nil && cursor.failed && !errors.Is(err, errSkipped) {
+		err = &decodeError{err}
+	}
+	return rows, l, err
+}
+
+// prepareRows turns a document into rows, see prepare.
+func (m *migrator) prepareRows(cm collectionMigration, cursor documentCursor, l *ledger) ([]tableRow, error) {
+	if keep, err := m.sample.keeps(cursor); err != nil || !keep {
+		if err == nil {
+			err = errSkipped
+		}
+		return nil, err
+	}
+	if err := m.checkFields(cm, cursor, l); err != nil {
+		return nil, err
+	}
+	rows, err := cm.Rows(m, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.passwords.rewrite(rows, l); err != nil {
+		return nil, err
+	}
+	if err := applyTransforms(m.transforms, rows); err != nil {
+		return nil, err
+	}
+	if err := m.media.audit(rows, l); err != nil {
+		return nil, err
+	}
+	if err := m.valid.check(cm.Name, rows, l); err != nil {
+		return nil, err
+	}
+	if err := m.ips.scrub(rows, l); err != nil {
+		return nil, err
+	}
+	return m.ids.rewrite(rows, l)
+}
+
+// summary logs how each migrated collection fared.
+func (m *migrator) summary(selected []collectionMigration) {
+	for _, cm := range selected {
+		line := fmt.Sprintf("%s: %d migrated", cm.Name, m.migrated[cm.Name])
+		if skipped := m.skipped[cm.Name]; skipped > 0 {
+			line += fmt.Sprintf(", %d skipped", skipped)
+		}
+		if failed := m.failed.counts[cm.Name]; failed > 0 {
+			line += fmt.Sprintf(", %d failed: %s (see %s)", failed, failureText(m.failed.kindCounts(cm.Name)), m.failed.path(cm.Name))
+		}
+		if n := m.interrupted[cm.Name]; n > 0 {
+			line += fmt.Sprintf(", %d connection failure(s)", n)
+		}
+		if err := m.aborted[cm.Name]; err != nil {
+			line += ", aborted before the end"
+		}
+		logf(levelInfo, "%s", line)
+		m.idTypeSummary(cm)
+		m.unknownSummary(cm)
+	}
+	m.failureTable(selected)
+	if m.peakHeap > 0 {
+		logf(levelInfo, "Peak heap in use: %.1f MiB (--max-in-flight %d)", float64(m.peakHeap)/(1<<20), m.maxInFlight)
+	}
+}
+
+// copyBatchRows is the most rows written in one CopyBatch, keeping the
+// statement far below MySQL's 65535 placeholders.
+const copyBatchRows = 500
+
+// insertRows writes the rows of one document in order. Consecutive rows of
+// one table and column list, such as the hearts of a post, are inserted
+// together; upserts go one row at a time. Waiting for --write-rate stops when
+// ctx is cancelled.
+func (m *migrator) insertRows(ctx context.Context, rows []tableRow) error {
+	if m.dryRun {
+		return nil
+	}
+	upsert := m.upsert || m.redo
+	for len(rows) > 0 {
+		n := 1
+		for !upsert && n < len(rows) && n < copyBatchRows && rows[n].sameShape(rows[0]) {
+			n++
+		}
+		batch := rows[:n]
+		rows = rows[n:]
+		for range batch {
+			if err := m.writes.wait(ctx); err != nil {
+				return err
+			}
+		}
+		err := m.write(func(ex sqlExecutor) error {
+			switch {
+			case upsert:
+				return m.target.Upsert(ex, batch[0])
+			case len(batch) == 1:
+				return m.target.Insert(ex, batch[0])
+			default:
+				return m.target.CopyBatch(ex, batch)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("error inserting into %s: %w", batch[0].Table, err)
+		}
+		m.mu.Lock()
+		if m.rows == nil {
+			m.rows = map[string]int{}
+		}
+		m.rows[batch[0].Table] += len(batch)
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// write runs f against the target, retrying transient failures outside
+// transactions. A broken connection takes the transaction with it, so inside
+// one only migrateCollection can start over.
+func (m *migrator) write(f func(ex sqlExecutor) error) error {
+	if m.tx != nil {
+		return f(m.tx)
+	}
+	return m.retry.do("insert", func() error {
+		return f(m.mysqlDB)
+	})
+}
@@ -0,0 +1,101 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mixedID is a document _id that may have been stored as an ObjectID, a
+// string or an integer: older SocialFlux writes used all three. It decodes
+// from BSON, extended JSON and the API's JSON alike into the text the
+// migration stores, an ObjectID as its hex form.
+type mixedID string
+
+func (id *mixedID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null || t == bsontype.Undefined {
+		*id = ""
+		return nil
+	}
+	var v interface{}
+	if err := (bson.RawValue{Type: t, Value: data}).Unmarshal(&v); err != nil {
+		return err
+	}
+	s, err := idText(v)
+	*id = mixedID(s)
+	return err
+}
+
+func (id *mixedID) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	// Relaxed extended JSON spells an ObjectID {"$oid": "..."}
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+		if oid, ok := m["$oid"].(string); ok {
+			v = oid
+		}
+	}
+	if v == nil {
+		*id = ""
+		return nil
+	}
+	s, err := idText(v)
+	*id = mixedID(s)
+	return err
+}
+
+// idText renders an _id of any of the types SocialFlux used as text.
+func idText(v interface{}) (string, error) {
+	switch id := v.(type) {
+	case primitive.ObjectID:
+		return id.Hex(), nil
+	case string:
+		return id, nil
+	case int32:
+		return strconv.FormatInt(int64(id), 10), nil
+	case int64:
+		return strconv.FormatInt(id, 10), nil
+	case json.Number:
+		if _, err := id.Int64(); err != nil {
+			return "", fmt.Errorf("non-integer _id %s", id)
+		}
+		return id.String(), nil
+	case float64:
+		// The shell stores plain numbers as doubles
+		if id != math.Trunc(id) || math.Abs(id) > 1<<53 {
+			return "", fmt.Errorf("non-integer _id %v", id)
+		}
+		return strconv.FormatInt(int64(id), 10), nil
+	}
+	return "", fmt.Errorf("unsupported _id type %T", v)
+}
+
+// idTypeName names the type an _id decoded into a bson.M has, for the audit
+// of which documents used which.
+func idTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "missing"
+	case primitive.ObjectID:
+		return "objectId"
+	case string:
+		return "string"
+	case int32:
+		return "int"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,118 @@
+package mongo
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type mixedIDDocument struct {
+	ID mixedID `bson:"_id" json:"_id"`
+}
+
+func TestMixedIDFromBSON(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("64b7f0c2e4b0a1b2c3d4e5f6")
+	tests := []struct {
+		name    string
+		id      interface{}
+		want    string
+		wantErr string
+	}{
+		{name: "ObjectID", id: oid, want: "64b7f0c2e4b0a1b2c3d4e5f6"},
+		{name: "string", id: "user-1", want: "user-1"},
+		{name: "int", id: int32(42), want: "42"},
+		{name: "long", id: int64(9007199254740993), want: "9007199254740993"},
+		{name: "whole double", id: float64(7), want: "7"},
+		{name: "null", id: nil, want: ""},
+		{name: "fractional double", id: 1.5, wantErr: "non-integer _id"},
+		{name: "document", id: bson.D{{Key: "a", Value: 1}}, wantErr: "unsupported _id type"},
+	}
+	for _, tt := range tests {
+		data, err := bson.Marshal(bson.D{{Key: "_id", Value: tt.id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc mixedIDDocument
+		err = bson.Unmarshal(data, &doc)
+		checkMixedID(t, tt.name+" in BSON", string(doc.ID), err, tt.want, tt.wantErr)
+	}
+}
+
+func TestMixedIDFromJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    string
+		wantErr string
+	}{
+		{name: "extended JSON ObjectID", json: `{"_id": {"$oid": "64b7f0c2e4b0a1b2c3d4e5f6"}}`, want: "64b7f0c2e4b0a1b2c3d4e5f6"},
+		{name: "string", json: `{"_id": "user-1"}`, want: "user-1"},
+		{name: "number", json: `{"_id": 42}`, want: "42"},
+		// Numbers beyond a float64's precision stay exact
+		{name: "large number", json: `{"_id": 9007199254740993}`, want: "9007199254740993"},
+		{name: "null", json: `{"_id": null}`, want: ""},
+		{name: "fraction", json: `{"_id": 1.5}`, wantErr: "non-integer _id"},
+		{name: "object", json: `{"_id": {"a": 1}}`, wantErr: "unsupported _id type"},
+	}
+	for _, tt := range tests {
+		var doc mixedIDDocument
+		err := json.Unmarshal([]byte(tt.json), &doc)
+		checkMixedID(t, tt.name+" in JSON", string(doc.ID), err, tt.want, tt.wantErr)
+	}
+}
+
+// Dump directories hold relaxed extended JSON, decoded by the BSON library.
+func TestMixedIDFromExtendedJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"_id": {"$oid": "64b7f0c2e4b0a1b2c3d4e5f6"}}`, "64b7f0c2e4b0a1b2c3d4e5f6"},
+		{`{"_id": "user-1"}`, "user-1"},
+		{`{"_id": 42}`, "42"},
+		{`{"_id": {"$numberLong": "9007199254740993"}}`, "9007199254740993"},
+	}
+	for _, tt := range tests {
+		var doc mixedIDDocument
+		err := unmarshalExtJSON([]byte(tt.json), &doc)
+		checkMixedID(t, tt.json, string(doc.ID), err, tt.want, "")
+	}
+}
+
+func checkMixedID(t *testing.T, name, got string, err error, want, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: error = %v, want one containing %q", name, err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+	} else if got != want {
+		t.Errorf("%s: _id = %q, want %q", name, got, want)
+	}
+}
+
+func TestIDTypeName(t *testing.T) {
+	tests := []struct {
+		id   interface{}
+		want string
+	}{
+		{nil, "missing"},
+		{primitive.NewObjectID(), "objectId"},
+		{"a", "string"},
+		{int32(1), "int"},
+		{int64(1), "long"},
+		{1.0, "double"},
+		{true, "bool"},
+	}
+	for _, tt := range tests {
+		if got := idTypeName(tt.id); got != tt.want {
+			t.Errorf("idTypeName(%#v) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -1,21 +1,20 @@
-package main
+package mongo
 
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/urfave/cli/v2"
 )
 
 type Post struct {
-	ID        string    `bson:"_id" json:"_id"`
+	ID        mixedID   `bson:"_id" json:"_id"`
 	Title     string    `bson:"title" json:"title"`
 	Content   string    `bson:"content" json:"content"`
 	Author    string    `bson:"author" json:"author"`
@@ -27,7 +26,7 @@ type Post struct {
 }
 
 type Comment struct {
-	ID             string    `bson:"_id,omitempty" json:"_id,omitempty"`
+	ID             mixedID   `bson:"_id,omitempty" json:"_id,omitempty"`
 	Content        string    `bson:"content" json:"content"`
 	Author         string    `bson:"author" json:"author"`
 	IsVerified     bool      `json:"isVerified"`
@@ -37,10 +36,11 @@ type Comment struct {
 	IsOwner        bool      `json:"isOwner"`
 	IsDeveloper    bool      `json:"isDeveloper"`
 	Replies        []Comment `bson:"replies" json:"replies"`
+	CreatedAt      time.Time `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
 }
 
 type User struct {
-	ID             string    `bson:"_id" json:"_id"`
+	ID             mixedID   `bson:"_id" json:"_id"`
 	Username       string    `bson:"username" json:"username"`
 	DisplayName    string    `bson:"displayname" json:"displayname"`
 	UserID         int       `bson:"userid" json:"userid"`
@@ -80,138 +80,812 @@ type PostEntry struct {
 	Body string `json:"body"`
 }
 
-func main() {
-	// Load environment variables
-	err := godotenv.Load()
+// collectionMigration ties a MongoDB collection to the function that turns
+// one of its documents into MySQL rows. Fields lists the document paths the
+// function migrates; anything else in a document is reported as dropped.
+type collectionMigration struct {
+	Name   string
+	Rows   func(*migrator, documentCursor) ([]tableRow, error)
+	Fields []string
+}
+
+// collectionMigrations lists every collection the tool knows how to migrate, in migration order.
+var collectionMigrations = []collectionMigration{
+	{Name: "posts", Rows: (*migrator).postRows, Fields: bsonFields(Post{}, "hearts", "comments")},
+	{Name: "users", Rows: (*migrator).userRows, Fields: bsonFields(User{}, "links")},
+	{Name: "partners", Rows: (*migrator).partnerRows, Fields: bsonFields(Partner{})},
+	{Name: "blogs", Rows: (*migrator).blogRows, Fields: bsonFields(BlogPost{})},
+}
+
+// Commands returns the commands of the MongoDB to MySQL migration, for
+// registering with the cli-tools app.
+func Commands() []*cli.Command {
+	return []*cli.Command{
+		migrateCommand,
+		importCommand,
+		exportCommand,
+		schemaCommand,
+		assertCommand,
+		checkIntegrityCommand,
+		diffCommand,
+		backfillBadgesCommand,
+		inferSchemaCommand,
+		configCommand,
+		runsCommand,
+		historyCommand,
+		quarantineCommand,
+		publishCommand,
+		doctorCommand,
+		preflightCommand,
+		smokeCommand,
+		benchCommand,
+		seedCommand,
+		selftestCommand,
+		daemonCommand,
+		selfUpdateCommand,
+		completionCommand,
+	}
+}
+
+var migrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "Migrate the SocialFlux MongoDB collections to MySQL",
+	Flags: withFlags(mongoFlags, mysqlFlags, sourceFlags, retryFlags, normalizeFlags, dateFlags, rewriteFlags, transferFlags, mediaFlags, filterFlags, runsFlags, []cli.Flag{
+		&cli.StringFlag{
+			Name:  "collections",
+			Usage: "comma separated list of collections to migrate (default: all)",
+		},
+		&cli.StringFlag{
+			Name:  "skip-collections",
+			Usage: "comma separated list of collections to leave out",
+		},
+		&cli.StringFlag{
+			Name:  "dedupe-emails",
+			Usage: "merge duplicate users, keeping the one preferred by these comma separated rules (verified, oldest, newest)",
+		},
+		&cli.StringFlag{
+			Name:  "dedupe-by",
+			Value: "email",
+			Usage: "comma separated user fields --dedupe-emails matches duplicates on: email, username",
+		},
+		&cli.StringFlag{
+			Name:  "ordered",
+			Usage: "comma separated collection[:field] list read in field order (default createdAt) so rows are inserted in that order",
+		},
+		&cli.StringFlag{
+			Name:  "indexes",
+			Value: "after",
+			Usage: "when secondary indexes are built: before or after the data load, or skip",
+		},
+		&cli.BoolFlag{
+			Name:  "foreign-keys",
+			Usage: "add foreign key constraints between the migrated tables once the data is loaded",
+		},
+		&cli.StringFlag{
+			Name:    "push-gateway",
+			Usage:   "Prometheus Pushgateway URL the run's final metrics are pushed to",
+			EnvVars: []string{"PUSHGATEWAY_URL"},
+		},
+		&cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "address, like :9090, to serve live Prometheus metrics on at /metrics while the run goes on",
+		},
+		&cli.StringFlag{
+			Name:    "notify-discord",
+			Usage:   "Discord webhook URL a summary is posted to when the run finishes or fails, on top of the config's notifications",
+			EnvVars: []string{"DISCORD_WEBHOOK_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "sentry-dsn",
+			Usage:   "Sentry DSN panics, errors and failed documents are reported to, in place of the config's",
+			EnvVars: []string{"SENTRY_DSN"},
+		},
+		&cli.StringFlag{
+			Name:  "push-job",
+			Value: "mongotomysql",
+			Usage: "job name the metrics are pushed under",
+		},
+		&cli.BoolFlag{
+			Name:  "trial",
+			Usage: "rehearse the whole run in a throwaway trial_<timestamp> database and compare it with the real target",
+		},
+		&cli.BoolFlag{
+			Name:  "trial-keep",
+			Usage: "keep the trial database instead of dropping it at the end",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "migrate at most this many documents of each collection, to rehearse a run quickly",
+		},
+		&cli.StringFlag{
+			Name:  "sample",
+			Usage: "migrate only a share of each collection, like 1%, picked by a hash of the _id so every run picks the same documents",
+		},
+		&cli.IntFlag{
+			Name:  "collection-retries",
+			Value: 3,
+			Usage: "times a collection is resumed on fresh connections after a connection failure before it is marked failed",
+		},
+		&cli.BoolFlag{
+			Name:  "upsert",
+			Usage: "overwrite rows that already exist instead of quarantining their documents, for reruns into a filled target",
+		},
+		&cli.BoolFlag{
+			Name:  "reset-target",
+			Usage: "drop and recreate the target tables before migrating, for a clean-slate rerun",
+		},
+		&cli.BoolFlag{
+			Name:  "reset-id-map",
+			Usage: "with --reset-target, drop id_map too, so every document gets a new UUID",
+		},
+		&cli.StringFlag{
+			Name:  "resume",
+			Usage: "ID of a stopped or failed run to continue from its checkpoints, upserting",
+		},
+		&cli.BoolFlag{
+			Name:  "accept-new-target",
+			Usage: "migrate even though the target was stamped by runs from another environment, and restamp it",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "read and transform every document, quarantining the failures, without connecting to MySQL",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "start without showing the run plan and asking for confirmation on a terminal",
+		},
+	}),
+	Action:       migrate,
+	BashComplete: completeCollections(true, "collections", "skip-collections", "ordered"),
+}
+
+func migrate(c *cli.Context) error {
+	defer reportPanic("", nil)
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	selected, err := selectCollections(cfg.migrations(), c.String("collections"), c.String("skip-collections"))
+	if err != nil {
+		return err
+	}
+	ordered, err := parseOrdered(selected, c.String("ordered"))
+	if err != nil {
+		return err
+	}
+	filters, err := collectionFilters(cfg, selected, c.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+	projections := collectionProjections(cfg, selected)
+	dates, err := newDateParser(cfg.dateLayouts(), c.String("default-timezone"))
+	if err != nil {
+		return err
+	}
+	indexTiming := c.String("indexes")
+	if indexTiming != "before" && indexTiming != "after" && indexTiming != "skip" {
+		return fmt.Errorf("unknown --indexes %q, expected before, after or skip", indexTiming)
+	}
+	if err := validTxPer(c.String("tx-per")); err != nil {
+		return err
+	}
+	if c.Int("max-in-flight") < 1 {
+		return fmt.Errorf("--max-in-flight must be at least 1")
+	}
+	reads, err := newRateLimiter("read-rate", c.Float64("read-rate"))
+	if err != nil {
+		return err
+	}
+	writes, err := newRateLimiter("write-rate", c.Float64("write-rate"))
+	if err != nil {
+		return err
+	}
+	dedupeRules, err := parseUserRules(c.String("dedupe-emails"))
+	if err != nil {
+		return err
+	}
+	dedupeKeys, err := parseUserKeys(c.String("dedupe-by"))
+	if err != nil {
+		return err
+	}
+	dryRun := c.Bool("dry-run")
+	if dryRun && (c.Bool("trial") || c.Bool("uuid-ids")) {
+		return fmt.Errorf("--dry-run doesn't touch MySQL, so it can't be combined with --trial or --uuid-ids")
+	}
+	if c.Bool("reset-target") && (dryRun || c.Bool("trial")) {
+		return fmt.Errorf("--reset-target resets the real target, so it can't be combined with --dry-run or --trial")
+	}
+	if c.Bool("reset-id-map") && !c.Bool("reset-target") {
+		return fmt.Errorf("--reset-id-map only applies to --reset-target")
+	}
+	sample, err := parseSample(c.Int("limit"), c.String("sample"))
+	if err != nil {
+		return err
+	}
+	resumed := map[string]checkpoint{}
+	if id := c.String("resume"); id != "" {
+		if c.Bool("trial") {
+			return fmt.Errorf("--resume continues in the real target, so it can't be combined with --trial")
+		}
+		if sample != nil {
+			return fmt.Errorf("--resume continues a whole run, so it can't be combined with --limit or --sample")
+		}
+		if c.Bool("reset-target") {
+			return fmt.Errorf("--resume continues from the rows already written, so it can't be combined with --reset-target")
+		}
+		if resumed, err = readCheckpoints(filepath.Join(c.String("runs-dir"), id, "checkpoints")); err != nil {
+			return err
+		}
+	}
+	if c.Bool("normalize-comments") {
+		selected = coverFields(selected, "posts", "comments")
+	}
+	if c.Bool("normalize-hearts") {
+		selected = coverFields(selected, "posts", "hearts")
+	}
+	if c.Bool("normalize-links") {
+		selected = coverFields(selected, "users", "links")
+	}
+
+	started := time.Now()
+	notify := newNotifications(cfg.Notifications, c.String("notify-discord"), selected, started)
+	sentry, err := runSentry(c, cfg)
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		return err
+	}
+	if sentry != nil {
+		crashReporter = sentry
+		notify.subscribe("sentry", sentry, eventFinish, eventError)
+	}
+	// run stays nil until the collections are about to be migrated
+	var run *migrator
+	// abort is returned rather than exiting, so the deferred cleanups such
+	// as unlocking the run directory still run
+	abort := func(err error) error {
+		notify.send(runEvent{Kind: eventError, Run: run, Err: err, Aborted: true})
+		return err
 	}
+	retry := newRetrier(c.Context, c.Int("max-retries"), c.Duration("retry-delay"))
+	defer retry.summary()
 
-	mongodbURI := os.Getenv("MONGODB_URI")
-	mysqlURI := os.Getenv("MYSQL_URI")
+	source, err := openSource(c, retry)
+	if err != nil {
+		return abort(err)
+	}
+	// source and mysqlDB are replaced when a collection is resumed on fresh connections
+	defer func() { source.Close(context.TODO()) }()
 
-	// Connect to MongoDB
-	mongoClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongodbURI))
+	plan, err := newRunPlan(c, source, selected, filters, sample)
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
+		return abort(err)
+	}
+	if err := confirmRun(c, plan); err != nil {
+		return err
+	}
+
+	uri := c.String("mysql-uri")
+	var trial *trialTarget
+	if c.Bool("trial") {
+		if trial, err = newTrialTarget(uri, retry); err != nil {
+			return abort(err)
+		}
+		defer func() {
+			if err := trial.Close(c.Bool("trial-keep")); err != nil {
+				logf(levelError, "%v", err)
+			}
+		}()
+		uri = trial.uri
 	}
-	defer mongoClient.Disconnect(context.TODO())
 
 	// Connect to MySQL
-	mysqlDB, err := sql.Open("mysql", mysqlURI)
+	var mysqlDB *sql.DB
+	if !dryRun {
+		if mysqlDB, err = openMySQL(uri, retry); err != nil {
+			return abort(err)
+		}
+		defer func() { mysqlDB.Close() }()
+	}
+
+	dir, err := newRunDir(c.String("runs-dir"))
 	if err != nil {
-		log.Fatalf("Error connecting to MySQL: %v", err)
+		return abort(err)
+	}
+	defer dir.Close()
+	history := newRunHistory(c, dir, selected, started)
+	if sentry != nil {
+		sentry.runID = dir.ID
 	}
-	defer mysqlDB.Close()
+	notify.subscribe("run history", history, eventStart, eventFinish, eventError)
 
-	if err = mysqlDB.Ping(); err != nil {
-		log.Fatalf("MySQL ping failed: %v", err)
+	changes, err := newSchemaChangelog(filepath.Join(dir.reports(), "schema_changes.log"))
+	if err != nil {
+		return abort(err)
 	}
+	defer changes.Close()
+	defer changes.summary()
 
-	// Collections in MongoDB
-	postsCollection := mongoClient.Database("SocialFlux").Collection("posts")
-	usersCollection := mongoClient.Database("SocialFlux").Collection("users")
-	partnersCollection := mongoClient.Database("SocialFlux").Collection("partners")
-	blogsCollection := mongoClient.Database("SocialFlux").Collection("blogs")
+	var passwords *passwordPolicy
+	if policy := c.String("password-policy"); policy != "" {
+		if passwords, err = newPasswordPolicy(policy, filepath.Join(dir.reports(), "passwords.ndjson")); err != nil {
+			return abort(err)
+		}
+		defer passwords.Close()
+	}
 
-	// Fetch and migrate posts
-	migratePosts(postsCollection, mysqlDB)
-	// Fetch and migrate users
-	migrateUsers(usersCollection, mysqlDB)
-	// Fetch and migrate partners
-	migratePartners(partnersCollection, mysqlDB)
-	// Fetch and migrate blogs
-	migrateBlogs(blogsCollection, mysqlDB)
-}
+	valid, err := newValidator(cfg.validations(), c.Bool("fail-on-invalid"), filepath.Join(dir.reports(), "invalid_values.ndjson"))
+	if err != nil {
+		return abort(err)
+	}
+	defer valid.Close()
 
-func migratePosts(postsCollection *mongo.Collection, mysqlDB *sql.DB) {
-	cursor, err := postsCollection.Find(context.TODO(), bson.M{})
+	var store *objectStore
+	if c.Bool("upload-media") {
+		if store = cfg.MediaStore; store == nil {
+			return abort(fmt.Errorf("--upload-media needs a mediaStore in the config file"))
+		}
+	}
+	media, err := newMediaAuditor(cfg.MediaHosts, store, c.Bool("check-media"), c.Int("media-concurrency"), c.Duration("media-timeout"), c.Duration("media-upload-timeout"), filepath.Join(dir.reports(), "broken_media.ndjson"))
 	if err != nil {
-		log.Fatalf("Error finding posts: %v", err)
+		return abort(err)
 	}
-	defer cursor.Close(context.TODO())
+	defer media.Close()
 
-	for cursor.Next(context.TODO()) {
-		var post Post
-		if err := cursor.Decode(&post); err != nil {
-			log.Fatalf("Error decoding post: %v", err)
+	var ips *ipScrubber
+	if policy := c.String("scrub-ips"); policy != "" {
+		if ips, err = newIPScrubber(policy, filepath.Join(dir.reports(), "ip_findings.ndjson")); err != nil {
+			return abort(err)
 		}
-		// Insert into MySQL
-		query := "INSERT INTO posts (id, title, content, author, image_url, image, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
-		_, err := mysqlDB.Exec(query, post.ID, post.Title, post.Content, post.Author, post.ImageURL, post.Image, post.CreatedAt)
+		defer ips.Close()
+	}
+
+	// A trial run writes into a throwaway database, so there's nothing to protect
+	if !dryRun && trial == nil {
+		env, err := runEnvironment(c, uri)
 		if err != nil {
-			log.Fatalf("Error inserting post into MySQL: %v", err)
+			return abort(err)
+		}
+		if err := checkEnvironment(mysqlDB, env, c.Bool("accept-new-target")); err != nil {
+			return abort(err)
+		}
+		if err := history.useTable(mysqlDB); err != nil {
+			return abort(err)
+		}
+	}
+
+	target := &mysqlTarget{db: mysqlDB}
+	if c.Bool("reset-target") {
+		logf(levelWarn, "Resetting the target: dropping its tables")
+		if err := resetTarget(mysqlDB, cfg, selected, c.Bool("reset-id-map"), changes); err != nil {
+			return abort(err)
+		}
+	}
+	if !dryRun {
+		if err := prepareTarget(target, mysqlDB, cfg, selected, indexTiming, changes); err != nil {
+			return abort(err)
+		}
+	}
+
+	failed := newDeadLetter(dir.quarantine())
+	defer failed.Close()
+
+	run = &migrator{
+		mysqlDB:   mysqlDB,
+		target:    target,
+		retry:     retry,
+		failed:    failed,
+		migrated:  map[string]int{},
+		skipped:   map[string]int{},
+		unknown:   map[string]map[string]int{},
+		aborted:   map[string]error{},
+		strict:    c.Bool("strict"),
+		comments:  c.Bool("normalize-comments"),
+		hearts:    c.Bool("normalize-hearts"),
+		links:     c.Bool("normalize-links"),
+		ips:       ips,
+		valid:     valid,
+		media:     media,
+		passwords: passwords,
+		dryRun:    dryRun,
+		sample:    sample,
+		txPer:     c.String("tx-per"),
+		reads:     reads,
+		writes:    writes,
+		// Documents after the last checkpoint of the resumed run may already be in
+		upsert: c.Bool("upsert") || len(resumed) > 0,
+		offset: map[string]int{},
+
+		checkpoints: dir.checkpoints(),
+		maxInFlight: c.Int("max-in-flight"),
+		notify:      notify,
+		dates:       dates,
+		transforms:  cfg.Transforms,
+	}
+	if sample != nil {
+		// A partial run's positions would have a resumed run skip documents it never read
+		run.checkpoints = ""
+		logf(levelInfo, "Migrating a sample: %s", sample)
+	}
+	if c.Bool("uuid-ids") {
+		if run.ids, err = loadIDMap(mysqlDB, retry); err != nil {
+			return abort(err)
+		}
+	}
+	if c.IsSet("dedupe-emails") {
+		if run.aliases, err = resolveDuplicateUsers(c.Context, source, dedupeRules, dedupeKeys, filepath.Join(dir.reports(), "merged_users.ndjson")); err != nil {
+			return abort(err)
+		}
+	}
+
+	if addr := c.String("metrics-addr"); addr != "" {
+		if err := serveMetrics(addr, run, selected, retry, started); err != nil {
+			return abort(err)
 		}
 	}
+
+	for name, cp := range resumed {
+		run.offset[name] = cp.Position
+	}
+	notify.send(runEvent{Kind: eventStart, Run: run})
+
+	// Fetch and migrate the selected collections until they're done or the
+	// command is stopped
+	stopped := ""
+	for _, cm := range selected {
+		if resumed[cm.Name].Done {
+			logf(levelInfo, "Skipping %s, run %s migrated it", cm.Name, c.String("resume"))
+			run.checkpoint(cm.Name, true)
+			continue
+		}
+		if run.position(cm.Name) > 0 {
+			logf(levelInfo, "Migrating %s from document %d", cm.Name, run.position(cm.Name))
+		} else {
+			logf(levelInfo, "Migrating %s", cm.Name)
+		}
+		run.timeCollection(cm.Name, false)
+		for retries := 0; ; retries++ {
+			err := run.readCollection(c.Context, source, cm, readOptions{Sort: ordered[cm.Name], Skip: run.position(cm.Name), Filter: filters[cm.Name], Projection: projections[cm.Name]})
+			if err == nil {
+				break
+			}
+			if c.Context.Err() != nil {
+				stopped = cm.Name
+				break
+			}
+			if !isTransient(err) {
+				return abort(err)
+			}
+			run.mu.Lock()
+			if run.interrupted == nil {
+				run.interrupted = map[string]int{}
+			}
+			run.interrupted[cm.Name]++
+			run.mu.Unlock()
+			if retries == c.Int("collection-retries") {
+				logf(levelError, "Giving up on %s after %d document(s): %v", cm.Name, run.position(cm.Name), err)
+				run.mu.Lock()
+				run.aborted[cm.Name] = err
+				run.mu.Unlock()
+				break
+			}
+			// The connection itself may be what's broken, so don't reuse it
+			logf(levelWarn, "%v; resuming %s after document %d on fresh connections", err, cm.Name, run.position(cm.Name))
+			source.Close(context.TODO())
+			if source, err = openSource(c, retry); err != nil {
+				return abort(err)
+			}
+			if !dryRun {
+				mysqlDB.Close()
+				if mysqlDB, err = openMySQL(uri, retry); err != nil {
+					return abort(err)
+				}
+				run.reconnect(mysqlDB)
+				if history.db != nil {
+					history.db = mysqlDB
+				}
+			}
+		}
+		run.timeCollection(cm.Name, true)
+		if stopped != "" {
+			break
+		}
+	}
+
+	run.summary(selected)
+	media.summary()
+	valid.summary()
+	ips.summary()
+	passwords.summary()
+	if gateway := c.String("push-gateway"); gateway != "" {
+		if err := pushMetrics(gateway, c.String("push-job"), run, selected, retry, started); err != nil {
+			logf(levelError, "%v", err)
+		}
+	}
+	if stopped != "" {
+		logResumeState(run, selected, stopped)
+		err := cli.Exit(fmt.Sprintf("Stopped, continue with migrate --resume %s", dir.ID), 130)
+		notify.send(runEvent{Kind: eventError, Run: run, Err: err, Aborted: true})
+		return err
+	}
+	// Building indexes once the rows are in is much faster than maintaining them on every insert
+	if indexTiming == "after" && !dryRun {
+		if err := createIndexes(mysqlDB, cfg.indexes(), changes); err != nil {
+			return abort(err)
+		}
+	}
+	if c.Bool("foreign-keys") && !dryRun {
+		if err := addForeignKeys(mysqlDB, changes); err != nil {
+			return abort(err)
+		}
+	}
+	if trial != nil {
+		if err := trial.compare(); err != nil {
+			logf(levelError, "%v", err)
+		}
+	}
+	if err = finishRun(c, run, mysqlDB, cfg); err != nil {
+		notify.send(runEvent{Kind: eventError, Run: run, Err: err})
+	} else {
+		notify.send(runEvent{Kind: eventFinish, Run: run})
+	}
+	return err
 }
 
-func migrateUsers(usersCollection *mongo.Collection, mysqlDB *sql.DB) {
-	cursor, err := usersCollection.Find(context.TODO(), bson.M{})
-	if err != nil {
-		log.Fatalf("Error finding users: %v", err)
+// finishRun decides how a run that got through every collection exits.
+func finishRun(c *cli.Context, run *migrator, mysqlDB *sql.DB, cfg *config) error {
+	if len(run.aborted) > 0 {
+		return cli.Exit(fmt.Sprintf("%d collection(s) failed to migrate", len(run.aborted)), 1)
+	}
+	if n := run.failed.total(); n > 0 && c.Bool("strict") {
+		return cli.Exit(fmt.Sprintf("%d document(s) failed to transfer", n), 1)
 	}
-	defer cursor.Close(context.TODO())
+	if n := run.valid.refusedCount(); n > 0 {
+		return cli.Exit(fmt.Sprintf("%d document(s) had invalid values", n), 1)
+	}
+	if run.dryRun {
+		logf(levelInfo, "Dry run, nothing was written to MySQL")
+		return nil
+	}
+	return checkAssertions(mysqlDB, cfg.Assertions)
+}
 
-	for cursor.Next(context.TODO()) {
-		var user User
-		if err := cursor.Decode(&user); err != nil {
-			log.Fatalf("Error decoding user: %v", err)
+// prepareTarget brings the target schema up to date and creates the tables of
+// mapped collections that ask for it, before anything is written into them.
+func prepareTarget(target targetDB, mysqlDB *sql.DB, cfg *config, selected []collectionMigration, indexTiming string, changes *schemaChangelog) error {
+	if err := target.CreateSchema(changes); err != nil {
+		return err
+	}
+	for _, mapping := range cfg.Mappings {
+		if !mapping.CreateTable || !knownCollection(selected, mapping.Collection) {
+			continue
+		}
+		exists, err := target.Exists(mapping.Table)
+		if err != nil {
+			return err
+		}
+		if exists {
+			changes.kept("table %s for mapped collection %s already existed", mapping.Table, mapping.Collection)
+			continue
+		}
+		if _, err := mysqlDB.Exec(mapping.createTableStatement()); err != nil {
+			return fmt.Errorf("error creating table %s: %v", mapping.Table, err)
+		}
+		changes.changed("created table %s for mapped collection %s", mapping.Table, mapping.Collection)
+	}
+	if indexTiming == "before" {
+		return createIndexes(mysqlDB, cfg.indexes(), changes)
+	}
+	return nil
+}
+
+// openSource connects to the document source chosen with --source.
+func openSource(c *cli.Context, retry *retrier) (documentSource, error) {
+	switch c.String("source") {
+	case "mongo":
+		if c.Int("batch-size") < 0 {
+			return nil, fmt.Errorf("--batch-size can't be negative")
+		}
+		readPref, err := parseReadPreference(c.String("read-preference"))
+		if err != nil {
+			return nil, err
 		}
-		// Insert into MySQL
-		query := "INSERT INTO users (id, username, display_name, user_id, email, created_at, profile_picture, profile_banner, bio, is_verified, is_organisation, is_developer, is_partner, is_owner, password) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
-		_, err := mysqlDB.Exec(query, user.ID, user.Username, user.DisplayName, user.UserID, user.Email, user.CreatedAt, user.ProfilePicture, user.ProfileBanner, user.Bio, user.IsVerified, user.IsOrganisation, user.IsDeveloper, user.IsPartner, user.IsOwner, user.Password)
+		source, err := newMongoSource(c.Context, c.String("mongodb-uri"), retry)
 		if err != nil {
-			log.Fatalf("Error inserting user into MySQL: %v", err)
+			return nil, err
 		}
+		source.batchSize = int32(c.Int("batch-size"))
+		source.readPref = readPref
+		source.noCursorTimeout = c.Bool("no-cursor-timeout")
+		return source, nil
+	case "api":
+		return newAPISource(c.String("api-url"), c.String("api-token"), c.Int("api-page-size"), c.Float64("api-rate"), retry)
+	case "dir":
+		return newDirSource(c.String("source-dir"))
+	case "archive":
+		return newArchiveSource(c.String("source-archive"))
+	default:
+		return nil, fmt.Errorf("unknown --source %q, expected mongo, api, dir or archive", c.String("source"))
 	}
 }
 
-func migratePartners(partnersCollection *mongo.Collection, mysqlDB *sql.DB) {
-	cursor, err := partnersCollection.Find(context.TODO(), bson.M{})
+// connectMySQL opens and pings the MySQL database behind uri, retrying the
+// ping while the server is unreachable.
+func connectMySQL(uri string, retry *retrier) *sql.DB {
+	mysqlDB, err := openMySQL(uri, retry)
 	if err != nil {
-		log.Fatalf("Error finding partners: %v", err)
+		log.Fatal(err)
+	}
+	return mysqlDB
+}
+
+// openMySQL is connectMySQL returning the error instead of exiting on it.
+func openMySQL(uri string, retry *retrier) (*sql.DB, error) {
+	mysqlDB, err := sqlOpen(uri)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to MySQL: %v", err)
+	}
+	if err = retry.do("connect", mysqlDB.Ping); err != nil {
+		mysqlDB.Close()
+		return nil, fmt.Errorf("MySQL ping failed: %v", err)
+	}
+	return mysqlDB, nil
+}
+
+// selectCollections applies the --collections and --skip-collections flags to
+// the available migrations. Both take comma separated collection names; unknown
+// names are rejected so a typo can't silently migrate nothing.
+func selectCollections(available []collectionMigration, only, skip string) ([]collectionMigration, error) {
+	onlySet, err := parseCollectionList(available, only)
+	if err != nil {
+		return nil, err
+	}
+	skipSet, err := parseCollectionList(available, skip)
+	if err != nil {
+		return nil, err
 	}
-	defer cursor.Close(context.TODO())
 
-	for cursor.Next(context.TODO()) {
-		var partner Partner
-		if err := cursor.Decode(&partner); err != nil {
-			log.Fatalf("Error decoding partner: %v", err)
+	var selected []collectionMigration
+	for _, m := range available {
+		if len(onlySet) > 0 && !onlySet[m.Name] {
+			continue
 		}
-		// Insert into MySQL
-		query := "INSERT INTO partners (banner, logo, title, text, link) VALUES (?, ?, ?, ?, ?)"
-		_, err := mysqlDB.Exec(query, partner.Banner, partner.Logo, partner.Title, partner.Text, partner.Link)
-		if err != nil {
-			log.Fatalf("Error inserting partner into MySQL: %v", err)
+		if skipSet[m.Name] {
+			continue
 		}
+		selected = append(selected, m)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no collections left to migrate")
 	}
+	return selected, nil
 }
 
-func migrateBlogs(blogsCollection *mongo.Collection, mysqlDB *sql.DB) {
-	cursor, err := blogsCollection.Find(context.TODO(), bson.M{})
-	if err != nil {
-		log.Fatalf("Error finding blogs: %v", err)
+// parseOrdered parses --ordered into the sort fields per collection. Ties are
+// broken by _id so the order is total.
+func parseOrdered(selected []collectionMigration, list string) (map[string][]string, error) {
+	ordered := map[string][]string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, field := item, "createdAt"
+		if i := strings.Index(item, ":"); i >= 0 {
+			name, field = item[:i], item[i+1:]
+		}
+		if !knownCollection(selected, name) {
+			return nil, fmt.Errorf("--ordered: collection %q is not being migrated", name)
+		}
+		ordered[name] = []string{field}
+		if field != "_id" {
+			ordered[name] = append(ordered[name], "_id")
+		}
 	}
-	defer cursor.Close(context.TODO())
+	return ordered, nil
+}
 
-	for cursor.Next(context.TODO()) {
-		var blog BlogPost
-		if err := cursor.Decode(&blog); err != nil {
-			log.Fatalf("Error decoding blog: %v", err)
+func parseCollectionList(available []collectionMigration, list string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
-		// Insert into MySQL
-		query := "INSERT INTO blogs (slug, title, date, author_name, overview, author_avatar) VALUES (?, ?, ?, ?, ?, ?)"
-		_, err := mysqlDB.Exec(query, blog.Slug, blog.Title, blog.Date, blog.AuthorName, blog.Overview, blog.Authoravatar)
-		if err != nil {
-			log.Fatalf("Error inserting blog into MySQL: %v", err)
+		if !knownCollection(available, name) {
+			return nil, fmt.Errorf("unknown collection %q", name)
 		}
+		set[name] = true
+	}
+	return set, nil
+}
 
-		for _, entry := range blog.Content {
-			entryQuery := "INSERT INTO blog_entries (blog_slug, body) VALUES (?, ?)"
-			_, err := mysqlDB.Exec(entryQuery, blog.Slug, entry.Body)
-			if err != nil {
-				log.Fatalf("Error inserting blog entry into MySQL: %v", err)
-			}
+func knownCollection(available []collectionMigration, name string) bool {
+	for _, m := range available {
+		if m.Name == name {
+			return true
 		}
 	}
+	return false
+}
+
+func (m *migrator) postRows(cursor documentCursor) ([]tableRow, error) {
+	var post Post
+	if err := cursor.Decode(&post); err != nil {
+		return nil, fmt.Errorf("error decoding post: %v", err)
+	}
+	id := string(post.ID)
+	post.Author = m.aliases.resolve(post.Author)
+	rows := []tableRow{{
+		Table:   "posts",
+		Columns: []string{"id", "title", "content", "author", "image_url", "image", "created_at"},
+		Values:  []interface{}{id, post.Title, post.Content, post.Author, post.ImageURL, post.Image, post.CreatedAt},
+	}}
+	if m.comments {
+		rows = append(rows, m.commentRows(id, nil, id, post.Comments)...)
+	}
+	if m.hearts {
+		rows = append(rows, m.heartRows(id, post.Hearts)...)
+	}
+	return rows, nil
+}
+
+func (m *migrator) userRows(cursor documentCursor) ([]tableRow, error) {
+	var user User
+	if err := cursor.Decode(&user); err != nil {
+		return nil, fmt.Errorf("error decoding user: %v", err)
+	}
+	id := string(user.ID)
+	if _, merged := m.aliases[id]; merged {
+		return nil, errSkipped
+	}
+	rows := []tableRow{{
+		Table:   "users",
+		Columns: []string{"id", "username", "display_name", "user_id", "email", "created_at", "profile_picture", "profile_banner", "bio", "is_verified", "is_organisation", "is_developer", "is_partner", "is_owner", "password"},
+		Values:  []interface{}{id, user.Username, user.DisplayName, user.UserID, user.Email, user.CreatedAt, user.ProfilePicture, user.ProfileBanner, user.Bio, user.IsVerified, user.IsOrganisation, user.IsDeveloper, user.IsPartner, user.IsOwner, user.Password},
+	}}
+	if m.links {
+		rows = append(rows, m.linkRows(id, user.Links)...)
+	}
+	return rows, nil
+}
+
+func (m *migrator) partnerRows(cursor documentCursor) ([]tableRow, error) {
+	var partner Partner
+	if err := cursor.Decode(&partner); err != nil {
+		return nil, fmt.Errorf("error decoding partner: %v", err)
+	}
+	return []tableRow{{
+		Table:   "partners",
+		Columns: []string{"banner", "logo", "title", "text", "link"},
+		Values:  []interface{}{partner.Banner, partner.Logo, partner.Title, partner.Text, partner.Link},
+	}}, nil
+}
+
+func (m *migrator) blogRows(cursor documentCursor) ([]tableRow, error) {
+	var blog BlogPost
+	if err := cursor.Decode(&blog); err != nil {
+		return nil, fmt.Errorf("error decoding blog: %v", err)
+	}
+	// date keeps the text as written, published_at the time it means
+	var published interface{}
+	if strings.TrimSpace(blog.Date) != "" {
+		t, err := m.dates.parse(blog.Date)
+		if err != nil {
+			return nil, fmt.Errorf("blog %s: %v", blog.Slug, err)
+		}
+		published = t
+	}
+	rows := []tableRow{{
+		Table:   "blogs",
+		Columns: []string{"slug", "title", "date", "published_at", "author_name", "overview", "author_avatar"},
+		Values:  []interface{}{blog.Slug, blog.Title, blog.Date, published, blog.AuthorName, blog.Overview, blog.Authoravatar},
+	}}
+	// position keys the entries, so rerunning a blog overwrites them in place
+	for i, entry := range blog.Content {
+		rows = append(rows, tableRow{
+			Table:   "blog_entries",
+			Columns: []string{"blog_slug", "position", "body"},
+			Values:  []interface{}{blog.Slug, i, entry.Body},
+		})
+	}
+	return rows, nil
 }
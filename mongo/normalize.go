@@ -0,0 +1,55 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// commentRows flattens a post's comments and their replies into rows of the
+// comments table. Replies point at their parent through parent_id. Comments
+// without an _id get one derived from their position, so re-running a
+// migration produces the same keys. Derived IDs are a hash of the parent's ID
+// and the position, so they fit comments.id however deep the replies nest.
+func (m *migrator) commentRows(postID string, parentID interface{}, prefix string, comments []Comment) []tableRow {
+	var rows []tableRow
+	for i, comment := range comments {
+		id := string(comment.ID)
+		if id == "" {
+			sum := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", prefix, i)))
+			id = hex.EncodeToString(sum[:16])
+		}
+		var createdAt interface{}
+		if !comment.CreatedAt.IsZero() {
+			createdAt = comment.CreatedAt
+		}
+		rows = append(rows, tableRow{
+			Table:   "comments",
+			Columns: []string{"id", "post_id", "parent_id", "author", "content", "created_at"},
+			Values:  []interface{}{id, postID, parentID, m.aliases.resolve(comment.Author), comment.Content, createdAt},
+		})
+		rows = append(rows, m.commentRows(postID, id, id, comment.Replies)...)
+	}
+	return rows
+}
+
+// heartRows turns the user IDs that hearted a post into post_heart rows. Hearts
+// of merged users count for the surviving user, and every user hearts a post
+// at most once.
+func (m *migrator) heartRows(postID string, hearts []string) []tableRow {
+	var rows []tableRow
+	seen := map[string]bool{}
+	for _, userID := range hearts {
+		userID = m.aliases.resolve(userID)
+		if userID == "" || seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		rows = append(rows, tableRow{
+			Table:   "post_heart",
+			Columns: []string{"post_id", "user_id"},
+			Values:  []interface{}{postID, userID},
+		})
+	}
+	return rows
+}
@@ -0,0 +1,43 @@
+package mongo
+
+import "testing"
+
+func TestCommentRows(t *testing.T) {
+	// Replies nested far deeper than comments.id could hold a path of
+	comment := Comment{Content: "deepest"}
+	for i := 0; i < 20; i++ {
+		comment = Comment{Content: "reply", Replies: []Comment{comment}}
+	}
+	comments := []Comment{{ID: "65f1c0ffee0000000000abcd", Content: "kept"}, comment}
+
+	m := &migrator{}
+	rows := m.commentRows("65f1c0ffee00000000001234", nil, "65f1c0ffee00000000001234", comments)
+	if len(rows) != 22 {
+		t.Fatalf("%d row(s), want 22", len(rows))
+	}
+	if id := rows[0].Values[0]; id != "65f1c0ffee0000000000abcd" {
+		t.Errorf("comment with an _id got %v", id)
+	}
+	seen := map[interface{}]bool{}
+	for i, row := range rows {
+		id := row.Values[0].(string)
+		if len(id) > 64 {
+			t.Errorf("row %d: id %q doesn't fit comments.id", i, id)
+		}
+		if seen[id] {
+			t.Errorf("row %d: id %q repeats", i, id)
+		}
+		seen[id] = true
+		if i > 1 && row.Values[2] != rows[i-1].Values[0] {
+			t.Errorf("row %d: parent %v, want %v", i, row.Values[2], rows[i-1].Values[0])
+		}
+	}
+
+	// Derived IDs stay the same from one run to the next
+	again := m.commentRows("65f1c0ffee00000000001234", nil, "65f1c0ffee00000000001234", comments)
+	for i := range rows {
+		if rows[i].Values[0] != again[i].Values[0] {
+			t.Errorf("row %d: id %v, then %v", i, rows[i].Values[0], again[i].Values[0])
+		}
+	}
+}
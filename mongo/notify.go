@@ -0,0 +1,315 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Events a run notifies about.
+const (
+	eventStart      = "start"
+	eventFinish     = "finish"
+	eventError      = "error"
+	eventCheckpoint = "checkpoint"
+)
+
+// defaultEvents are sent to a notifier that doesn't list its own; starts and
+// checkpoints are opt-in, as they'd drown out the outcomes.
+var defaultEvents = []string{eventFinish, eventError}
+
+// notifierConfig is one entry of the config file's notifications list.
+type notifierConfig struct {
+	Type   string   `json:"type" doc:"discord, slack or webhook"`
+	URL    string   `json:"url" doc:"webhook URL the notifications are posted to"`
+	Events []string `json:"events" default:"finish, error" doc:"events to notify about: start, finish, error, checkpoint"`
+}
+
+func (nc notifierConfig) validate() error {
+	switch nc.Type {
+	case "discord", "slack", "webhook":
+	default:
+		return fmt.Errorf("notification type %q: expected discord, slack or webhook", nc.Type)
+	}
+	if nc.URL == "" {
+		return fmt.Errorf("%s notification: no url", nc.Type)
+	}
+	for _, event := range nc.Events {
+		switch event {
+		case eventStart, eventFinish, eventError, eventCheckpoint:
+		default:
+			return fmt.Errorf("%s notification: unknown event %q, expected start, finish, error or checkpoint", nc.Type, event)
+		}
+	}
+	return nil
+}
+
+// runEvent is something that happened to a run. Run is nil when the run
+// stopped before it was set up.
+type runEvent struct {
+	Kind     string
+	Run      *migrator
+	Selected []collectionMigration
+	Started  time.Time
+	// Err is what an error event ended the run with; Aborted tells a run that
+	// stopped before the end from one that got there and failed
+	Err     error
+	Aborted bool
+	// Checkpoint is the position a checkpoint event recorded
+	Checkpoint checkpoint
+}
+
+func (e runEvent) title() string {
+	switch {
+	case e.Kind == eventStart:
+		return "Migration started"
+	case e.Kind == eventCheckpoint && e.Checkpoint.Done:
+		return fmt.Sprintf("Migrated %s, %d document(s)", e.Checkpoint.Collection, e.Checkpoint.Position)
+	case e.Kind == eventCheckpoint:
+		return fmt.Sprintf("Migrating %s, %d document(s) so far", e.Checkpoint.Collection, e.Checkpoint.Position)
+	case e.Kind == eventError && e.Aborted:
+		return "Migration aborted"
+	case e.Kind == eventError:
+		return "Migration finished with errors"
+	default:
+		return "Migration finished"
+	}
+}
+
+// totals returns the documents migrated and failed across the selected collections.
+func (e runEvent) totals() (migrated, failed int) {
+	if e.Run == nil {
+		return 0, 0
+	}
+	for _, cm := range e.Selected {
+		migrated += e.Run.migrated[cm.Name]
+	}
+	return migrated, e.Run.failed.total()
+}
+
+// summary lists how each selected collection fared, one line each, with the
+// collection names in the notifier's bold markup.
+func (e runEvent) summary(bold string) string {
+	if e.Run == nil {
+		return "No collection was migrated."
+	}
+	var b strings.Builder
+	for _, cm := range e.Selected {
+		if _, ok := e.Run.began[cm.Name]; !ok {
+			fmt.Fprintf(&b, "%s%s%s: not started\n", bold, cm.Name, bold)
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s%s: %d migrated", bold, cm.Name, bold, e.Run.migrated[cm.Name])
+		if skipped := e.Run.skipped[cm.Name]; skipped > 0 {
+			fmt.Fprintf(&b, ", %d skipped", skipped)
+		}
+		if failed := e.Run.failed.counts[cm.Name]; failed > 0 {
+			fmt.Fprintf(&b, ", %d failed", failed)
+		}
+		if e.Run.aborted[cm.Name] != nil {
+			b.WriteString(", aborted before the end")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// notifier tells somebody about a run event.
+type notifier interface {
+	notify(e runEvent) error
+}
+
+func newNotifier(nc notifierConfig) notifier {
+	switch nc.Type {
+	case "discord":
+		return &discordNotifier{webhook: nc.URL}
+	case "slack":
+		return &slackNotifier{webhook: nc.URL}
+	default:
+		return &webhookNotifier{url: nc.URL}
+	}
+}
+
+// notifications sends a run's events to every notifier subscribed to them.
+type notifications struct {
+	targets  []notifyTarget
+	selected []collectionMigration
+	started  time.Time
+}
+
+type notifyTarget struct {
+	name   string
+	n      notifier
+	events map[string]bool
+}
+
+// newNotifications sets up the notifiers of the config file, plus a Discord
+// one for --notify-discord.
+func newNotifications(configured []notifierConfig, discord string, selected []collectionMigration, started time.Time) *notifications {
+	if discord != "" {
+		configured = append(configured, notifierConfig{Type: "discord", URL: discord})
+	}
+	ns := &notifications{selected: selected, started: started}
+	for _, nc := range configured {
+		events := nc.Events
+		if events == nil {
+			events = defaultEvents
+		}
+		t := notifyTarget{name: nc.Type, n: newNotifier(nc), events: map[string]bool{}}
+		for _, event := range events {
+			t.events[event] = true
+		}
+		ns.targets = append(ns.targets, t)
+	}
+	return ns
+}
+
+// subscribe adds a notifier of the given events, such as the run history.
+func (ns *notifications) subscribe(name string, n notifier, events ...string) {
+	t := notifyTarget{name: name, n: n, events: map[string]bool{}}
+	for _, event := range events {
+		t.events[event] = true
+	}
+	ns.targets = append(ns.targets, t)
+}
+
+// send notifies the subscribers of e.Kind. A failed notification is only
+// logged, it mustn't change how the run goes.
+func (ns *notifications) send(e runEvent) {
+	if ns == nil {
+		return
+	}
+	e.Selected, e.Started = ns.selected, ns.started
+	for _, t := range ns.targets {
+		if !t.events[e.Kind] {
+			continue
+		}
+		if err := t.n.notify(e); err != nil {
+			logf(levelWarn, "error sending %s notification: %v", t.name, err)
+		}
+	}
+}
+
+// postJSON posts payload to url as JSON.
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// truncate cuts s to max characters, the unit chat services' limits are in.
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct {
+	webhook string
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Text   string       `json:"text"`
+	Fields []slackField `json:"fields"`
+	TS     int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (s *slackNotifier) notify(e runEvent) error {
+	migrated, failed := e.totals()
+	attachment := slackAttachment{
+		Color: "#2eb886",
+		Text:  truncate(e.summary("*"), 3000),
+		Fields: []slackField{
+			{Title: "Duration", Value: time.Since(e.Started).Round(time.Second).String(), Short: true},
+			{Title: "Migrated", Value: fmt.Sprint(migrated), Short: true},
+			{Title: "Failed", Value: fmt.Sprint(failed), Short: true},
+		},
+		TS: time.Now().Unix(),
+	}
+	if e.Err != nil {
+		attachment.Color = "#e01e5a"
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Error", Value: truncate(e.Err.Error(), 2000)})
+	}
+	return postJSON(s.webhook, slackMessage{Text: e.title(), Attachments: []slackAttachment{attachment}})
+}
+
+// webhookNotifier posts the event as plain JSON for receivers of our own.
+type webhookNotifier struct {
+	url string
+}
+
+type webhookEvent struct {
+	Event       string              `json:"event"`
+	Title       string              `json:"title"`
+	StartedAt   time.Time           `json:"startedAt"`
+	Duration    float64             `json:"durationSeconds"`
+	Error       string              `json:"error,omitempty"`
+	Collections []webhookCollection `json:"collections"`
+	Checkpoint  *checkpoint         `json:"checkpoint,omitempty"`
+}
+
+type webhookCollection struct {
+	Collection string `json:"collection"`
+	Migrated   int    `json:"migrated"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+	Aborted    bool   `json:"aborted"`
+}
+
+func (w *webhookNotifier) notify(e runEvent) error {
+	payload := webhookEvent{
+		Event:       e.Kind,
+		Title:       e.title(),
+		StartedAt:   e.Started.UTC(),
+		Duration:    time.Since(e.Started).Seconds(),
+		Collections: []webhookCollection{},
+	}
+	if e.Err != nil {
+		payload.Error = e.Err.Error()
+	}
+	if e.Kind == eventCheckpoint {
+		payload.Checkpoint = &e.Checkpoint
+	}
+	if e.Run != nil {
+		for _, cm := range e.Selected {
+			payload.Collections = append(payload.Collections, webhookCollection{
+				Collection: cm.Name,
+				Migrated:   e.Run.migrated[cm.Name],
+				Skipped:    e.Run.skipped[cm.Name],
+				Failed:     e.Run.failed.counts[cm.Name],
+				Aborted:    e.Run.aborted[cm.Name] != nil,
+			})
+		}
+	}
+	return postJSON(w.url, payload)
+}
@@ -0,0 +1,144 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectStore is an S3 compatible bucket media is copied into: AWS S3,
+// Cloudflare R2 or MinIO. Requests are path-style and signed with AWS
+// Signature Version 4, which all three accept.
+type objectStore struct {
+	Endpoint  string `json:"endpoint" doc:"S3 API endpoint, e.g. https://s3.eu-central-1.amazonaws.com or https://<account>.r2.cloudflarestorage.com"`
+	Region    string `json:"region" default:"us-east-1" doc:"region the requests are signed for; auto for R2"`
+	Bucket    string `json:"bucket" doc:"bucket the objects are written to"`
+	Prefix    string `json:"prefix" doc:"key prefix of the objects, e.g. media/"`
+	AccessKey string `json:"accessKey" doc:"access key ID, e.g. ${S3_ACCESS_KEY}"`
+	SecretKey string `json:"secretKey" doc:"secret access key, e.g. ${S3_SECRET_KEY}"`
+	PublicURL string `json:"publicURL" default:"the endpoint and bucket" doc:"base URL the objects are served from, stored in place of the old URLs"`
+}
+
+// objectKeyPattern limits prefixes to characters that need no escaping in a
+// request path, so the signed path is the one sent.
+var objectKeyPattern = regexp.MustCompile(`^[A-Za-z0-9/_.-]*$`)
+
+func (s *objectStore) validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("mediaStore: endpoint %q is not an http(s) URL", s.Endpoint)
+	}
+	if s.Bucket == "" || s.AccessKey == "" || s.SecretKey == "" {
+		return fmt.Errorf("mediaStore: bucket, accessKey and secretKey are required")
+	}
+	if !objectKeyPattern.MatchString(s.Prefix) {
+		return fmt.Errorf("mediaStore: invalid prefix %q", s.Prefix)
+	}
+	return nil
+}
+
+// objectURL is where the object stored under key is read from.
+func (s *objectStore) objectURL(key string) string {
+	base := s.PublicURL
+	if base == "" {
+		base = strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	}
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// put uploads body under key.
+func (s *objectStore) put(ctx context.Context, client *http.Client, key string, body []byte, contentType string) error {
+	path := "/" + s.Bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(s.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, path, contentType, hex.EncodeToString(sum[:]), time.Now().UTC())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload of %s: status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 headers for a PUT of a body hashing to
+// payloadHash to req.
+func (s *objectStore) sign(req *http.Request, path, contentType, payloadHash string, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	req.Header.Set("Content-Type", contentType)
+	signAWS(req, path, payloadHash, "s3", region, awsCredentials{AccessKey: s.AccessKey, SecretKey: s.SecretKey}, now)
+}
+
+// awsCredentials sign requests to AWS and the services compatible with it.
+type awsCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// signAWS adds the Signature Version 4 Authorization header for service to
+// req, covering host and every header already set. path is the request path
+// as sent and payloadHash the hex SHA-256 of the body.
+func signAWS(req *http.Request, path, payloadHash, service, region string, creds awsCredentials, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		name = strings.ToLower(name)
+		names = append(names, name)
+		values[name] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers.String(), signed, payloadHash}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + creds.SecretKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
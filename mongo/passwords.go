@@ -0,0 +1,192 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordSchemes recognises the formats legacy password fields were written
+// in, tried in order. Anything else is taken to be a plaintext password,
+// unless it looks like a hash of a format not listed here.
+var passwordSchemes = []struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Weak    bool
+}{
+	{Name: "wrapped", Pattern: regexp.MustCompile(`^\$wrap\$[a-z0-9]+\$\$2[abxy]?\$`)},
+	{Name: "bcrypt", Pattern: regexp.MustCompile(`^\$2[abxy]?\$\d\d\$[./A-Za-z0-9]{53}$`)},
+	{Name: "argon2", Pattern: regexp.MustCompile(`^\$argon2(id|i|d)\$`)},
+	{Name: "scrypt", Pattern: regexp.MustCompile(`^\$(scrypt|7)\$`)},
+	{Name: "pbkdf2", Pattern: regexp.MustCompile(`^(\$pbkdf2[-_a-z0-9]*\$|pbkdf2_[a-z0-9]+\$)`)},
+	{Name: "md5", Pattern: regexp.MustCompile(`^[0-9a-fA-F]{32}$`), Weak: true},
+	{Name: "sha1", Pattern: regexp.MustCompile(`^[0-9a-fA-F]{40}$`), Weak: true},
+	{Name: "sha256", Pattern: regexp.MustCompile(`^[0-9a-fA-F]{64}$`), Weak: true},
+}
+
+// unknownHash matches passwords that are hashes of some other format: modular
+// crypt strings such as "$6$salt$hash", LDAP style "{SSHA}…" values, and long
+// hex or base64 digests.
+var unknownHash = regexp.MustCompile(`^(\$[A-Za-z0-9_-]+\$|\{[A-Za-z0-9_-]+\}|[0-9a-fA-F]{32,}$|[A-Za-z0-9+/]{40,}={0,2}$)`)
+
+// passwordScheme names the format of a stored password: "" when there is
+// none, "unknown" for a hash of a format it doesn't know, "plaintext" when
+// it looks like no hash at all.
+func passwordScheme(password string) (scheme string, weak bool) {
+	if password == "" {
+		return "", false
+	}
+	for _, s := range passwordSchemes {
+		if s.Pattern.MatchString(password) {
+			return s.Name, s.Weak
+		}
+	}
+	if unknownHash.MatchString(password) {
+		return "unknown", false
+	}
+	return "plaintext", true
+}
+
+// bcryptMaxPassword is the longest password bcrypt takes, in bytes.
+const bcryptMaxPassword = 72
+
+// passwordPolicy decides what happens to the password of every migrated
+// user. "report" migrates them as they are; "rewrap" stores weak hashes as
+// bcrypt of the old hash, "$wrap$<scheme>$<bcrypt>", which the API can verify
+// by hashing the login password with <scheme> first, and plaintext passwords
+// as plain bcrypt. Hashes of an unknown format and plaintext passwords too
+// long for bcrypt are never rewritten. Either
+// way, users whose password was weak, plaintext or of an unknown format are
+// listed in a report. A nil *passwordPolicy leaves passwords untouched.
+type passwordPolicy struct {
+	policy string
+	path   string
+	report *os.File
+	enc    *json.Encoder
+	counts map[string]int
+	// tooLong counts the plaintext passwords kept for being too long for bcrypt
+	tooLong int
+}
+
+// passwordFinding is one line of the password report.
+type passwordFinding struct {
+	User   string `json:"user"`
+	Scheme string `json:"scheme"`
+	Action string `json:"action"`
+}
+
+func newPasswordPolicy(policy, path string) (*passwordPolicy, error) {
+	if policy != "report" && policy != "rewrap" {
+		return nil, fmt.Errorf("unknown --password-policy %q, expected report or rewrap", policy)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating password report: %v", err)
+	}
+	return &passwordPolicy{policy: policy, path: path, report: f, enc: json.NewEncoder(f), counts: map[string]int{}}, nil
+}
+
+// rewrite replaces the passwords of the users rows with the ones to store,
+// recording their schemes and findings in l.
+func (p *passwordPolicy) rewrite(rows []tableRow, l *ledger) error {
+	if p == nil {
+		return nil
+	}
+	for _, row := range rows {
+		if row.Table != "users" {
+			continue
+		}
+		for i, col := range row.Columns {
+			password, ok := row.Values[i].(string)
+			if col != "password" || !ok {
+				continue
+			}
+			stored, err := p.apply(rowKey(row), password, l)
+			if err != nil {
+				return err
+			}
+			row.Values[i] = stored
+		}
+	}
+	return nil
+}
+
+// apply returns the password to store for user.
+func (p *passwordPolicy) apply(user, password string, l *ledger) (string, error) {
+	scheme, weak := passwordScheme(password)
+	if scheme == "" {
+		return password, nil
+	}
+	l.add(func() error {
+		p.counts[scheme]++
+		return nil
+	})
+	if !weak && scheme != "unknown" {
+		return password, nil
+	}
+
+	action := "kept"
+	switch {
+	// Rehashing a hash the API can't verify would lock its user out
+	case p.policy != "rewrap" || scheme == "unknown":
+	case len(password) > bcryptMaxPassword:
+		action = "too long"
+		l.add(func() error {
+			p.tooLong++
+			return nil
+		})
+	default:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", fmt.Errorf("error rehashing password: %v", err)
+		}
+		if scheme == "plaintext" {
+			password, action = string(hash), "hashed"
+		} else {
+			password, action = "$wrap$"+scheme+"$"+string(hash), "wrapped"
+		}
+	}
+	finding := passwordFinding{User: user, Scheme: scheme, Action: action}
+	l.add(func() error {
+		if err := p.enc.Encode(finding); err != nil {
+			return fmt.Errorf("error writing %s: %v", p.path, err)
+		}
+		return nil
+	})
+	return password, nil
+}
+
+// summary logs how many passwords of each scheme were seen.
+func (p *passwordPolicy) summary() {
+	if p == nil || len(p.counts) == 0 {
+		return
+	}
+	schemes := make([]string, 0, len(p.counts))
+	for scheme := range p.counts {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	parts := make([]string, len(schemes))
+	for i, scheme := range schemes {
+		parts[i] = fmt.Sprintf("%s (%d)", scheme, p.counts[scheme])
+	}
+	logf(levelInfo, "Passwords by scheme: %s, weak and unknown ones listed in %s", strings.Join(parts, ", "), p.path)
+	if n := p.counts["unknown"]; n > 0 {
+		logf(levelWarn, "%d password(s) are hashes of an unknown format and were kept as they are", n)
+	}
+	if p.tooLong > 0 {
+		logf(levelWarn, "%d plaintext password(s) are longer than bcrypt's %d bytes and were kept as they are", p.tooLong, bcryptMaxPassword)
+	}
+}
+
+func (p *passwordPolicy) Close() error {
+	if p == nil {
+		return nil
+	}
+	return p.report.Close()
+}
@@ -0,0 +1,140 @@
+package mongo
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordScheme(t *testing.T) {
+	tests := []struct {
+		password string
+		scheme   string
+		weak     bool
+	}{
+		{"", "", false},
+		{"$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "bcrypt", false},
+		{"$2b$12$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "bcrypt", false},
+		{"$wrap$md5$$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "wrapped", false},
+		{"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA", "argon2", false},
+		{"$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA", "scrypt", false},
+		{"$7$C6..../....SodiumChloride$kBGj9fHznVYFQMEn/qDCfrDevf9YDtcDdKvEqHJLV8D", "scrypt", false},
+		{"pbkdf2_sha256$260000$salt$hash", "pbkdf2", false},
+		{"$pbkdf2-sha512$25000$salt$hash", "pbkdf2", false},
+		{"5f4dcc3b5aa765d61d8327deb882cf99", "md5", true},
+		{"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8", "sha1", true},
+		{"5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", "sha256", true},
+		{"$6$saltsalt$hash", "unknown", false},
+		{"{SSHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", "unknown", false},
+		{strings.Repeat("ab", 64), "unknown", false},
+		{"dGhpcyBpcyBhIGJhc2U2NCBkaWdlc3Qgb2YgYSBwYXNzd29yZA==", "unknown", false},
+		{"hunter2", "plaintext", true},
+		{"correct horse battery staple", "plaintext", true},
+		// Truncated bcrypt is no bcrypt, but still a crypt string
+		{"$2a$10$short", "unknown", false},
+	}
+	for _, tt := range tests {
+		scheme, weak := passwordScheme(tt.password)
+		if scheme != tt.scheme || weak != tt.weak {
+			t.Errorf("passwordScheme(%q) = %q, %v, want %q, %v", tt.password, scheme, weak, tt.scheme, tt.weak)
+		}
+	}
+}
+
+func TestPasswordPolicyRewrap(t *testing.T) {
+	long := strings.Repeat("correct horse ", bcryptMaxPassword/len("correct horse ")+1)
+	tests := []struct {
+		policy, password string
+		// prefix is what the stored password starts with, "" when it is
+		// stored unchanged
+		prefix string
+		action string
+	}{
+		{"report", "hunter2", "", "kept"},
+		{"report", "5f4dcc3b5aa765d61d8327deb882cf99", "", "kept"},
+		{"rewrap", "hunter2", "$2a$", "hashed"},
+		{"rewrap", "5f4dcc3b5aa765d61d8327deb882cf99", "$wrap$md5$$2a$", "wrapped"},
+		{"rewrap", "$6$saltsalt$hash", "", "kept"},
+		{"rewrap", long, "", "too long"},
+		{"rewrap", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "", ""},
+	}
+	for _, tt := range tests {
+		p, err := newPasswordPolicy(tt.policy, filepath.Join(t.TempDir(), "passwords.ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := []tableRow{{Table: "users", Columns: []string{"id", "password"}, Values: []interface{}{"u1", tt.password}}}
+		l := &ledger{}
+		if err := p.rewrite(rows, l); err != nil {
+			t.Fatalf("%s %q: %v", tt.policy, tt.password, err)
+		}
+		stored := rows[0].Values[1].(string)
+		switch {
+		case tt.prefix == "" && stored != tt.password:
+			t.Errorf("%s %q: stored as %q, want it unchanged", tt.policy, tt.password, stored)
+		case tt.prefix != "" && !strings.HasPrefix(stored, tt.prefix):
+			t.Errorf("%s %q: stored as %q, want it to start with %q", tt.policy, tt.password, stored, tt.prefix)
+		}
+		if tt.action == "hashed" {
+			if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(tt.password)); err != nil {
+				t.Errorf("%s %q: the stored hash doesn't verify: %v", tt.policy, tt.password, err)
+			}
+		}
+		if tt.action == "wrapped" {
+			hash := strings.TrimPrefix(stored, "$wrap$md5$")
+			if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(tt.password)); err != nil {
+				t.Errorf("%s %q: the wrapped hash doesn't verify the old one: %v", tt.policy, tt.password, err)
+			}
+		}
+
+		// Nothing is counted or reported before the ledger is settled
+		if len(p.counts) != 0 {
+			t.Errorf("%s %q: counted before settling", tt.policy, tt.password)
+		}
+		m := &migrator{}
+		if err := m.settle(l); err != nil {
+			t.Fatal(err)
+		}
+		p.Close()
+		findings := readReport[passwordFinding](t, p.path)
+		switch {
+		case tt.action == "" && len(findings) != 0:
+			t.Errorf("%s %q: reported %+v", tt.policy, tt.password, findings)
+		case tt.action != "" && (len(findings) != 1 || findings[0] != passwordFinding{User: "u1", Scheme: schemeOf(tt.password), Action: tt.action}):
+			t.Errorf("%s %q: findings = %+v, want one with action %q", tt.policy, tt.password, findings, tt.action)
+		}
+		if tt.action == "too long" && p.tooLong != 1 {
+			t.Errorf("%s %q: %d too long, want 1", tt.policy, tt.password, p.tooLong)
+		}
+	}
+}
+
+func schemeOf(password string) string {
+	scheme, _ := passwordScheme(password)
+	return scheme
+}
+
+// readReport decodes the NDJSON lines of a report file.
+func readReport[T any](t *testing.T, path string) []T {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []T
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line T
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
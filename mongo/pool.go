@@ -0,0 +1,113 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// poolFlags size the connection pools of both databases for every command.
+var poolFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:    "mysql-max-open-conns",
+		EnvVars: []string{"MYSQL_MAX_OPEN_CONNS"},
+		Usage:   "most connections open to MySQL at once, 0 for no limit; keep it under the server's max_connections",
+	},
+	&cli.IntFlag{
+		Name:    "mysql-max-idle-conns",
+		EnvVars: []string{"MYSQL_MAX_IDLE_CONNS"},
+		Value:   2,
+		Usage:   "idle MySQL connections kept open for reuse",
+	},
+	&cli.DurationFlag{
+		Name:    "mysql-conn-max-lifetime",
+		EnvVars: []string{"MYSQL_CONN_MAX_LIFETIME"},
+		Usage:   "age after which a MySQL connection is closed and replaced, e.g. 5m; 0 keeps them, but should be under the server's wait_timeout",
+	},
+	&cli.DurationFlag{
+		Name:    "mysql-conn-max-idle-time",
+		EnvVars: []string{"MYSQL_CONN_MAX_IDLE_TIME"},
+		Usage:   "time after which an idle MySQL connection is closed, 0 for never",
+	},
+	&cli.Uint64Flag{
+		Name:    "mongodb-max-pool-size",
+		EnvVars: []string{"MONGODB_MAX_POOL_SIZE"},
+		Usage:   "most connections open to each MongoDB server (default the connection string's maxPoolSize, or 100)",
+	},
+	&cli.Uint64Flag{
+		Name:    "mongodb-min-pool-size",
+		EnvVars: []string{"MONGODB_MIN_POOL_SIZE"},
+		Usage:   "connections kept open to each MongoDB server even when idle",
+	},
+}
+
+// mysqlPool and mongoPool are the pool settings of the flags, database/sql's
+// and the driver's defaults until they are read.
+var (
+	mysqlPool = struct {
+		maxOpen, maxIdle         int
+		maxLifetime, maxIdleTime time.Duration
+	}{maxIdle: 2}
+	mongoPool struct {
+		// nil leaves the connection string's setting
+		maxSize, minSize *uint64
+	}
+)
+
+// applyPool reads the pool flags into mysqlPool and mongoPool.
+func applyPool(c *cli.Context) error {
+	mysqlPool.maxOpen = c.Int("mysql-max-open-conns")
+	mysqlPool.maxIdle = c.Int("mysql-max-idle-conns")
+	if mysqlPool.maxOpen < 0 || mysqlPool.maxIdle < 0 {
+		return fmt.Errorf("--mysql-max-open-conns and --mysql-max-idle-conns can't be negative")
+	}
+	// migrate records ID mappings while a transaction holds a connection
+	if mysqlPool.maxOpen == 1 {
+		return fmt.Errorf("--mysql-max-open-conns must be 0 or at least 2")
+	}
+	mysqlPool.maxLifetime = c.Duration("mysql-conn-max-lifetime")
+	mysqlPool.maxIdleTime = c.Duration("mysql-conn-max-idle-time")
+	if c.IsSet("mongodb-max-pool-size") {
+		n := c.Uint64("mongodb-max-pool-size")
+		mongoPool.maxSize = &n
+	}
+	if c.IsSet("mongodb-min-pool-size") {
+		n := c.Uint64("mongodb-min-pool-size")
+		mongoPool.minSize = &n
+	}
+	if max, min := mongoPool.maxSize, mongoPool.minSize; max != nil && min != nil && *max != 0 && *min > *max {
+		return fmt.Errorf("--mongodb-min-pool-size can't be above --mongodb-max-pool-size")
+	}
+	return nil
+}
+
+// sqlOpen opens a pool of connections to the MySQL database behind uri, with
+// the TLS, tunnel and pool flags applied. Like sql.Open it doesn't connect yet.
+func sqlOpen(uri string) (*sql.DB, error) {
+	uri, err := mysqlDSN(uri)
+	if err != nil {
+		return nil, err
+	}
+	mysqlDB, err := sql.Open("mysql", uri)
+	if err != nil {
+		return nil, err
+	}
+	mysqlDB.SetMaxOpenConns(mysqlPool.maxOpen)
+	mysqlDB.SetMaxIdleConns(mysqlPool.maxIdle)
+	mysqlDB.SetConnMaxLifetime(mysqlPool.maxLifetime)
+	mysqlDB.SetConnMaxIdleTime(mysqlPool.maxIdleTime)
+	return mysqlDB, nil
+}
+
+// setMongoPool applies the pool flags to opts.
+func setMongoPool(opts *options.ClientOptions) {
+	if mongoPool.maxSize != nil {
+		opts.SetMaxPoolSize(*mongoPool.maxSize)
+	}
+	if mongoPool.minSize != nil {
+		opts.SetMinPoolSize(*mongoPool.minSize)
+	}
+}
@@ -0,0 +1,140 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var preflightCommand = &cli.Command{
+	Name:  "preflight",
+	Usage: "Sample the MongoDB collections and report fields the migration would drop or never finds",
+	Flags: withFlags(mongoFlags, normalizeFlags, []cli.Flag{
+		&cli.StringFlag{
+			Name:  "collections",
+			Usage: "comma separated list of collections to sample (default: all)",
+		},
+		&cli.IntFlag{Name: "sample", Value: 1000, Usage: "number of documents to sample per collection"},
+	}),
+	Action:       preflight,
+	BashComplete: completeCollections(true, "collections"),
+}
+
+// fieldDrift is how many sampled documents of a collection disagreed with its
+// migration about one field.
+type fieldDrift struct {
+	Collection string
+	Field      string
+	// Unknown is set for a field the documents have and nothing migrates,
+	// unset for one the migration reads and the documents lack
+	Unknown   bool
+	Documents int
+	Sampled   int
+}
+
+func preflight(c *cli.Context) error {
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	selected, err := selectCollections(cfg.migrations(), c.String("collections"), "")
+	if err != nil {
+		return err
+	}
+	if c.Bool("normalize-comments") {
+		selected = coverFields(selected, "posts", "comments")
+	}
+	if c.Bool("normalize-hearts") {
+		selected = coverFields(selected, "posts", "hearts")
+	}
+	if c.Bool("normalize-links") {
+		selected = coverFields(selected, "users", "links")
+	}
+
+	source, err := newMongoSource(c.Context, c.String("mongodb-uri"), nil)
+	if err != nil {
+		return err
+	}
+	defer source.Close(context.TODO())
+
+	var drift []fieldDrift
+	for _, cm := range selected {
+		found, err := sampleDrift(c.Context, source.db.Collection(cm.Name), cm, c.Int("sample"))
+		if err != nil {
+			return err
+		}
+		drift = append(drift, found...)
+	}
+
+	dropped := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tFIELD\tDRIFT\tDOCUMENTS")
+	for _, d := range drift {
+		kind := "missing from documents"
+		if d.Unknown {
+			kind = "not migrated, dropped"
+			dropped++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d of %d\n", d.Collection, d.Field, kind, d.Documents, d.Sampled)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if dropped > 0 {
+		return cli.Exit(fmt.Sprintf("%d field(s) in the sampled documents would be dropped by the migration", dropped), 1)
+	}
+	return nil
+}
+
+// sampleDrift reads up to n random documents of coll and compares their
+// fields with the ones cm migrates: fields no path of cm covers are unknown,
+// covered fields absent or null in documents are missing. Unknown fields come
+// first, each kind sorted by name.
+func sampleDrift(ctx context.Context, coll *mongo.Collection, cm collectionMigration, n int) ([]fieldDrift, error) {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$sample", Value: bson.D{{Key: "size", Value: n}}}}})
+	if err != nil {
+		return nil, fmt.Errorf("error sampling %s: %v", cm.Name, err)
+	}
+	defer cursor.Close(ctx)
+
+	unknown, missing := map[string]int{}, map[string]int{}
+	sampled := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %v", cm.Name, err)
+		}
+		sampled++
+		for _, path := range unknownFields(doc, cm.Fields) {
+			unknown[path]++
+		}
+		for _, path := range cm.Fields {
+			if lookupField(doc, path) == nil {
+				missing[path]++
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error sampling %s: %v", cm.Name, err)
+	}
+	logf(levelInfo, "%s: %d document(s) sampled, %d unknown and %d missing field(s)", cm.Name, sampled, len(unknown), len(missing))
+
+	var drift []fieldDrift
+	for i, counts := range []map[string]int{unknown, missing} {
+		fields := make([]string, 0, len(counts))
+		for field := range counts {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			drift = append(drift, fieldDrift{Collection: cm.Name, Field: field, Unknown: i == 0, Documents: counts[field], Sampled: sampled})
+		}
+	}
+	return drift, nil
+}
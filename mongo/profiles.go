@@ -0,0 +1,129 @@
+package mongo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/urfave/cli/v2"
+)
+
+// profile is one named environment of the config file, such as dev, staging
+// or prod. Selecting it with --profile points the commands at its databases.
+type profile struct {
+	MongoDBURI string `json:"mongodbURI" doc:"MongoDB connection string, in place of MONGODB_URI"`
+	MySQLURI   string `json:"mysqlURI" doc:"MySQL DSN, in place of MYSQL_URI"`
+	APIURL     string `json:"apiURL" doc:"NetSocial API URL, in place of NETSOCIAL_API_URL"`
+	Production bool   `json:"production" doc:"commands writing to the profile need --yes-i-mean-it or the database name typed in"`
+}
+
+func (p profile) validate(name string) error {
+	database := ""
+	if p.MySQLURI != "" {
+		dsn, err := mysql.ParseDSN(p.MySQLURI)
+		if err != nil {
+			return fmt.Errorf("profiles: %s: invalid mysqlURI: %v", name, err)
+		}
+		database = dsn.DBName
+	}
+	// The database name is what confirms a write to it
+	if p.Production && database == "" {
+		return fmt.Errorf("profiles: %s: a production profile needs a mysqlURI naming its database", name)
+	}
+	return nil
+}
+
+// writingCommands write to the target database, so they are gated on
+// production profiles.
+var writingCommands = [][]string{
+	{"migrate"},
+	{"import"},
+	{"schema", "up"},
+	{"schema", "down"},
+	{"backfill-badges"},
+	{"bench"},
+	{"seed"},
+	{"daemon", "run"},
+}
+
+// productionConfirmed is set once a command was confirmed against a
+// production profile, so the runs daemon run starts aren't asked again.
+var productionConfirmed bool
+
+// applyProfile points the command about to run at the databases of the
+// --profile, through the environment variables the flags read, and asks for
+// confirmation before a write to a production profile. Flags given on the
+// command line still win over the profile.
+func applyProfile(c *cli.Context) error {
+	name := c.String("profile")
+	if name == "" {
+		return nil
+	}
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown --profile %q, the config has no profiles", name)
+		}
+		return fmt.Errorf("unknown --profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	for env, value := range map[string]string{"MONGODB_URI": p.MongoDBURI, "MYSQL_URI": p.MySQLURI, "NETSOCIAL_API_URL": p.APIURL} {
+		if value != "" {
+			os.Setenv(env, value)
+		}
+	}
+	logf(levelInfo, "Using profile %s", name)
+
+	cmd := commandPath(c.App.Commands, c.Args().Slice())
+	writes := false
+	for _, w := range writingCommands {
+		writes = writes || isPrefix(w, cmd)
+	}
+	if !p.Production || !writes || productionConfirmed {
+		return nil
+	}
+	dsn, _ := mysql.ParseDSN(p.MySQLURI)
+	if err := confirmProduction(c, name, strings.Join(cmd, " "), dsn.DBName); err != nil {
+		return err
+	}
+	productionConfirmed = true
+	return nil
+}
+
+// confirmProduction passes with --yes-i-mean-it, or when the operator types
+// the database name at the prompt. Without a terminal to ask on it refuses.
+func confirmProduction(c *cli.Context, profile, command, database string) error {
+	if c.Bool("yes-i-mean-it") {
+		logf(levelWarn, "Running %s against production profile %s (%s), confirmed by --yes-i-mean-it", command, profile, database)
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%s writes to production profile %s; pass --yes-i-mean-it to confirm", command, profile)
+	}
+	fmt.Fprintf(os.Stderr, "Profile %s is PRODUCTION and %s writes to database %s.\nType the database name to continue: ", profile, command, database)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("no answer; pass --yes-i-mean-it to confirm %s against production profile %s", command, profile)
+	}
+	if strings.TrimSpace(answer) != database {
+		return cli.Exit("Not confirmed, nothing was done", 1)
+	}
+	return nil
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
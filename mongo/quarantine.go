@@ -0,0 +1,244 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var quarantineCommand = &cli.Command{
+	Name:  "quarantine",
+	Usage: "Inspect the documents a run sent to its quarantine directory",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "list",
+			Usage:     "List quarantined documents as <collection>:<n> with their errors",
+			Flags:     withFlags(runsFlags, runFlags),
+			Action:    quarantineList,
+			ArgsUsage: " ",
+		},
+		{
+			Name:      "show",
+			Usage:     "Show a quarantined document, the rows it turns into and the error, marking the offending fields",
+			ArgsUsage: "<collection>:<n> | <document id>",
+			Flags:     withFlags(runsFlags, runFlags),
+			Action:    quarantineShow,
+		},
+	},
+}
+
+var runFlags = []cli.Flag{
+	&cli.StringFlag{Name: "run", Usage: "run ID (default: the newest run)"},
+}
+
+// quarantinedDocument is one entry of a run's failed_<collection>.ndjson files.
+type quarantinedDocument struct {
+	Collection string
+	N          int
+	failedDocument
+}
+
+func (q quarantinedDocument) ref() string {
+	return fmt.Sprintf("%s:%d", q.Collection, q.N)
+}
+
+// quarantineDir finds the quarantine directory of the run chosen with --run.
+func quarantineDir(c *cli.Context) (string, error) {
+	root := c.String("runs-dir")
+	id := c.String("run")
+	if id == "" {
+		runs, err := listRuns(root)
+		if err != nil {
+			return "", err
+		}
+		if len(runs) == 0 {
+			return "", fmt.Errorf("no runs in %s", root)
+		}
+		id = runs[len(runs)-1]
+	}
+	return filepath.Join(root, id, "quarantine"), nil
+}
+
+// loadQuarantine reads every quarantined document of a run, by collection
+// and in the order they failed.
+func loadQuarantine(dir string) ([]quarantinedDocument, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "failed_*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	var docs []quarantinedDocument
+	for _, path := range paths {
+		collection := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "failed_"), ".ndjson")
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		n := 0
+		for scanner.Scan() {
+			n++
+			var doc failedDocument
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s line %d: %v", path, n, err)
+			}
+			docs = append(docs, quarantinedDocument{Collection: collection, N: n, failedDocument: doc})
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+	}
+	return docs, nil
+}
+
+func quarantineList(c *cli.Context) error {
+	dir, err := quarantineDir(c)
+	if err != nil {
+		return err
+	}
+	docs, err := loadQuarantine(dir)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		fmt.Printf("%-16s %-26s %s\n", doc.ref(), documentID(doc.Document), doc.Error)
+	}
+	return nil
+}
+
+func quarantineShow(c *cli.Context) error {
+	want := c.Args().First()
+	if want == "" {
+		return fmt.Errorf("which document? Pass <collection>:<n> or a document ID, see quarantine list")
+	}
+	dir, err := quarantineDir(c)
+	if err != nil {
+		return err
+	}
+	docs, err := loadQuarantine(dir)
+	if err != nil {
+		return err
+	}
+	var found *quarantinedDocument
+	for i := range docs {
+		if docs[i].ref() == want || documentID(docs[i].Document) == want {
+			found = &docs[i]
+			break
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("no quarantined document %s in %s", want, dir)
+	}
+
+	fields := offendingFields(found.Error)
+	fmt.Printf("%s (%s)\n\nError:\n  %s\n", found.ref(), documentID(found.Document), found.Error)
+
+	fmt.Println("\nSource document:")
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, found.Document, "", "  "); err != nil {
+		pretty.Write(found.Document)
+	}
+	for _, line := range strings.Split(pretty.String(), "\n") {
+		marker := "  "
+		for _, field := range fields {
+			if strings.HasPrefix(strings.TrimSpace(line), strconv.Quote(field)+":") {
+				marker = "> "
+			}
+		}
+		fmt.Println(marker + line)
+	}
+
+	fmt.Println("\nTransformed output:")
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
+	selected, err := selectCollections(cfg.migrations(), found.Collection, "")
+	if err != nil {
+		fmt.Printf("  not available: %v\n", err)
+		return nil
+	}
+	rows, err := selected[0].Rows(&migrator{}, &quarantinedCursor{doc: found.Document})
+	if err != nil {
+		fmt.Printf("  no rows, the transfer function failed: %v\n", err)
+		return nil
+	}
+	for _, row := range rows {
+		fmt.Printf("  %s\n", row.Table)
+		for i, col := range row.Columns {
+			v, err := exportValue(row.Values[i])
+			if err != nil {
+				v = err
+			}
+			fmt.Printf("    %-16s %v\n", col, v)
+		}
+	}
+	return nil
+}
+
+// offendingFieldPatterns pull field names out of the errors transfers fail with.
+var offendingFieldPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`decoding key ([\w.]+)`),
+	regexp.MustCompile(`^field ([\w.]+):`),
+	regexp.MustCompile(`not covered by the migration: (.+)$`),
+	regexp.MustCompile(`for column '(\w+)'`),
+}
+
+// offendingFields returns the last path segment of every field an error
+// names, for matching against the keys of the pretty-printed document.
+func offendingFields(cause string) []string {
+	var fields []string
+	for _, p := range offendingFieldPatterns {
+		m := p.FindStringSubmatch(cause)
+		if m == nil {
+			continue
+		}
+		for _, path := range strings.Split(m[1], ", ") {
+			parts := strings.Split(path, ".")
+			fields = append(fields, parts[len(parts)-1])
+		}
+	}
+	return fields
+}
+
+// documentID returns the _id of a quarantined document as text.
+func documentID(doc json.RawMessage) string {
+	var d struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := bson.UnmarshalExtJSON(doc, false, &d); err != nil || d.ID == nil {
+		return "-"
+	}
+	v, err := convertField(d.ID, "objectid", nil)
+	if err != nil {
+		return fmt.Sprint(d.ID)
+	}
+	return fmt.Sprint(v)
+}
+
+// quarantinedCursor presents one quarantined document to a transfer function.
+type quarantinedCursor struct {
+	doc json.RawMessage
+}
+
+func (c *quarantinedCursor) Next(ctx context.Context) bool { return false }
+func (c *quarantinedCursor) Decode(v interface{}) error {
+	return bson.UnmarshalExtJSON(c.doc, false, v)
+}
+func (c *quarantinedCursor) Raw() json.RawMessage            { return c.doc }
+func (c *quarantinedCursor) Err() error                      { return nil }
+func (c *quarantinedCursor) Close(ctx context.Context) error { return nil }
+func (c *quarantinedCursor) Detach() documentCursor          { return c }
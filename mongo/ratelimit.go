@@ -0,0 +1,51 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// rateLimiter is a token bucket allowing rate operations per second on
+// average, in bursts of up to a second's worth. A nil *rateLimiter never waits.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for rate operations per second, or nil for
+// a rate of 0. flag names the option the rate came from, for the error.
+func newRateLimiter(flag string, rate float64) (*rateLimiter, error) {
+	if rate < 0 {
+		return nil, fmt.Errorf("--%s can't be negative", flag)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	burst := math.Max(1, rate)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}, nil
+}
+
+// wait takes a token, waiting until one is available or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		l.tokens, l.last = 1, now.Add(delay)
+	}
+	l.tokens--
+	return nil
+}
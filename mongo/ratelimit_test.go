@@ -0,0 +1,84 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		rate    float64
+		burst   float64
+		wantErr bool
+	}{
+		{0, 0, false},
+		{-1, 0, true},
+		{0.5, 1, false},
+		{20, 20, false},
+	}
+	for _, tt := range tests {
+		l, err := newRateLimiter("rate-limit", tt.rate)
+		switch {
+		case tt.wantErr:
+			if err == nil {
+				t.Errorf("newRateLimiter(%v) succeeded", tt.rate)
+			}
+		case err != nil:
+			t.Errorf("newRateLimiter(%v): %v", tt.rate, err)
+		case tt.rate == 0 && l != nil:
+			t.Errorf("newRateLimiter(0) = %+v, want nil", l)
+		case tt.rate != 0 && l.burst != tt.burst:
+			t.Errorf("newRateLimiter(%v) bursts %v, want %v", tt.rate, l.burst, tt.burst)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	const rate = 50
+	l, err := newRateLimiter("rate-limit", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < rate; i++ {
+		if err := l.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second/rate*5 {
+		t.Errorf("a burst of %d took %s", rate, elapsed)
+	}
+
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Second/rate*4 {
+		t.Errorf("5 operations past the burst took %s, want at least %s", elapsed, time.Second/rate*4)
+	}
+
+	var nilLimiter *rateLimiter
+	if err := nilLimiter.wait(ctx); err != nil {
+		t.Errorf("a nil limiter returned %v", err)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	l, err := newRateLimiter("rate-limit", 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := l.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait after cancel = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,106 @@
+package mongo
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxRetryDelay caps the exponential backoff between two attempts.
+const maxRetryDelay = 30 * time.Second
+
+// retrier re-runs operations that failed with a transient error, backing off
+// exponentially with jitter, and counts how often each kind of operation had
+// to be retried. A nil *retrier runs every operation exactly once.
+type retrier struct {
+	// ctx cuts the backoff short when the command is being stopped
+	ctx        context.Context
+	maxRetries int
+	baseDelay  time.Duration
+
+	mu      sync.Mutex
+	retried map[string]int
+}
+
+func newRetrier(ctx context.Context, maxRetries int, baseDelay time.Duration) *retrier {
+	return &retrier{ctx: ctx, maxRetries: maxRetries, baseDelay: baseDelay, retried: map[string]int{}}
+}
+
+// do calls fn until it succeeds, fails with a permanent error or runs out of retries.
+func (r *retrier) do(op string, fn func() error) error {
+	err := fn()
+	if r == nil {
+		return err
+	}
+	for attempt := 0; err != nil && isTransient(err) && attempt < r.maxRetries; attempt++ {
+		delay := r.backoff(attempt)
+		logf(levelWarn, "%s failed (%v), retrying in %s", op, err, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+
+		r.mu.Lock()
+		r.retried[op]++
+		r.mu.Unlock()
+
+		err = fn()
+	}
+	return err
+}
+
+// backoff returns a random delay between half and all of baseDelay*2^attempt.
+func (r *retrier) backoff(attempt int) time.Duration {
+	delay := r.baseDelay << uint(attempt)
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// summary logs how many retries each kind of operation needed.
+func (r *retrier) summary() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.retried) == 0 {
+		return
+	}
+	ops := make([]string, 0, len(r.retried))
+	for op := range r.retried {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		logf(levelInfo, "Retried %s %d time(s)", op, r.retried[op])
+	}
+}
+
+// isTransient reports whether err looks like a network blip rather than a problem with the data.
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,163 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// defaultRolesPath is where the roles file of a shared installation lives;
+// CLI_TOOLS_ROLES points elsewhere on hosts that don't have it.
+const defaultRolesPath = "/etc/cli-tools/roles.json"
+
+// rolesFile restricts which commands each operator may run. Roles list the
+// command paths they allow, like "export" (with all its subcommands),
+// "schema status" or "*" for everything. Operators are matched by the
+// SHA-256 of CLI_TOOLS_TOKEN when it is set, otherwise by OS user; anyone
+// unmatched gets the Default role, and no role at all when that is empty.
+type rolesFile struct {
+	Roles     map[string][]string `json:"roles"`
+	Operators []operator          `json:"operators"`
+	Default   string              `json:"default"`
+}
+
+type operator struct {
+	User  string `json:"user"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// alwaysAllowed are the commands that can't change or reveal anything.
+var alwaysAllowed = []string{"help", "h", "completion"}
+
+// loadRoles reads the roles file, returning nil when there is none. The
+// environment can't replace the file of a shared installation, and a file
+// CLI_TOOLS_ROLES names must exist, so neither lifts the restrictions.
+func loadRoles() (*rolesFile, string, error) {
+	path := defaultRolesPath
+	data, err := os.ReadFile(path)
+	if env := os.Getenv("CLI_TOOLS_ROLES"); env != "" && env != path {
+		if !errors.Is(err, os.ErrNotExist) {
+			logf(levelWarn, "Ignoring CLI_TOOLS_ROLES, %s applies", path)
+		} else {
+			path = env
+			data, err = os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, path, fmt.Errorf("roles file %s named by CLI_TOOLS_ROLES doesn't exist", path)
+			}
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, path, nil
+	}
+	if err != nil {
+		return nil, path, fmt.Errorf("error reading roles file: %v", err)
+	}
+	roles := &rolesFile{}
+	if err := json.Unmarshal(data, roles); err != nil {
+		return nil, path, fmt.Errorf("error parsing roles file %s: %v", path, err)
+	}
+	for _, op := range roles.Operators {
+		if _, ok := roles.Roles[op.Role]; !ok {
+			return nil, path, fmt.Errorf("roles file %s: unknown role %q", path, op.Role)
+		}
+	}
+	if _, ok := roles.Roles[roles.Default]; roles.Default != "" && !ok {
+		return nil, path, fmt.Errorf("roles file %s: unknown default role %q", path, roles.Default)
+	}
+	return roles, path, nil
+}
+
+// enforceRoles refuses to run the command c is about to run unless the roles
+// file allows it for the current operator. Without a roles file everything
+// is allowed.
+func enforceRoles(c *cli.Context) error {
+	roles, path, err := loadRoles()
+	if err != nil || roles == nil {
+		return err
+	}
+	cmd := commandPath(c.App.Commands, c.Args().Slice())
+	if len(cmd) == 0 {
+		return nil
+	}
+	for _, name := range alwaysAllowed {
+		if cmd[0] == name {
+			return nil
+		}
+	}
+
+	who, role := roles.operatorRole()
+	logf(levelDebug, "Operator %s has role %q", who, role)
+	for _, allowed := range roles.Roles[role] {
+		if allowed == "*" || isPrefix(strings.Fields(allowed), cmd) {
+			return nil
+		}
+	}
+	if role == "" {
+		return fmt.Errorf("%s has no role in %s and may not run %s", who, path, strings.Join(cmd, " "))
+	}
+	return fmt.Errorf("%s (role %s) may not run %s, see %s", who, role, strings.Join(cmd, " "), path)
+}
+
+// operatorRole identifies the current operator and returns their role.
+func (r *rolesFile) operatorRole() (who, role string) {
+	if token := os.Getenv("CLI_TOOLS_TOKEN"); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		hash := hex.EncodeToString(sum[:])
+		for _, op := range r.Operators {
+			if strings.EqualFold(op.Token, hash) {
+				return "token " + hash[:8], op.Role
+			}
+		}
+	}
+	who = "unknown user"
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+		for _, op := range r.Operators {
+			if op.User != "" && op.User == u.Username {
+				return who, op.Role
+			}
+		}
+	}
+	return who, r.Default
+}
+
+// commandPath resolves the leading arguments to command names, following
+// aliases, e.g. "export collections" for export collections --format csv.
+func commandPath(cmds []*cli.Command, args []string) []string {
+	var path []string
+	for _, arg := range args {
+		var found *cli.Command
+		for _, cmd := range cmds {
+			if cmd.HasName(arg) {
+				found = cmd
+				break
+			}
+		}
+		if found == nil {
+			break
+		}
+		path = append(path, found.Name)
+		cmds = found.Subcommands
+	}
+	return path
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) == 0 || len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestCommandPath(t *testing.T) {
+	cmds := []*cli.Command{
+		{Name: "migrate"},
+		{Name: "export", Aliases: []string{"e"}, Subcommands: []*cli.Command{
+			{Name: "collections"},
+			{Name: "schema", Aliases: []string{"s"}},
+		}},
+		{Name: "schema", Subcommands: []*cli.Command{{Name: "status"}}},
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"migrate", "--upsert"}, []string{"migrate"}},
+		{[]string{"export", "collections", "--format", "csv"}, []string{"export", "collections"}},
+		{[]string{"e", "s"}, []string{"export", "schema"}},
+		{[]string{"schema", "status", "extra"}, []string{"schema", "status"}},
+		// Subcommands only resolve under their own parent
+		{[]string{"migrate", "status"}, []string{"migrate"}},
+		{[]string{"--log-level", "debug", "migrate"}, nil},
+		{[]string{"unknown"}, nil},
+	}
+	for _, tt := range tests {
+		if got := commandPath(cmds, tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("commandPath(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestIsPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, path []string
+		want         bool
+	}{
+		{[]string{"export"}, []string{"export", "collections"}, true},
+		{[]string{"schema", "status"}, []string{"schema", "status"}, true},
+		{[]string{"schema", "status"}, []string{"schema", "down"}, false},
+		{[]string{"schema", "status"}, []string{"schema"}, false},
+		{[]string{"export"}, []string{"exports"}, false},
+		// An empty role entry allows nothing
+		{nil, []string{"migrate"}, false},
+		{nil, nil, false},
+	}
+	for _, tt := range tests {
+		if got := isPrefix(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("isPrefix(%q, %q) = %v, want %v", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOperatorRole(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	roles := &rolesFile{
+		Operators: []operator{{Token: hex.EncodeToString(sum[:]), Role: "admin"}},
+		Default:   "viewer",
+	}
+	tests := []struct {
+		token string
+		role  string
+	}{
+		{"secret", "admin"},
+		{"other", "viewer"},
+		{"", "viewer"},
+	}
+	for _, tt := range tests {
+		t.Setenv("CLI_TOOLS_TOKEN", tt.token)
+		if _, role := roles.operatorRole(); role != tt.role {
+			t.Errorf("token %q: role %q, want %q", tt.token, role, tt.role)
+		}
+	}
+}
+
+func TestLoadRolesFromEnvironment(t *testing.T) {
+	if _, err := os.Stat(defaultRolesPath); err == nil {
+		t.Skipf("%s exists and applies instead", defaultRolesPath)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "roles.json")
+	if err := os.WriteFile(path, []byte(`{"roles": {"viewer": ["export"]}, "default": "viewer"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CLI_TOOLS_ROLES", path)
+	roles, got, err := loadRoles()
+	if err != nil || roles == nil || got != path || roles.Default != "viewer" {
+		t.Errorf("loadRoles() = %+v, %q, %v, want the roles in %s", roles, got, err, path)
+	}
+
+	// A missing file fails closed instead of disabling enforcement
+	t.Setenv("CLI_TOOLS_ROLES", filepath.Join(dir, "missing.json"))
+	if roles, _, err := loadRoles(); err == nil {
+		t.Errorf("loadRoles() = %+v with a missing CLI_TOOLS_ROLES file", roles)
+	}
+
+	t.Setenv("CLI_TOOLS_ROLES", "")
+	if roles, _, err := loadRoles(); roles != nil || err != nil {
+		t.Errorf("loadRoles() = %+v, %v without a roles file", roles, err)
+	}
+}
@@ -0,0 +1,169 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// runDirs are the subdirectories every run directory is laid out with.
+var runDirs = []string{"checkpoints", "quarantine", "reports"}
+
+// runLockFile marks a run directory as in use by the process whose ID it holds.
+const runLockFile = "lock"
+
+// runDir holds everything one run leaves behind, under <runs-dir>/<id>:
+// checkpoints/ for resuming, quarantine/ for the dead-letter files and
+// reports/ for the changelog and other reports. Each run gets its own
+// directory, so runs started side by side never write to the same files.
+type runDir struct {
+	ID   string
+	Path string
+}
+
+// newRunDir creates and locks a fresh run directory under root.
+func newRunDir(root string) (*runDir, error) {
+	id := time.Now().UTC().Format("20060102T150405Z")
+	path := filepath.Join(root, id)
+	// Two runs started within the same second get numbered directories
+	for n := 2; ; n++ {
+		err := os.MkdirAll(root, 0o755)
+		if err == nil {
+			err = os.Mkdir(path, 0o755)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("error creating run directory: %v", err)
+		}
+		id = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), n)
+		path = filepath.Join(root, id)
+	}
+	for _, dir := range runDirs {
+		if err := os.Mkdir(filepath.Join(path, dir), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating run directory: %v", err)
+		}
+	}
+	lock, err := os.OpenFile(filepath.Join(path, runLockFile), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error locking run directory: %v", err)
+	}
+	fmt.Fprintln(lock, os.Getpid())
+	lock.Close()
+	logf(levelInfo, "Run %s, artifacts in %s", id, path)
+	return &runDir{ID: id, Path: path}, nil
+}
+
+func (r *runDir) checkpoints() string { return filepath.Join(r.Path, "checkpoints") }
+func (r *runDir) quarantine() string  { return filepath.Join(r.Path, "quarantine") }
+func (r *runDir) reports() string     { return filepath.Join(r.Path, "reports") }
+
+// Close unlocks the run directory so runs prune may remove it.
+func (r *runDir) Close() error {
+	return os.Remove(filepath.Join(r.Path, runLockFile))
+}
+
+var runsCommand = &cli.Command{
+	Name:  "runs",
+	Usage: "Manage the run directories under --runs-dir",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "List runs, oldest first",
+			Flags: runsFlags,
+			Action: func(c *cli.Context) error {
+				runs, err := listRuns(c.String("runs-dir"))
+				if err != nil {
+					return err
+				}
+				for _, id := range runs {
+					state := ""
+					if runLocked(filepath.Join(c.String("runs-dir"), id)) {
+						state = " (running)"
+					}
+					fmt.Println(id + state)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "Remove all but the newest runs",
+			Flags: withFlags(runsFlags, []cli.Flag{
+				&cli.IntFlag{Name: "keep", Value: 10, Usage: "number of runs to keep"},
+			}),
+			Action: func(c *cli.Context) error {
+				return pruneRuns(c.String("runs-dir"), c.Int("keep"))
+			},
+		},
+	},
+}
+
+// listRuns returns the run IDs under root, oldest first. IDs start with their
+// UTC start time, so they sort chronologically.
+func listRuns(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			runs = append(runs, e.Name())
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runOrder(runs[i]) < runOrder(runs[j]) })
+	return runs, nil
+}
+
+// runOrder makes "…Z-10" sort after "…Z-9" and both after the unnumbered run.
+func runOrder(id string) string {
+	base, n, _ := strings.Cut(id, "-")
+	seq, _ := strconv.Atoi(n)
+	return fmt.Sprintf("%s-%06d", base, seq)
+}
+
+// runLocked reports whether the process that locked a run directory is still
+// running. A lock left behind by a process that died is stale.
+func runLocked(path string) bool {
+	pid, ok := readPIDFile(filepath.Join(path, runLockFile))
+	return ok && processAlive(pid)
+}
+
+// pruneRuns removes the oldest runs until keep remain. Runs that are still
+// locked are never removed.
+func pruneRuns(root string, keep int) error {
+	if keep < 0 {
+		return fmt.Errorf("--keep can't be negative")
+	}
+	runs, err := listRuns(root)
+	if err != nil {
+		return err
+	}
+	if len(runs) <= keep {
+		return nil
+	}
+	for _, id := range runs[:len(runs)-keep] {
+		path := filepath.Join(root, id)
+		if runLocked(path) {
+			logf(levelInfo, "Keeping run %s: still locked", id)
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error removing run %s: %v", id, err)
+		}
+		logf(levelInfo, "Removed run %s", id)
+	}
+	return nil
+}
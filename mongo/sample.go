@@ -0,0 +1,77 @@
+package mongo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// samplePrecision is the number of slices the _id hashes are spread over, so
+// samples go down to 0.0001%.
+const samplePrecision = 1000000
+
+// documentSample picks the documents of a trial migration: the ones whose _id
+// hashes into the sampled share of each collection, up to limit of them. The
+// hash depends on the _id alone, so every run picks the same documents. A nil
+// *documentSample keeps every document.
+type documentSample struct {
+	limit int
+	// share is the number of slices out of samplePrecision kept
+	share uint64
+}
+
+// parseSample reads --limit and --sample, a percentage like 1% or 0.5%.
+func parseSample(limit int, sample string) (*documentSample, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("--limit can't be negative")
+	}
+	s := &documentSample{limit: limit, share: samplePrecision}
+	if sample != "" {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(sample), "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid --sample %q, expected a percentage like 1%%", sample)
+		}
+		s.share = uint64(percent / 100 * samplePrecision)
+		if s.share == 0 {
+			return nil, fmt.Errorf("--sample %s is below %g%%", sample, 100.0/samplePrecision)
+		}
+	}
+	if s.limit == 0 && s.share == samplePrecision {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// keeps reports whether the document behind cursor is in the sample.
+func (s *documentSample) keeps(cursor documentCursor) (bool, error) {
+	if s == nil || s.share == samplePrecision {
+		return true, nil
+	}
+	var doc struct {
+		ID mixedID `bson:"_id" json:"_id"`
+	}
+	if err := cursor.Decode(&doc); err != nil {
+		return false, fmt.Errorf("error decoding _id: %v", err)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(doc.ID))
+	return h.Sum64()%samplePrecision < s.share, nil
+}
+
+// full reports whether a collection that got through taken sampled documents
+// has reached the limit.
+func (s *documentSample) full(taken int) bool {
+	return s != nil && s.limit > 0 && taken >= s.limit
+}
+
+func (s *documentSample) String() string {
+	var parts []string
+	if s.share < samplePrecision {
+		parts = append(parts, fmt.Sprintf("%g%% of each collection", float64(s.share)*100/samplePrecision))
+	}
+	if s.limit > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d document(s) per collection", s.limit))
+	}
+	return strings.Join(parts, ", ")
+}
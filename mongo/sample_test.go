@@ -0,0 +1,126 @@
+package mongo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		limit   int
+		sample  string
+		want    *documentSample
+		wantErr string
+	}{
+		{limit: 0, sample: "", want: nil},
+		{limit: 0, sample: "100%", want: nil},
+		{limit: 10, sample: "", want: &documentSample{limit: 10, share: samplePrecision}},
+		{limit: 0, sample: "1%", want: &documentSample{share: 10000}},
+		{limit: 0, sample: " 0.5% ", want: &documentSample{share: 5000}},
+		{limit: 5, sample: "25", want: &documentSample{limit: 5, share: 250000}},
+		{limit: -1, wantErr: "--limit can't be negative"},
+		{sample: "abc", wantErr: "invalid --sample"},
+		{sample: "0%", wantErr: "invalid --sample"},
+		{sample: "101%", wantErr: "invalid --sample"},
+		{sample: "0.00001%", wantErr: "is below"},
+	}
+	for _, tt := range tests {
+		got, err := parseSample(tt.limit, tt.sample)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseSample(%d, %q) error = %v, want one containing %q", tt.limit, tt.sample, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSample(%d, %q): %v", tt.limit, tt.sample, err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("parseSample(%d, %q) = %+v, want %+v", tt.limit, tt.sample, got, tt.want)
+		}
+	}
+}
+
+func sampleDocument(t *testing.T, id interface{}) documentCursor {
+	t.Helper()
+	data, err := bson.Marshal(bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &document{data: data, unmarshal: bson.Unmarshal, bson: true}
+}
+
+func TestSampleKeeps(t *testing.T) {
+	tests := []struct {
+		sample   string
+		min, max int
+	}{
+		{"100%", 1000, 1000},
+		{"50%", 400, 600},
+		{"10%", 50, 150},
+	}
+	for _, tt := range tests {
+		s, err := parseSample(0, tt.sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			doc := sampleDocument(t, fmt.Sprintf("user-%d", i))
+			keep, err := s.keeps(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The choice depends on the _id alone
+			again, _ := s.keeps(doc)
+			if keep != again {
+				t.Fatalf("%s: user-%d kept once but not twice", tt.sample, i)
+			}
+			if keep {
+				kept++
+			}
+		}
+		if kept < tt.min || kept > tt.max {
+			t.Errorf("%s kept %d of 1000 documents, want %d-%d", tt.sample, kept, tt.min, tt.max)
+		}
+	}
+}
+
+func TestSampleKeepsSameIDAcrossTypes(t *testing.T) {
+	s := &documentSample{share: samplePrecision / 2}
+	for i := int32(0); i < 100; i++ {
+		asInt, err := s.keeps(sampleDocument(t, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		asString, err := s.keeps(sampleDocument(t, fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asInt != asString {
+			t.Errorf("_id %d and %q sampled differently", i, fmt.Sprint(i))
+		}
+	}
+}
+
+func TestSampleFull(t *testing.T) {
+	tests := []struct {
+		s     *documentSample
+		taken int
+		want  bool
+	}{
+		{nil, 1000, false},
+		{&documentSample{share: samplePrecision / 10}, 1000, false},
+		{&documentSample{limit: 10}, 9, false},
+		{&documentSample{limit: 10}, 10, true},
+	}
+	for _, tt := range tests {
+		if got := tt.s.full(tt.taken); got != tt.want {
+			t.Errorf("%+v full(%d) = %v, want %v", tt.s, tt.taken, got, tt.want)
+		}
+	}
+}
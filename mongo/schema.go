@@ -0,0 +1,309 @@
+package mongo
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Schema migrations live in migrations/ as NNNN_name.up.sql and
+// NNNN_name.down.sql pairs. Applied versions are recorded in schema_migrations.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var schemaCommand = &cli.Command{
+	Name:  "schema",
+	Usage: "Manage the versioned MySQL schema",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "up",
+			Usage: "Apply pending schema migrations",
+			Flags: withFlags(mysqlFlags, []cli.Flag{
+				&cli.IntFlag{Name: "to", Usage: "stop after applying this version (default: latest)"},
+			}),
+			Action: func(c *cli.Context) error {
+				mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+				defer mysqlDB.Close()
+				return schemaUp(mysqlDB, c.Int("to"), nil)
+			},
+		},
+		{
+			Name:  "down",
+			Usage: "Roll back applied schema migrations",
+			Flags: withFlags(mysqlFlags, []cli.Flag{
+				&cli.IntFlag{Name: "steps", Value: 1, Usage: "number of migrations to roll back"},
+			}),
+			Action: func(c *cli.Context) error {
+				mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+				defer mysqlDB.Close()
+				return schemaDown(mysqlDB, c.Int("steps"))
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "List schema migrations and whether they are applied",
+			Flags: mysqlFlags,
+			Action: func(c *cli.Context) error {
+				mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+				defer mysqlDB.Close()
+				return schemaStatus(mysqlDB)
+			},
+		},
+	},
+}
+
+// schemaMigration is one versioned up/down pair.
+type schemaMigration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadSchemaMigrations reads the embedded migrations, sorted by version.
+func loadSchemaMigrations() ([]schemaMigration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*schemaMigration{}
+	for _, entry := range entries {
+		file := entry.Name()
+		base := strings.TrimSuffix(file, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("badly named migration %s", file)
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &schemaMigration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == ".up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]schemaMigration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedVersions returns the versions recorded in schema_migrations, creating the table if needed.
+func appliedVersions(mysqlDB *sql.DB) (map[int]time.Time, error) {
+	_, err := mysqlDB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at DATETIME NOT NULL
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	rows, err := mysqlDB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		// The driver hands DATETIME back as text unless the DSN sets parseTime
+		t, err := time.Parse("2006-01-02 15:04:05", appliedAt)
+		if err != nil {
+			t, _ = time.Parse(time.RFC3339Nano, appliedAt)
+		}
+		applied[version] = t
+	}
+	return applied, rows.Err()
+}
+
+// schemaUp applies every pending migration up to and including version to (0 for all).
+func schemaUp(mysqlDB *sql.DB, to int, changes *schemaChangelog) error {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(mysqlDB)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if to > 0 && m.Version > to {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		logf(levelInfo, "Applying schema migration %04d_%s", m.Version, m.Name)
+		if err := execStatements(mysqlDB, m.Up); err != nil {
+			return fmt.Errorf("error applying migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		_, err := mysqlDB.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("error recording migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		changes.changed("applied schema migration %04d_%s", m.Version, m.Name)
+		pending++
+	}
+	if pending == 0 {
+		logf(levelInfo, "Schema is up to date")
+	}
+	return nil
+}
+
+// schemaDown rolls back the latest steps applied migrations, passing over
+// those named in keep.
+func schemaDown(mysqlDB *sql.DB, steps int, keep ...string) error {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(mysqlDB)
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	for _, name := range keep {
+		kept[name] = true
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok || kept[m.Name] {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %04d_%s has no down migration", m.Version, m.Name)
+		}
+		logf(levelInfo, "Rolling back schema migration %04d_%s", m.Version, m.Name)
+		if err := execStatements(mysqlDB, m.Down); err != nil {
+			return fmt.Errorf("error rolling back migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		if _, err := mysqlDB.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+			return fmt.Errorf("error unrecording migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// idMapMigration is the schema migration creating id_map, which a reset
+// keeps unless told otherwise: dropping it would give every document a new
+// UUID on the next --uuid-ids run and break the references to the old ones.
+const idMapMigration = "id_map"
+
+// resetTarget drops the tables a migration of selected fills, so the run
+// recreates them from scratch: every table of the versioned schema, by
+// rolling it all back, and the tables of the selected createTable mappings.
+// id_map stays unless resetIDMap is set. The foreign keys --foreign-keys
+// adds go first, since they would keep the tables they reference from being
+// dropped.
+func resetTarget(mysqlDB *sql.DB, cfg *config, selected []collectionMigration, resetIDMap bool, changes *schemaChangelog) error {
+	for _, fk := range foreignKeys {
+		var exists int
+		err := mysqlDB.QueryRow(
+			"SELECT count(*) FROM information_schema.TABLE_CONSTRAINTS WHERE CONSTRAINT_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = ?",
+			fk.Table, fk.Name,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error looking up constraint %s: %v", fk.Name, err)
+		}
+		if exists == 0 {
+			continue
+		}
+		if _, err := mysqlDB.Exec(fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s", fk.Table, fk.Name)); err != nil {
+			return fmt.Errorf("error dropping foreign key %s: %v", fk, err)
+		}
+		changes.changed("dropped foreign key %s (%s) for --reset-target", fk.Name, fk)
+	}
+
+	applied, err := appliedVersions(mysqlDB)
+	if err != nil {
+		return err
+	}
+	var keep []string
+	if !resetIDMap {
+		keep = append(keep, idMapMigration)
+	}
+	if err := schemaDown(mysqlDB, len(applied), keep...); err != nil {
+		return err
+	}
+	left, err := appliedVersions(mysqlDB)
+	if err != nil {
+		return err
+	}
+	changes.changed("rolled back %d schema migration(s) for --reset-target", len(applied)-len(left))
+
+	for _, mapping := range cfg.Mappings {
+		if !mapping.CreateTable || !knownCollection(selected, mapping.Collection) {
+			continue
+		}
+		logf(levelInfo, "Dropping table %s of mapped collection %s", mapping.Table, mapping.Collection)
+		if _, err := mysqlDB.Exec("DROP TABLE IF EXISTS " + mapping.Table); err != nil {
+			return fmt.Errorf("error dropping table %s: %v", mapping.Table, err)
+		}
+		changes.changed("dropped table %s of mapped collection %s for --reset-target", mapping.Table, mapping.Collection)
+	}
+	return nil
+}
+
+func schemaStatus(mysqlDB *sql.DB) error {
+	migrations, err := loadSchemaMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(mysqlDB)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		state := "pending"
+		if at, ok := applied[m.Version]; ok {
+			state = "applied " + at.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d_%-30s %s\n", m.Version, m.Name, state)
+	}
+	return nil
+}
+
+// execStatements runs each ;-terminated statement of a migration file in turn,
+// since the MySQL driver only accepts one statement per Exec by default.
+func execStatements(mysqlDB *sql.DB, script string) error {
+	for _, stmt := range strings.Split(script, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := mysqlDB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package mongo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ipCandidates finds strings that may be IPv4 or IPv6 addresses; each match
+// is confirmed with net.ParseIP before it counts.
+var ipCandidates = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`)
+
+// ipPolicies are what --scrub-ips may do with an address found in a text column.
+var ipPolicies = map[string]func(key []byte, ip net.IP) string{
+	// report leaves the address in place and only records the finding
+	"report": nil,
+	// hash is keyed: there are few enough IPv4 addresses to reverse a plain hash
+	"hash": func(key []byte, ip net.IP) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(ip.String()))
+		return "ip:" + hex.EncodeToString(mac.Sum(nil)[:8])
+	},
+	"truncate": func(key []byte, ip net.IP) string {
+		if v4 := ip.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	},
+	"drop": func(key []byte, ip net.IP) string {
+		return ""
+	},
+}
+
+// ipScrubber looks for IP addresses in every text value about to be written
+// and applies the chosen policy to them. Each affected row is listed in a
+// findings file, with the scrubbed rather than the original value, for the
+// privacy review. A nil *ipScrubber scrubs nothing.
+type ipScrubber struct {
+	policy   string
+	key      []byte
+	path     string
+	findings *os.File
+	enc      *json.Encoder
+	counts   map[string]int
+}
+
+// ipFinding is one line of the findings file.
+type ipFinding struct {
+	Table   string `json:"table"`
+	Column  string `json:"column"`
+	Row     string `json:"row"`
+	Matches int    `json:"matches"`
+	Policy  string `json:"policy"`
+}
+
+func newIPScrubber(policy, path string) (*ipScrubber, error) {
+	if _, ok := ipPolicies[policy]; !ok {
+		return nil, fmt.Errorf("unknown --scrub-ips %q, expected report, hash, truncate or drop", policy)
+	}
+	key := os.Getenv("IP_HASH_KEY")
+	if policy == "hash" && key == "" {
+		return nil, fmt.Errorf("--scrub-ips hash needs IP_HASH_KEY")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating IP findings file: %v", err)
+	}
+	return &ipScrubber{policy: policy, key: []byte(key), path: path, findings: f, enc: json.NewEncoder(f), counts: map[string]int{}}, nil
+}
+
+// scrub applies the policy to the string values of rows in place, recording
+// the findings in l.
+func (s *ipScrubber) scrub(rows []tableRow, l *ledger) error {
+	if s == nil {
+		return nil
+	}
+	replace := ipPolicies[s.policy]
+	for _, row := range rows {
+		for i, col := range row.Columns {
+			text, ok := row.Values[i].(string)
+			if !ok || text == "" {
+				continue
+			}
+			matches := 0
+			scrubbed := ipCandidates.ReplaceAllStringFunc(text, func(candidate string) string {
+				ip := net.ParseIP(candidate)
+				if ip == nil {
+					return candidate
+				}
+				matches++
+				if replace == nil {
+					return candidate
+				}
+				return replace(s.key, ip)
+			})
+			if matches == 0 {
+				continue
+			}
+			row.Values[i] = scrubbed
+			finding := ipFinding{Table: row.Table, Column: col, Row: rowKey(row), Matches: matches, Policy: s.policy}
+			l.add(func() error {
+				s.counts[finding.Table+"."+finding.Column] += finding.Matches
+				if err := s.enc.Encode(finding); err != nil {
+					return fmt.Errorf("error writing %s: %v", s.path, err)
+				}
+				return nil
+			})
+		}
+	}
+	return nil
+}
+
+// rowKey identifies a row in the findings by its first column, the key of
+// every built-in table.
+func rowKey(row tableRow) string {
+	if len(row.Values) == 0 {
+		return ""
+	}
+	return fmt.Sprint(row.Values[0])
+}
+
+// summary logs how many addresses were found per column.
+func (s *ipScrubber) summary() {
+	if s == nil || len(s.counts) == 0 {
+		return
+	}
+	columns := make([]string, 0, len(s.counts))
+	for col := range s.counts {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	parts := make([]string, len(columns))
+	for i, col := range columns {
+		parts[i] = fmt.Sprintf("%s (%d)", col, s.counts[col])
+	}
+	logf(levelInfo, "IP addresses found (%s): %s, see %s", s.policy, strings.Join(parts, ", "), s.path)
+}
+
+func (s *ipScrubber) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.findings.Close()
+}
@@ -0,0 +1,76 @@
+package mongo
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIPScrubber(t *testing.T) {
+	t.Setenv("IP_HASH_KEY", "test-key")
+	const text = "login from 203.0.113.7 and 2001:db8::1, not 999.1.1.1 or 1.2.3"
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"report", text},
+		{"truncate", "login from 203.0.113.0 and 2001:db8::, not 999.1.1.1 or 1.2.3"},
+		{"drop", "login from  and , not 999.1.1.1 or 1.2.3"},
+	}
+	for _, tt := range tests {
+		s, err := newIPScrubber(tt.policy, filepath.Join(t.TempDir(), "findings.ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := []tableRow{{Table: "posts", Columns: []string{"id", "content", "views"}, Values: []interface{}{"p1", text, int64(3)}}}
+		if err := s.scrub(rows, nil); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		if got := rows[0].Values[1]; got != tt.want {
+			t.Errorf("%s: content = %q, want %q", tt.policy, got, tt.want)
+		}
+		if got := s.counts["posts.content"]; got != 2 {
+			t.Errorf("%s: %d address(es) counted, want 2", tt.policy, got)
+		}
+		findings := readReport[ipFinding](t, s.path)
+		if len(findings) != 1 || findings[0] != (ipFinding{Table: "posts", Column: "content", Row: "p1", Matches: 2, Policy: tt.policy}) {
+			t.Errorf("%s: findings = %+v", tt.policy, findings)
+		}
+	}
+}
+
+func TestIPScrubberHash(t *testing.T) {
+	hashed := func(key string) string {
+		t.Setenv("IP_HASH_KEY", key)
+		s, err := newIPScrubber("hash", filepath.Join(t.TempDir(), "findings.ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		rows := []tableRow{{Table: "users", Columns: []string{"id", "bio"}, Values: []interface{}{"u1", "at 198.51.100.2"}}}
+		if err := s.scrub(rows, nil); err != nil {
+			t.Fatal(err)
+		}
+		return rows[0].Values[1].(string)
+	}
+	a, b, other := hashed("k1"), hashed("k1"), hashed("k2")
+	if !strings.HasPrefix(a, "at ip:") || len(a) != len("at ip:")+16 {
+		t.Errorf("hashed address %q isn't ip: and 16 hex digits", a)
+	}
+	if a != b {
+		t.Errorf("the same key hashed the address to %q and %q", a, b)
+	}
+	if a == other {
+		t.Errorf("different keys hashed the address to the same %q", a)
+	}
+}
+
+func TestNewIPScrubberErrors(t *testing.T) {
+	t.Setenv("IP_HASH_KEY", "")
+	for _, policy := range []string{"mask", "hash"} {
+		if _, err := newIPScrubber(policy, filepath.Join(t.TempDir(), "findings.ndjson")); err == nil {
+			t.Errorf("newIPScrubber(%q) succeeded", policy)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// secretTimeout bounds reading one secret, including fetching the credentials.
+const secretTimeout = 30 * time.Second
+
+// secretStore reads the connection strings and tokens the flags take from
+// their environment variables out of a secrets manager, so no .env file with
+// them has to sit on the machine running the tool.
+type secretStore struct {
+	Backend string            `json:"backend" doc:"aws, gcp, vault or doppler"`
+	Env     map[string]string `json:"env" doc:"environment variables and the secret each is read from, e.g. MYSQL_URI: prod/cli-tools#mysqlURI; #key picks a key of a JSON secret"`
+	Region  string            `json:"region" default:"AWS_REGION" doc:"AWS region of the secrets"`
+	Project string            `json:"project" doc:"GCP project of secrets not named projects/..., or the Doppler project"`
+	Address string            `json:"address" default:"VAULT_ADDR" doc:"Vault server URL"`
+	Config  string            `json:"config" doc:"Doppler config, e.g. prd; a service token needs neither project nor config"`
+}
+
+// envVarName matches the names secrets can be stored in.
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *secretStore) validate() error {
+	switch s.Backend {
+	case "aws", "gcp", "vault", "doppler":
+	default:
+		return fmt.Errorf("secrets: unknown backend %q, expected aws, gcp, vault or doppler", s.Backend)
+	}
+	for env, ref := range s.Env {
+		if !envVarName.MatchString(env) {
+			return fmt.Errorf("secrets: invalid environment variable name %q", env)
+		}
+		name, key, _ := strings.Cut(ref, "#")
+		if name == "" {
+			return fmt.Errorf("secrets: %s: no secret named", env)
+		}
+		if s.Backend == "vault" && key == "" {
+			return fmt.Errorf("secrets: %s: a Vault secret needs the key read from it, like %s#%s", env, name, env)
+		}
+	}
+	return nil
+}
+
+// applySecrets reads the secrets of the environment variables the command
+// about to run reads, into the environment. Variables already set, by the
+// environment or .env, are left alone, and --profile still overrides them.
+func applySecrets(c *cli.Context) error {
+	cfg, err := loadConfig(c.String("config"))
+	if err != nil || cfg.Secrets == nil {
+		return err
+	}
+	read := commandEnvVars(c)
+	var names []string
+	for env := range cfg.Secrets.Env {
+		if _, set := os.LookupEnv(env); read[env] && !set {
+			names = append(names, env)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	backend, err := newSecretBackend(cfg.Secrets)
+	if err != nil {
+		return err
+	}
+	for _, env := range names {
+		ref := cfg.Secrets.Env[env]
+		ctx, cancel := context.WithTimeout(c.Context, secretTimeout)
+		value, err := readSecret(ctx, backend, ref)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error reading %s from %s secret %s: %v", env, cfg.Secrets.Backend, ref, err)
+		}
+		os.Setenv(env, value)
+		logf(levelDebug, "Read %s from %s secret %s", env, cfg.Secrets.Backend, ref)
+	}
+	return nil
+}
+
+// commandEnvVars returns the environment variables the flags of the command
+// c is about to run read.
+func commandEnvVars(c *cli.Context) map[string]bool {
+	flags := c.App.Flags
+	cmds := c.App.Commands
+	for _, name := range commandPath(c.App.Commands, c.Args().Slice()) {
+		for _, cmd := range cmds {
+			if cmd.Name == name {
+				flags, cmds = cmd.Flags, cmd.Subcommands
+				break
+			}
+		}
+	}
+	vars := map[string]bool{}
+	for _, f := range flags {
+		if f, ok := f.(cli.DocGenerationFlag); ok {
+			for _, env := range f.GetEnvVars() {
+				vars[env] = true
+			}
+		}
+	}
+	return vars
+}
+
+// secretBackend reads the value of one secret by name.
+type secretBackend interface {
+	secret(ctx context.Context, name string) (string, error)
+}
+
+func newSecretBackend(s *secretStore) (secretBackend, error) {
+	client := &http.Client{Timeout: secretTimeout}
+	switch s.Backend {
+	case "aws":
+		region := s.Region
+		for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+			if region == "" {
+				region = os.Getenv(env)
+			}
+		}
+		if region == "" {
+			return nil, fmt.Errorf("secrets: the aws backend needs a region, or AWS_REGION")
+		}
+		return &awsSecrets{client: client, region: region}, nil
+	case "gcp":
+		return &gcpSecrets{client: client, project: s.Project}, nil
+	case "vault":
+		address := s.Address
+		if address == "" {
+			address = os.Getenv("VAULT_ADDR")
+		}
+		if address == "" {
+			return nil, fmt.Errorf("secrets: the vault backend needs an address, or VAULT_ADDR")
+		}
+		return &vaultSecrets{client: client, address: strings.TrimSuffix(address, "/")}, nil
+	default:
+		return &dopplerSecrets{client: client, project: s.Project, config: s.Config}, nil
+	}
+}
+
+// readSecret reads the secret ref names, picking its key from the JSON object
+// it holds when ref ends in #key.
+func readSecret(ctx context.Context, backend secretBackend, ref string) (string, error) {
+	name, key, ok := strings.Cut(ref, "#")
+	value, err := backend.secret(ctx, name)
+	if err != nil || !ok {
+		return value, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("the secret isn't a JSON object to read %s from", key)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("the secret has no key %s", key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(field), nil
+}
+
+// secretRequest sends req and decodes its JSON response into v.
+func secretRequest(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Error responses don't echo secret values, so a bit of them helps
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// awsSecrets reads AWS Secrets Manager, with the credentials of the
+// environment or else of the EC2 instance's role.
+type awsSecrets struct {
+	client *http.Client
+	region string
+	creds  *awsCredentials
+}
+
+func (a *awsSecrets) secret(ctx context.Context, name string) (string, error) {
+	if a.creds == nil {
+		creds, err := awsEnvironmentCredentials(ctx, a.client)
+		if err != nil {
+			return "", err
+		}
+		a.creds = creds
+	}
+	body, _ := json.Marshal(map[string]string{"SecretId": name})
+	endpoint := "https://secretsmanager." + a.region + ".amazonaws.com/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sum := sha256.Sum256(body)
+	signAWS(req, "/", hex.EncodeToString(sum[:]), "secretsmanager", a.region, *a.creds, time.Now().UTC())
+	var out struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+	}
+	if err := secretRequest(a.client, req, &out); err != nil {
+		return "", err
+	}
+	if out.SecretString == "" && out.SecretBinary != nil {
+		return string(out.SecretBinary), nil
+	}
+	return out.SecretString, nil
+}
+
+// awsEnvironmentCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, or asks the EC2 instance metadata service (IMDSv2) for
+// the credentials of the instance's role when they aren't set.
+func awsEnvironmentCredentials(ctx context.Context, client *http.Client) (*awsCredentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return &awsCredentials{AccessKey: key, SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	token, err := metadataText(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS_ACCESS_KEY_ID and no instance role (%v)", err)
+	}
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imds+"/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return nil, err
+	}
+	role, err := metadataText(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS_ACCESS_KEY_ID and no instance role (%v)", err)
+	}
+	if req, err = get(strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])); err != nil {
+		return nil, err
+	}
+	var out struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := secretRequest(client, req, &out); err != nil {
+		return nil, fmt.Errorf("error reading the instance role's credentials: %v", err)
+	}
+	return &awsCredentials{AccessKey: out.AccessKeyID, SecretKey: out.SecretAccessKey, SessionToken: out.Token}, nil
+}
+
+// metadataText sends a request to a cloud metadata service and returns the
+// text it answers with.
+func metadataText(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return string(data), err
+}
+
+// gcpSecrets reads GCP Secret Manager, with the token in
+// GOOGLE_OAUTH_ACCESS_TOKEN or else the VM's service account.
+type gcpSecrets struct {
+	client  *http.Client
+	project string
+	token   string
+}
+
+func (g *gcpSecrets) secret(ctx context.Context, name string) (string, error) {
+	if !strings.HasPrefix(name, "projects/") {
+		if g.project == "" {
+			return "", fmt.Errorf("the secrets config needs a project for secrets not named projects/<project>/secrets/<name>")
+		}
+		name = "projects/" + g.project + "/secrets/" + name
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	if g.token == "" {
+		token, err := gcpAccessToken(ctx, g.client)
+		if err != nil {
+			return "", err
+		}
+		g.token = token
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	var out struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := secretRequest(g.client, req, &out); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(out.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %v", err)
+	}
+	return string(data), nil
+}
+
+func gcpAccessToken(ctx context.Context, client *http.Client) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var out struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := secretRequest(client, req, &out); err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN and no VM service account (%v)", err)
+	}
+	return out.AccessToken, nil
+}
+
+// vaultSecrets reads HashiCorp Vault with VAULT_TOKEN, or the token vault
+// login saved in ~/.vault-token. Secrets are paths like secret/data/cli-tools
+// for the KV version 2 engine, or secret/cli-tools for version 1.
+type vaultSecrets struct {
+	client  *http.Client
+	address string
+}
+
+func (v *vaultSecrets) secret(ctx context.Context, name string) (string, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("no VAULT_TOKEN and no ~/.vault-token")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+strings.TrimPrefix(name, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	var out struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := secretRequest(v.client, req, &out); err != nil {
+		return "", err
+	}
+	// KV version 2 nests the secret's keys next to its metadata
+	data := out.Data
+	if nested, ok := data["data"]; ok && data["metadata"] != nil {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return "", fmt.Errorf("invalid secret: %v", err)
+		}
+	}
+	value, err := json.Marshal(data)
+	return string(value), err
+}
+
+// dopplerSecrets reads a Doppler config with DOPPLER_TOKEN, all secrets at once.
+type dopplerSecrets struct {
+	client  *http.Client
+	project string
+	config  string
+	secrets map[string]string
+}
+
+func (d *dopplerSecrets) secret(ctx context.Context, name string) (string, error) {
+	if d.secrets == nil {
+		token := os.Getenv("DOPPLER_TOKEN")
+		if token == "" {
+			return "", fmt.Errorf("no DOPPLER_TOKEN")
+		}
+		query := url.Values{"format": {"json"}}
+		if d.project != "" {
+			query.Set("project", d.project)
+		}
+		if d.config != "" {
+			query.Set("config", d.config)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.doppler.com/v3/configs/config/secrets/download?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if err := secretRequest(d.client, req, &d.secrets); err != nil {
+			return "", err
+		}
+	}
+	value, ok := d.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("the Doppler config has no secret %s", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,512 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// seedBatch is how many documents are inserted into MongoDB at once, and
+// written to MySQL in one transaction.
+const seedBatch = 1000
+
+// seedPassword is the password of every seeded account.
+const seedPassword = "seeded-password"
+
+var seedCommand = &cli.Command{
+	Name:  "seed",
+	Usage: "Fill MongoDB or MySQL with realistic fake users, posts, comments, hearts, coteries, partners and blog posts, for load tests",
+	Flags: withFlags(mongoFlags, mysqlFlags, []cli.Flag{
+		&cli.StringFlag{Name: "target", Value: "mongo", Usage: "database seeded: mongo, in SocialFlux's document shapes, or mysql, in the migrated schema"},
+		&cli.IntFlag{Name: "users", Value: 100, Usage: "users generated"},
+		&cli.IntFlag{Name: "posts", Value: 500, Usage: "posts generated, by random users"},
+		&cli.Float64Flag{Name: "comments", Value: 3, Usage: "average comments per post, about a third of them replies"},
+		&cli.Float64Flag{Name: "hearts", Value: 5, Usage: "average hearts per post, from distinct users"},
+		&cli.IntFlag{Name: "coteries", Value: 10, Usage: "coteries generated (MongoDB only, they aren't migrated)"},
+		&cli.Float64Flag{Name: "coterie-members", Value: 20, Usage: "average members per coterie"},
+		&cli.IntFlag{Name: "partners", Value: 5, Usage: "partners generated"},
+		&cli.IntFlag{Name: "blogs", Value: 10, Usage: "blog posts generated"},
+		&cli.Int64Flag{Name: "seed", Value: 1, Usage: "seed of the random generator; the same seed and counts generate the same data"},
+		&cli.BoolFlag{Name: "append", Usage: "add to collections or tables that already hold data, instead of refusing to"},
+	}),
+	Action: seed,
+}
+
+// seedCollections are the collections seed fills, in the order it does.
+var seedCollections = []string{"users", "posts", "coteries", "partners", "blogs"}
+
+func seed(c *cli.Context) error {
+	for _, flag := range []string{"users", "posts", "coteries", "partners", "blogs"} {
+		if c.Int(flag) < 0 {
+			return fmt.Errorf("--%s can't be negative", flag)
+		}
+	}
+	for _, flag := range []string{"comments", "hearts", "coterie-members"} {
+		if c.Float64(flag) < 0 {
+			return fmt.Errorf("--%s can't be negative", flag)
+		}
+	}
+	if c.Int("posts") > 0 && c.Int("users") == 0 {
+		return fmt.Errorf("--posts need --users to write them")
+	}
+	if c.Int("coteries") > 0 && c.Int("users") == 0 {
+		return fmt.Errorf("--coteries need --users to own them")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(seedPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	g := &seedGenerator{random: rand.New(rand.NewSource(c.Int64("seed"))), now: time.Now().UTC(), password: string(hash)}
+	docs := g.generate(seedCounts{
+		users:          c.Int("users"),
+		posts:          c.Int("posts"),
+		comments:       c.Float64("comments"),
+		hearts:         c.Float64("hearts"),
+		coteries:       c.Int("coteries"),
+		coterieMembers: c.Float64("coterie-members"),
+		partners:       c.Int("partners"),
+		blogs:          c.Int("blogs"),
+	})
+
+	switch c.String("target") {
+	case "mongo":
+		err = seedMongo(c.Context, c.String("mongodb-uri"), docs, c.Bool("append"))
+	case "mysql":
+		err = seedMySQL(c, docs)
+	default:
+		return fmt.Errorf("unknown --target %q, expected mongo or mysql", c.String("target"))
+	}
+	if err != nil {
+		return err
+	}
+	logf(levelInfo, "Seeded accounts log in with the password %q", seedPassword)
+	return nil
+}
+
+// seedMongo inserts the documents into the SocialFlux database, refusing
+// collections that already hold some unless appending.
+func seedMongo(ctx context.Context, uri string, docs map[string][]bson.D, appending bool) error {
+	source, err := newMongoSource(ctx, uri, nil)
+	if err != nil {
+		return err
+	}
+	defer source.Close(context.TODO())
+	if !appending {
+		for _, name := range seedCollections {
+			if len(docs[name]) == 0 {
+				continue
+			}
+			n, err := source.db.Collection(name).CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+			if err != nil {
+				return fmt.Errorf("error counting %s: %v", name, err)
+			}
+			if n > 0 {
+				return fmt.Errorf("%s already holds documents; pass --append to seed it anyway", name)
+			}
+		}
+	}
+	for _, name := range seedCollections {
+		all := docs[name]
+		for len(all) > 0 {
+			batch := all[:min(seedBatch, len(all))]
+			all = all[len(batch):]
+			many := make([]interface{}, len(batch))
+			for i, doc := range batch {
+				many[i] = doc
+			}
+			if _, err := source.db.Collection(name).InsertMany(ctx, many); err != nil {
+				return fmt.Errorf("error seeding %s: %v", name, err)
+			}
+		}
+		if len(docs[name]) > 0 {
+			logf(levelInfo, "Seeded %d %s document(s) into MongoDB", len(docs[name]), name)
+		}
+	}
+	return nil
+}
+
+// seedMySQL turns the documents into rows with the transfer functions of
+// migrate, comments, hearts and links normalized, and writes them to the
+// target schema.
+func seedMySQL(c *cli.Context, docs map[string][]bson.D) error {
+	mysqlDB, err := openMySQL(c.String("mysql-uri"), nil)
+	if err != nil {
+		return err
+	}
+	defer mysqlDB.Close()
+	target := &mysqlTarget{db: mysqlDB}
+	if err := target.CreateSchema(nil); err != nil {
+		return err
+	}
+	if !c.Bool("append") {
+		for _, table := range []string{"users", "posts", "comments", "post_heart", "user_links", "partners", "blogs", "blog_entries"} {
+			var one int
+			err := mysqlDB.QueryRow("SELECT 1 FROM " + table + " LIMIT 1").Scan(&one)
+			if err == nil {
+				return fmt.Errorf("table %s already holds rows; pass --append to seed it anyway", table)
+			}
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("error checking table %s: %v", table, err)
+			}
+		}
+	}
+	if len(docs["coteries"]) > 0 {
+		logf(levelWarn, "Not seeding coteries into MySQL, they aren't migrated")
+	}
+	m := &migrator{mysqlDB: mysqlDB, target: target, comments: true, hearts: true, links: true}
+	for _, cm := range collectionMigrations {
+		all := docs[cm.Name]
+		for len(all) > 0 {
+			batch := all[:min(seedBatch, len(all))]
+			all = all[len(batch):]
+			if err := seedRows(c.Context, m, cm, batch); err != nil {
+				return fmt.Errorf("error seeding %s: %v", cm.Name, err)
+			}
+		}
+		if len(docs[cm.Name]) > 0 {
+			logf(levelInfo, "Seeded %d %s document(s) into MySQL", len(docs[cm.Name]), cm.Name)
+		}
+	}
+	for _, table := range []string{"users", "posts", "comments", "post_heart", "user_links", "partners", "blogs", "blog_entries"} {
+		if n := m.rows[table]; n > 0 {
+			logf(levelInfo, "%s: %d row(s)", table, n)
+		}
+	}
+	return nil
+}
+
+// seedRows writes the rows of a batch of documents in one transaction.
+func seedRows(ctx context.Context, m *migrator, cm collectionMigration, batch []bson.D) error {
+	tx, err := m.mysqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	m.tx = tx
+	defer func() { m.tx = nil }()
+	for _, doc := range batch {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		rows, err := cm.Rows(m, &document{data: data, unmarshal: bson.Unmarshal, bson: true})
+		if err == nil {
+			err = m.insertRows(ctx, rows)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// seedGenerator makes up the documents. Everything random comes from random,
+// so a seed always generates the same data, dated back from now.
+type seedGenerator struct {
+	random   *rand.Rand
+	now      time.Time
+	password string
+	// users are the generated accounts posts, comments, hearts and
+	// coteries refer to
+	users []seedUser
+}
+
+type seedUser struct {
+	id       string
+	username string
+	created  time.Time
+	flags    bson.D
+}
+
+// seedCounts are how many documents of each kind are generated, and the
+// averages of those embedded in them.
+type seedCounts struct {
+	users, posts, coteries, partners, blogs int
+	comments, hearts, coterieMembers        float64
+}
+
+// generate makes up the documents of every collection counts asks for.
+func (g *seedGenerator) generate(counts seedCounts) map[string][]bson.D {
+	docs := map[string][]bson.D{}
+	for i := 0; i < counts.users; i++ {
+		docs["users"] = append(docs["users"], g.user(i))
+	}
+	for i := 0; i < counts.posts; i++ {
+		docs["posts"] = append(docs["posts"], g.post(counts.comments, counts.hearts))
+	}
+	for i := 0; i < counts.coteries; i++ {
+		docs["coteries"] = append(docs["coteries"], g.coterie(counts.coterieMembers))
+	}
+	titles := map[string]bool{}
+	for i := 0; i < counts.partners; i++ {
+		docs["partners"] = append(docs["partners"], g.partner(titles))
+	}
+	slugs := map[string]bool{}
+	for i := 0; i < counts.blogs; i++ {
+		docs["blogs"] = append(docs["blogs"], g.blog(slugs))
+	}
+	return docs
+}
+
+func (g *seedGenerator) user(i int) bson.D {
+	first, last := g.pick(seedFirstNames), g.pick(seedLastNames)
+	// The index keeps usernames unique
+	username := fmt.Sprintf("%s%s%d", strings.ToLower(first), g.pick(seedUsernameSuffixes), i)
+	created := g.since(g.now.AddDate(-3, 0, 0))
+	oid := g.objectID(created)
+	u := seedUser{
+		id:       oid.Hex(),
+		username: username,
+		created:  created,
+		flags: bson.D{
+			{Key: "isVerified", Value: g.chance(0.1)},
+			{Key: "isOrganisation", Value: g.chance(0.03)},
+			{Key: "isDeveloper", Value: g.chance(0.05)},
+			{Key: "isPartner", Value: g.chance(0.02)},
+			{Key: "isOwner", Value: i == 0},
+		},
+	}
+	g.users = append(g.users, u)
+	var links bson.A
+	for _, site := range seedLinkSites {
+		if g.chance(0.3) {
+			links = append(links, site+username)
+		}
+	}
+	doc := bson.D{
+		{Key: "_id", Value: oid},
+		{Key: "username", Value: username},
+		{Key: "displayname", Value: first + " " + last},
+		{Key: "userid", Value: i + 1},
+		{Key: "email", Value: username + "@example.com"},
+		{Key: "createdAt", Value: u.created},
+		{Key: "profilePicture", Value: "https://cdn.example.com/avatars/" + u.id + ".png"},
+		{Key: "profileBanner", Value: "https://cdn.example.com/banners/" + u.id + ".jpg"},
+		{Key: "bio", Value: g.sentence(4, 16)},
+	}
+	doc = append(doc, u.flags...)
+	doc = append(doc, bson.E{Key: "password", Value: g.password})
+	if len(links) > 0 {
+		doc = append(doc, bson.E{Key: "links", Value: links})
+	}
+	return append(doc, bson.E{Key: "__v", Value: 0})
+}
+
+func (g *seedGenerator) post(comments, hearts float64) bson.D {
+	author := g.users[g.random.Intn(len(g.users))]
+	created := g.since(author.created)
+	doc := bson.D{
+		{Key: "_id", Value: g.objectID(created)},
+		{Key: "title", Value: strings.TrimSuffix(g.sentence(3, 9), ".")},
+		{Key: "content", Value: g.paragraph(1, 4)},
+		{Key: "author", Value: author.id},
+	}
+	if g.chance(0.25) {
+		doc = append(doc, bson.E{Key: "imageUrl", Value: fmt.Sprintf("https://cdn.example.com/posts/%d.jpg", g.random.Intn(1000000))})
+	}
+	var hearted bson.A
+	for _, u := range g.sample(g.count(hearts)) {
+		hearted = append(hearted, u.id)
+	}
+	if hearted == nil {
+		hearted = bson.A{}
+	}
+	var thread bson.A
+	for i, n := 0, g.count(comments); i < n; i++ {
+		// About a third of the comments answer an earlier one
+		if len(thread) > 0 && g.chance(0.33) {
+			parent := thread[g.random.Intn(len(thread))].(bson.D)
+			// The last field of a comment is when it was made
+			answered := parent[len(parent)-1].Value.(time.Time)
+			for j, e := range parent {
+				if e.Key == "replies" {
+					parent[j].Value = append(e.Value.(bson.A), g.comment(answered))
+				}
+			}
+			continue
+		}
+		thread = append(thread, g.comment(created))
+	}
+	doc = append(doc, bson.E{Key: "hearts", Value: hearted}, bson.E{Key: "createdAt", Value: created})
+	if len(thread) > 0 {
+		doc = append(doc, bson.E{Key: "comments", Value: thread})
+	}
+	return append(doc, bson.E{Key: "__v", Value: 0})
+}
+
+// comment is a comment of a post made at after.
+func (g *seedGenerator) comment(after time.Time) bson.D {
+	author := g.users[g.random.Intn(len(g.users))]
+	created := g.since(after)
+	doc := bson.D{
+		{Key: "_id", Value: g.objectID(created)},
+		{Key: "content", Value: g.sentence(2, 20)},
+		{Key: "author", Value: author.id},
+		{Key: "authorName", Value: author.username},
+	}
+	doc = append(doc, author.flags...)
+	return append(doc, bson.E{Key: "replies", Value: bson.A{}}, bson.E{Key: "createdAt", Value: created})
+}
+
+// coterie is a group of users. The migration doesn't cover coteries, so its
+// shape only follows what the app stores.
+func (g *seedGenerator) coterie(members float64) bson.D {
+	owner := g.users[g.random.Intn(len(g.users))]
+	joined := bson.A{owner.id}
+	for _, u := range g.sample(g.count(members)) {
+		if u.id != owner.id {
+			joined = append(joined, u.id)
+		}
+	}
+	created := g.since(owner.created)
+	return bson.D{
+		{Key: "_id", Value: g.objectID(created)},
+		{Key: "name", Value: titleCase(g.pick(seedAdjectives) + " " + g.pick(seedNouns))},
+		{Key: "description", Value: g.sentence(6, 20)},
+		{Key: "owner", Value: owner.id},
+		{Key: "members", Value: joined},
+		{Key: "banner", Value: fmt.Sprintf("https://cdn.example.com/coteries/%d.jpg", g.random.Intn(1000000))},
+		{Key: "createdAt", Value: created},
+		{Key: "__v", Value: 0},
+	}
+}
+
+// partner is a partner with a title not in titles yet, as titles key the partners table.
+func (g *seedGenerator) partner(titles map[string]bool) bson.D {
+	base := titleCase(g.pick(seedAdjectives) + " " + g.pick(seedNouns))
+	name := base
+	for n := 2; titles[name]; n++ {
+		name = fmt.Sprintf("%s %d", base, n)
+	}
+	titles[name] = true
+	slug := strings.ReplaceAll(strings.ToLower(name), " ", "-")
+	return bson.D{
+		{Key: "_id", Value: g.objectID(g.since(g.now.AddDate(-3, 0, 0)))},
+		{Key: "banner", Value: "https://cdn.example.com/partners/" + slug + "-banner.jpg"},
+		{Key: "logo", Value: "https://cdn.example.com/partners/" + slug + ".png"},
+		{Key: "title", Value: name},
+		{Key: "text", Value: g.sentence(8, 24)},
+		{Key: "link", Value: "https://" + slug + ".example.com"},
+	}
+}
+
+// blog is a blog post with a slug not in slugs yet.
+func (g *seedGenerator) blog(slugs map[string]bool) bson.D {
+	title := strings.TrimSuffix(g.sentence(4, 10), ".")
+	slug := strings.ToLower(strings.Join(strings.Fields(title), "-"))
+	for n := 2; slugs[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", strings.ToLower(strings.Join(strings.Fields(title), "-")), n)
+	}
+	slugs[slug] = true
+	author := g.pick(seedFirstNames) + " " + g.pick(seedLastNames)
+	published := g.since(g.now.AddDate(-2, 0, 0))
+	var content bson.A
+	for i, n := 0, 2+g.random.Intn(5); i < n; i++ {
+		content = append(content, bson.D{{Key: "body", Value: g.paragraph(2, 6)}})
+	}
+	return bson.D{
+		{Key: "_id", Value: g.objectID(published)},
+		{Key: "slug", Value: slug},
+		{Key: "title", Value: title},
+		{Key: "date", Value: published.Format("January 02, 2006")},
+		{Key: "authorname", Value: author},
+		{Key: "overview", Value: g.sentence(10, 25)},
+		{Key: "authoravatar", Value: fmt.Sprintf("https://cdn.example.com/authors/%d.png", g.random.Intn(1000))},
+		{Key: "content", Value: content},
+	}
+}
+
+// objectID is an ObjectID created at t, as MongoDB would have made it then.
+func (g *seedGenerator) objectID(t time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[:4], uint32(t.Unix()))
+	g.random.Read(id[4:])
+	return id
+}
+
+// count is a random count averaging mean.
+func (g *seedGenerator) count(mean float64) int {
+	if mean <= 0 {
+		return 0
+	}
+	return int(g.random.ExpFloat64()*mean + 0.5)
+}
+
+// sample picks up to n distinct users.
+func (g *seedGenerator) sample(n int) []seedUser {
+	if n > len(g.users) {
+		n = len(g.users)
+	}
+	picked := make([]seedUser, n)
+	for i, j := range g.random.Perm(len(g.users))[:n] {
+		picked[i] = g.users[j]
+	}
+	return picked
+}
+
+func (g *seedGenerator) chance(p float64) bool {
+	return g.random.Float64() < p
+}
+
+func (g *seedGenerator) pick(words []string) string {
+	return words[g.random.Intn(len(words))]
+}
+
+// since is a time between t and now, truncated to the millisecond as
+// MongoDB stores it.
+func (g *seedGenerator) since(t time.Time) time.Time {
+	span := g.now.Sub(t)
+	if span <= 0 {
+		return g.now.Truncate(time.Millisecond)
+	}
+	return t.Add(time.Duration(g.random.Int63n(int64(span)))).Truncate(time.Millisecond)
+}
+
+// sentence is a sentence of min to max words.
+func (g *seedGenerator) sentence(min, max int) string {
+	words := make([]string, min+g.random.Intn(max-min+1))
+	for i := range words {
+		words[i] = g.pick(seedWords)
+	}
+	s := strings.Join(words, " ")
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+// paragraph is min to max sentences.
+func (g *seedGenerator) paragraph(min, max int) string {
+	sentences := make([]string, min+g.random.Intn(max-min+1))
+	for i := range sentences {
+		sentences[i] = g.sentence(5, 18)
+	}
+	return strings.Join(sentences, " ")
+}
+
+// titleCase capitalizes the first letter of every word of s.
+func titleCase(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+var (
+	seedFirstNames       = strings.Fields("Ada Alan Amara Ben Chidi Clara Daniel Elif Emma Farah Grace Hiro Ines Jonas Kai Lena Liam Maya Mateo Nadia Noah Olivia Omar Priya Quinn Ravi Sara Tomas Uma Victor Wen Yara Zoe")
+	seedLastNames        = strings.Fields("Adeyemi Bauer Chen Costa Dubois Eriksen Fernandez Garcia Haddad Ito Jensen Kowalski Lopez Martin Nakamura Novak Okafor Patel Quispe Rossi Schmidt Silva Tanaka Umar Virtanen Walker Xu Yilmaz Zhang")
+	seedUsernameSuffixes = strings.Fields("_dev _codes _art _writes _plays _x _hq _io")
+	seedAdjectives       = strings.Fields("bright quiet open swift golden hidden little northern urban wild cosmic friendly")
+	seedNouns            = strings.Fields("builders readers garden circle studio collective lab guild network harbor forge club")
+	seedLinkSites        = []string{"https://github.com/", "https://x.com/", "https://www.instagram.com/", "https://www.youtube.com/@"}
+	seedWords            = strings.Fields("the a and of to in is it that for on with as this was at by we our just new post today finally shipped working building release community thanks everyone update weekend coffee music code design open source project feature launch feedback love idea flux social friends photo trip learning team")
+)
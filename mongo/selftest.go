@@ -0,0 +1,342 @@
+package mongo
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// selftestPassword is the root password of the throwaway MySQL.
+const selftestPassword = "selftest"
+
+var selftestCommand = &cli.Command{
+	Name:  "selftest",
+	Usage: "Run a full migration between throwaway MongoDB and MySQL containers filled with fixtures, and check what lands in MySQL",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "docker", Value: "docker", Usage: "container CLI the databases are run with, e.g. podman"},
+		&cli.StringFlag{Name: "mongo-image", Value: "mongo:7", Usage: "image of the source database"},
+		&cli.StringFlag{Name: "mysql-image", Value: "mysql:8.0", Usage: "image of the target database"},
+		&cli.IntFlag{Name: "users", Value: 50, Usage: "users among the fixtures"},
+		&cli.IntFlag{Name: "posts", Value: 200, Usage: "posts among the fixtures, with comments and hearts"},
+		&cli.Int64Flag{Name: "seed", Value: 1, Usage: "seed the fixtures are generated from, as for seed"},
+		&cli.DurationFlag{Name: "timeout", Value: 3 * time.Minute, Usage: "how long the databases may take to start"},
+		&cli.BoolFlag{Name: "keep", Usage: "leave the containers running afterwards and print how to connect to them"},
+	},
+	Action: selftest,
+}
+
+func selftest(c *cli.Context) error {
+	if c.Int("users") < 1 || c.Int("posts") < 0 {
+		return fmt.Errorf("--users must be at least 1 and --posts can't be negative")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// Stopping removes the containers like finishing does
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	u := &checkup{}
+	st := &selftestRun{cli: c.String("docker"), suffix: strconv.FormatInt(time.Now().Unix(), 10)}
+	version, err := st.run("version", "--format", "{{.Server.Version}}")
+	if !u.check(strings.TrimSpace(st.cli+" "+version), err) {
+		return cli.Exit("no container runtime to run the databases with", 1)
+	}
+	defer st.teardown(c.Bool("keep"))
+
+	mongoAddr, err := st.start("mongo", c.String("mongo-image"), "27017/tcp")
+	if !u.check("MongoDB container", err) {
+		return cli.Exit("selftest failed", 1)
+	}
+	mysqlAddr, err := st.start("mysql", c.String("mysql-image"), "3306/tcp", "-e", "MYSQL_ROOT_PASSWORD="+selftestPassword, "-e", "MYSQL_DATABASE=socialflux")
+	if !u.check("MySQL container", err) {
+		return cli.Exit("selftest failed", 1)
+	}
+	st.mongoURI = "mongodb://" + mongoAddr + "/?directConnection=true"
+	st.mysqlURI = "root:" + selftestPassword + "@tcp(" + mysqlAddr + ")/socialflux"
+
+	ready, cancel := context.WithTimeout(ctx, c.Duration("timeout"))
+	defer cancel()
+	if !u.check("MongoDB ready", waitReady(ready, "MongoDB", st.pingMongo)) ||
+		!u.check("MySQL ready", waitReady(ready, "MySQL", st.pingMySQL)) {
+		return cli.Exit("selftest failed", 1)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(seedPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	g := &seedGenerator{random: rand.New(rand.NewSource(c.Int64("seed"))), now: time.Now().UTC(), password: string(hash)}
+	docs := g.generate(seedCounts{users: c.Int("users"), posts: c.Int("posts"), comments: 3, hearts: 5, partners: 5, blogs: 10})
+	expected, err := selftestExpected(docs)
+	if !u.check("fixtures transform", err) ||
+		!u.check("fixtures loaded", seedMongo(ctx, st.mongoURI, docs, false)) {
+		return cli.Exit("selftest failed", 1)
+	}
+
+	dir, err := os.MkdirTemp("", "cli-tools-selftest-")
+	if err != nil {
+		return err
+	}
+	if !u.check("migrate", st.migrate(ctx, exe, dir)) {
+		return cli.Exit("selftest failed", 1)
+	}
+
+	mysqlDB, err := openMySQL(st.mysqlURI, nil)
+	if !u.check("MySQL connection", err) {
+		return cli.Exit("selftest failed", 1)
+	}
+	defer mysqlDB.Close()
+	tables := make([]string, 0, len(expected))
+	for table := range expected {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		var n int
+		err := mysqlDB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+		if err == nil && n != expected[table] {
+			err = fmt.Errorf("%d row(s), expected %d", n, expected[table])
+		}
+		u.check(fmt.Sprintf("%s has %d row(s)", table, expected[table]), err)
+	}
+	u.check("user names", selftestContents(mysqlDB, "users", "username", docs["users"], "username"))
+	u.check("post titles", selftestContents(mysqlDB, "posts", "title", docs["posts"], "title"))
+	u.check("post authors", selftestContents(mysqlDB, "posts", "author", docs["posts"], "author"))
+
+	if u.failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d check(s) failed, migrate's log is in %s", u.failed, dir), 1)
+	}
+	os.RemoveAll(dir)
+	logf(levelInfo, "The migration works end to end in this environment")
+	return nil
+}
+
+// selftestRun is the containers of one selftest and how to reach them.
+type selftestRun struct {
+	// cli is the docker compatible CLI the containers are run with
+	cli        string
+	suffix     string
+	containers []string
+	mongoURI   string
+	mysqlURI   string
+}
+
+// run runs the container CLI and returns what it printed.
+func (st *selftestRun) run(args ...string) (string, error) {
+	out, err := exec.Command(st.cli, args...).Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return "", fmt.Errorf("%s %s: %s", st.cli, args[0], strings.TrimSpace(string(exit.Stderr)))
+		}
+		return "", fmt.Errorf("%s %s: %v", st.cli, args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// start runs a container of image publishing port on a free local port, and
+// returns the address it is reachable at.
+func (st *selftestRun) start(db, image, port string, args ...string) (string, error) {
+	name := "cli-tools-selftest-" + db + "-" + st.suffix
+	args = append([]string{"run", "-d", "--rm", "--name", name, "-p", "127.0.0.1::" + port}, args...)
+	if _, err := st.run(append(args, image)...); err != nil {
+		return "", err
+	}
+	st.containers = append(st.containers, name)
+	out, err := st.run("port", name, port)
+	if err != nil {
+		return "", err
+	}
+	// One line per address the port is published on, e.g. 127.0.0.1:49153
+	addr, _, _ := strings.Cut(out, "\n")
+	if addr == "" {
+		return "", fmt.Errorf("%s doesn't publish %s", name, port)
+	}
+	return addr, nil
+}
+
+// teardown removes the containers, or says how to reach them when keeping them.
+func (st *selftestRun) teardown(keep bool) {
+	if len(st.containers) == 0 {
+		return
+	}
+	if keep {
+		logf(levelInfo, "Kept the containers; connect with --mongodb-uri %q --mysql-uri %q and remove them with %s rm -f %s",
+			st.mongoURI, st.mysqlURI, st.cli, strings.Join(st.containers, " "))
+		return
+	}
+	if _, err := st.run(append([]string{"rm", "-f"}, st.containers...)...); err != nil {
+		logf(levelError, "error removing the containers: %v", err)
+	}
+}
+
+func (st *selftestRun) pingMongo(ctx context.Context) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(st.mongoURI).SetServerSelectionTimeout(2*time.Second))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.TODO())
+	return client.Ping(ctx, nil)
+}
+
+func (st *selftestRun) pingMySQL(ctx context.Context) error {
+	mysqlDB, err := sqlOpen(st.mysqlURI)
+	if err != nil {
+		return err
+	}
+	defer mysqlDB.Close()
+	return mysqlDB.PingContext(ctx)
+}
+
+// waitReady pings a database every second until it answers or ctx is done.
+func waitReady(ctx context.Context, name string, ping func(context.Context) error) error {
+	for {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s didn't start: %v", name, err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// migrate runs this binary's migrate between the containers, comments, hearts
+// and links normalized, logging to migrate.log in dir.
+func (st *selftestRun) migrate(ctx context.Context, exe, dir string) error {
+	path := filepath.Join(dir, "migrate.log")
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.CommandContext(ctx, exe, "migrate", "--yes",
+		"--mongodb-uri", st.mongoURI, "--mysql-uri", st.mysqlURI,
+		"--normalize-comments", "--normalize-hearts", "--normalize-links",
+		"--runs-dir", filepath.Join(dir, "runs"))
+	cmd.Stdout, cmd.Stderr = out, out
+	// The operator's profile would point migrate elsewhere
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "CLI_TOOLS_PROFILE=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	if err := cmd.Run(); err != nil {
+		for _, line := range logTail(path, 20) {
+			logf(levelError, "  %s", line)
+		}
+		return fmt.Errorf("%v, see %s", err, path)
+	}
+	return nil
+}
+
+// logTail returns the last n lines of a file.
+func logTail(path string, n int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
+
+// selftestExpected counts the rows of each table the transfer functions make
+// of the fixtures, comments, hearts and links normalized.
+func selftestExpected(docs map[string][]bson.D) (map[string]int, error) {
+	m := &migrator{comments: true, hearts: true, links: true, dryRun: true}
+	expected := map[string]int{}
+	for _, cm := range collectionMigrations {
+		for _, doc := range docs[cm.Name] {
+			data, err := bson.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			rows, err := cm.Rows(m, &document{data: data, unmarshal: bson.Unmarshal, bson: true})
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", cm.Name, selftestField(doc, "_id"), err)
+			}
+			for _, row := range rows {
+				expected[row.Table]++
+			}
+		}
+	}
+	return expected, nil
+}
+
+// selftestContents checks that the column of each fixture's row in table
+// holds the fixture's field.
+func selftestContents(mysqlDB *sql.DB, table, column string, docs []bson.D, field string) error {
+	rows, err := mysqlDB.Query("SELECT id, " + column + " FROM " + table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	got := map[string]sql.NullString{}
+	for rows.Next() {
+		var id string
+		var value sql.NullString
+		if err := rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		got[id] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		id, want := selftestField(doc, "_id"), selftestField(doc, field)
+		value, ok := got[id]
+		if !ok {
+			return fmt.Errorf("%s %s is missing", table, id)
+		}
+		if value.String != want {
+			return fmt.Errorf("%s %s has %s %q, expected %q", table, id, column, value.String, want)
+		}
+	}
+	return nil
+}
+
+// selftestField returns a fixture's field as text, ObjectIDs in hex.
+func selftestField(doc bson.D, key string) string {
+	for _, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if oid, ok := e.Value.(primitive.ObjectID); ok {
+			return oid.Hex()
+		}
+		return fmt.Sprint(e.Value)
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package mongo
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSelftestExpected(t *testing.T) {
+	g := &seedGenerator{random: rand.New(rand.NewSource(1)), now: time.Now().UTC(), password: "x"}
+	docs := g.generate(seedCounts{users: 20, posts: 50, comments: 3, hearts: 5, partners: 2, blogs: 3})
+	expected, err := selftestExpected(docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One row per document of the collections migrated into a table of their own
+	for table, collection := range map[string]string{"users": "users", "posts": "posts", "partners": "partners", "blogs": "blogs"} {
+		if expected[table] != len(docs[collection]) {
+			t.Errorf("%s: %d row(s) expected of %d document(s)", table, expected[table], len(docs[collection]))
+		}
+	}
+	for _, table := range []string{"comments", "post_heart", "user_links", "blog_entries"} {
+		if expected[table] == 0 {
+			t.Errorf("no %s rows expected of the normalized fixtures", table)
+		}
+	}
+}
+
+func TestSelftestField(t *testing.T) {
+	oid := primitive.NewObjectID()
+	doc := bson.D{{Key: "_id", Value: oid}, {Key: "title", Value: "hello"}, {Key: "userid", Value: 3}}
+	tests := []struct{ key, want string }{
+		{"_id", oid.Hex()},
+		{"title", "hello"},
+		{"userid", "3"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := selftestField(doc, tt.key); got != tt.want {
+			t.Errorf("selftestField(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
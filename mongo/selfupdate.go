@@ -0,0 +1,253 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// maxReleaseAsset caps the size of a downloaded binary.
+const maxReleaseAsset = 256 << 20
+
+var selfUpdateCommand = &cli.Command{
+	Name:  "self-update",
+	Usage: "Replace this binary with the latest GitHub release",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "repo",
+			Value: "NetSocialOSS/CLI-Tools",
+			Usage: "GitHub repository the releases are published in",
+		},
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "only report whether a newer release exists, exiting 1 if so",
+		},
+		&cli.StringFlag{
+			Name:    "github-token",
+			EnvVars: []string{"GITHUB_TOKEN"},
+			Usage:   "token for the GitHub API, for private repositories and higher rate limits",
+		},
+	},
+	Action: selfUpdate,
+}
+
+// githubRelease is the part of a GitHub release the update reads.
+type githubRelease struct {
+	Tag    string `json:"tag_name"`
+	URL    string `json:"html_url"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of the release asset called name.
+func (r *githubRelease) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+func selfUpdate(c *cli.Context) error {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	token := c.String("github-token")
+	release, err := latestRelease(client, c.String("repo"), token)
+	if err != nil {
+		return err
+	}
+	current, latest := c.App.Version, strings.TrimPrefix(release.Tag, "v")
+	if !newerVersion(latest, current) {
+		logf(levelInfo, "%s is up to date, the latest release is %s", current, release.Tag)
+		return nil
+	}
+	if c.Bool("check") {
+		return cli.Exit(fmt.Sprintf("%s is out of date, %s is available: %s", current, release.Tag, release.URL), 1)
+	}
+
+	name := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+	url, ok := release.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no %s binary", release.Tag, name)
+	}
+	logf(levelInfo, "Downloading %s %s", name, release.Tag)
+	binary, err := download(client, url, token)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %v", name, err)
+	}
+	if url, ok := release.asset("checksums.txt"); ok {
+		sums, err := download(client, url, token)
+		if err != nil {
+			return fmt.Errorf("error downloading checksums.txt: %v", err)
+		}
+		if err := verifyChecksum(sums, name, binary); err != nil {
+			return err
+		}
+	} else {
+		logf(levelWarn, "Release %s has no checksums.txt, installing %s unverified", release.Tag, name)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating this binary: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("error locating this binary: %v", err)
+	}
+	if err := replaceBinary(exe, binary); err != nil {
+		return err
+	}
+	logf(levelInfo, "Updated %s from %s to %s", exe, current, release.Tag)
+	return nil
+}
+
+func latestRelease(client *http.Client, repo, token string) (*githubRelease, error) {
+	data, err := download(client, "https://api.github.com/repos/"+repo+"/releases/latest", token)
+	if err != nil {
+		return nil, fmt.Errorf("error checking the releases of %s: %v", repo, err)
+	}
+	var release githubRelease
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("error reading the latest release of %s: %v", repo, err)
+	}
+	return &release, nil
+}
+
+func download(client *http.Client, url, token string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxReleaseAsset+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxReleaseAsset {
+		return nil, fmt.Errorf("larger than %d MiB", maxReleaseAsset>>20)
+	}
+	return data, nil
+}
+
+// releaseAssetName is the name release binaries are published under, e.g.
+// cli-tools_linux_amd64 or cli-tools_windows_amd64.exe.
+func releaseAssetName(goos, goarch string) string {
+	name := "cli-tools_" + goos + "_" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// verifyChecksum checks binary against its line of a sha256sum style
+// checksums file.
+func verifyChecksum(sums []byte, name string, binary []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(binary)
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("%s doesn't match its checksum, not installing it", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+// replaceBinary swaps the binary at exe for binary. The new one is written
+// next to it and renamed over it, so a failed update leaves the old one in
+// place. Windows can't overwrite a running binary, so it is moved aside first.
+func replaceBinary(exe string, binary []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return fmt.Errorf("error locating this binary: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), filepath.Base(exe)+".new-*")
+	if err != nil {
+		return fmt.Errorf("error writing the new binary: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the new binary: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing the new binary: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return fmt.Errorf("error writing the new binary: %v", err)
+	}
+	old := ""
+	if runtime.GOOS == "windows" {
+		old = exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return fmt.Errorf("error moving the old binary aside: %v", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		if old != "" {
+			os.Rename(old, exe)
+		}
+		return fmt.Errorf("error replacing %s: %v", exe, err)
+	}
+	return nil
+}
+
+// newerVersion reports whether version a, like 1.2.0 or 1.3.0-rc1, is newer
+// than b. Missing parts count as 0, and a release is newer than its
+// pre-releases, which compare as text.
+func newerVersion(a, b string) bool {
+	coreA, preA, _ := strings.Cut(a, "-")
+	coreB, preB, _ := strings.Cut(b, "-")
+	pa, pb := strings.Split(coreA, "."), strings.Split(coreB, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		x, y := 0, 0
+		if i < len(pa) {
+			x, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(pb[i])
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	switch {
+	case preA == preB:
+		return false
+	case preA == "":
+		return true
+	case preB == "":
+		return false
+	}
+	return preA > preB
+}
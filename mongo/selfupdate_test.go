@@ -0,0 +1,90 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1.2.1", "1.2.0", true},
+		{"1.2.0", "1.2.1", false},
+		{"1.10.0", "1.9.0", true},
+		{"2.0.0", "1.99.99", true},
+		{"1.2.0", "1.2.0", false},
+		// Missing parts count as 0
+		{"1.2", "1.2.0", false},
+		{"1.2.0.1", "1.2", true},
+		// A release is newer than its pre-releases
+		{"1.3.0", "1.3.0-rc1", true},
+		{"1.3.0-rc1", "1.3.0", false},
+		{"1.3.0-rc2", "1.3.0-rc1", true},
+		{"1.3.0-rc1", "1.2.9", true},
+		{"1.3.0-beta", "1.3.0-alpha", true},
+	}
+	for _, tt := range tests {
+		if got := newerVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	binary := []byte("new binary")
+	sum := sha256.Sum256(binary)
+	good := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name string
+		sums string
+		want string
+	}{
+		{"text mode", fmt.Sprintf("%s  cli-tools_linux_amd64\n", good), ""},
+		{"binary mode", fmt.Sprintf("%s *cli-tools_linux_amd64\n", good), ""},
+		{"upper case", fmt.Sprintf("%s  cli-tools_linux_amd64\n", strings.ToUpper(good)), ""},
+		{"among others", fmt.Sprintf("%s  cli-tools_darwin_arm64\n%s  cli-tools_linux_amd64\n", strings.Repeat("0", 64), good), ""},
+		{"mismatch", fmt.Sprintf("%s  cli-tools_linux_amd64\n", strings.Repeat("0", 64)), "doesn't match its checksum"},
+		{"missing", fmt.Sprintf("%s  cli-tools_darwin_arm64\n", good), "no checksum for cli-tools_linux_amd64"},
+		// The name has to match exactly, not as a prefix
+		{"prefix", fmt.Sprintf("%s  cli-tools_linux_amd64.exe\n", good), "no checksum"},
+		{"empty", "", "no checksum"},
+	}
+	for _, tt := range tests {
+		err := verifyChecksum([]byte(tt.sums), "cli-tools_linux_amd64", binary)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReplaceBinary(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "cli-tools")
+	if err := os.WriteFile(exe, []byte("old binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceBinary(exe, []byte("new binary")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil || string(data) != "new binary" {
+		t.Errorf("binary = %q, %v, want the new one", data, err)
+	}
+	if info, err := os.Stat(exe); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0o755 {
+		t.Errorf("binary mode = %v, want 0755", info.Mode().Perm())
+	}
+	if tmp, _ := filepath.Glob(exe + ".new-*"); len(tmp) != 0 {
+		t.Errorf("left %v next to the binary", tmp)
+	}
+}
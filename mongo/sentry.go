@@ -0,0 +1,339 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// sentryMaxSamples is how many failed documents of a collection and category
+// a failure report names.
+const sentryMaxSamples = 10
+
+// sentryConfig is the config file's sentry section.
+type sentryConfig struct {
+	DSN         string `json:"dsn" doc:"DSN of the Sentry project, like https://<key>@o1.ingest.sentry.io/<project>"`
+	Environment string `json:"environment" default:"the --profile" doc:"environment the events are filed under"`
+}
+
+func (sc *sentryConfig) validate() error {
+	if sc.DSN == "" {
+		return nil
+	}
+	if _, _, err := parseSentryDSN(sc.DSN); err != nil {
+		return fmt.Errorf("sentry: %v", err)
+	}
+	return nil
+}
+
+// parseSentryDSN returns the envelope endpoint and public key of a DSN.
+func parseSentryDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid dsn: %v", err)
+	}
+	prefix, project := "", strings.TrimPrefix(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User.Username() == "" || project == "" {
+		return "", "", fmt.Errorf("invalid dsn %q, expected https://<key>@<host>/<project>", dsn)
+	}
+	return u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/envelope/", u.User.Username(), nil
+}
+
+// crashReporter is the Sentry project panics are reported to, nil when the
+// run reports to none.
+var crashReporter *sentryReporter
+
+// sentryReporter sends a run's panics, errors and failed documents to Sentry,
+// for runs nobody watches such as the daemon's. It is subscribed to a run's
+// finish and error events like the other notifiers.
+type sentryReporter struct {
+	endpoint, key string
+	dsn           string
+	release       string
+	environment   string
+	// runID tags the events once the run directory is made
+	runID string
+}
+
+// runSentry sets up the reporter of --sentry-dsn or the config's sentry
+// section, nil when neither names a DSN.
+func runSentry(c *cli.Context, cfg *config) (*sentryReporter, error) {
+	sc := sentryConfig{}
+	if cfg.Sentry != nil {
+		sc = *cfg.Sentry
+	}
+	if dsn := c.String("sentry-dsn"); dsn != "" {
+		sc.DSN = dsn
+	}
+	if sc.DSN == "" {
+		return nil, nil
+	}
+	if sc.Environment == "" {
+		sc.Environment = c.String("profile")
+	}
+	endpoint, key, err := parseSentryDSN(sc.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("--sentry-dsn: %v", err)
+	}
+	return &sentryReporter{endpoint: endpoint, key: key, dsn: sc.DSN, release: "cli-tools@" + c.App.Version, environment: sc.Environment}, nil
+}
+
+// sentryEvent is the subset of Sentry's event payload the reports use.
+type sentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger"`
+	Release     string                 `json:"release"`
+	Environment string                 `json:"environment,omitempty"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Message     *sentryMessage         `json:"message,omitempty"`
+	Exception   *sentryExceptions      `json:"exception,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	Fingerprint []string               `json:"fingerprint,omitempty"`
+}
+
+type sentryMessage struct {
+	Formatted string `json:"formatted"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+func (s *sentryReporter) notify(e runEvent) error {
+	// Being stopped isn't an error worth tracking
+	var exit cli.ExitCoder
+	if errors.As(e.Err, &exit) && exit.ExitCode() == 130 {
+		return nil
+	}
+	if e.Kind == eventError {
+		event := s.event("error", fmt.Sprintf("%s: %v", e.title(), e.Err))
+		event.Fingerprint = []string{"migration-error", e.Err.Error()}
+		if err := s.send(event); err != nil {
+			return err
+		}
+	}
+	if e.Run == nil {
+		return nil
+	}
+	for _, cm := range e.Selected {
+		if err := e.Run.aborted[cm.Name]; err != nil {
+			event := s.event("error", fmt.Sprintf("%s aborted: %v", cm.Name, err))
+			event.Tags["collection"] = cm.Name
+			event.Fingerprint = []string{"collection-aborted", cm.Name}
+			if err := s.send(event); err != nil {
+				return err
+			}
+		}
+		if err := s.reportFailures(e.Run, cm.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportFailures sends one event per category of the collection's failures,
+// with the IDs and errors of the first documents that failed with it.
+func (s *sentryReporter) reportFailures(run *migrator, collection string) error {
+	counts := run.failureCounts(collection)
+	if len(counts) == 0 {
+		return nil
+	}
+	samples, err := failureSamples(run.failed.path(collection))
+	if err != nil {
+		logf(levelWarn, "Not naming the failed %s documents to Sentry: %v", collection, err)
+	}
+	for _, kind := range failureKinds {
+		n := counts[kind]
+		if n == 0 {
+			continue
+		}
+		event := s.event("warning", fmt.Sprintf("%d %s document(s) failed to migrate: %s", n, collection, kind))
+		event.Tags["collection"] = collection
+		event.Tags["failure"] = string(kind)
+		event.Extra["count"] = n
+		if docs := samples[kind]; len(docs) > 0 {
+			event.Extra["documents"] = docs
+			event.Extra["quarantine"] = run.failed.path(collection)
+		}
+		event.Fingerprint = []string{"migration-failures", collection, string(kind)}
+		if err := s.send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failureSamples reads the IDs and errors of the first documents of a
+// dead-letter file for each category.
+func failureSamples(path string) (map[failureKind][]map[string]string, error) {
+	samples := map[failureKind][]map[string]string{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return samples, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var doc failedDocument
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			return samples, err
+		}
+		if len(samples[doc.Kind]) < sentryMaxSamples {
+			samples[doc.Kind] = append(samples[doc.Kind], map[string]string{"id": documentID(doc.Document), "error": doc.Error})
+		}
+	}
+	return samples, scanner.Err()
+}
+
+// reportPanic reports a panic of the goroutine it is deferred in to Sentry and
+// panics on with it. doc is the document being migrated, if any.
+func reportPanic(collection string, doc documentCursor) {
+	if crashReporter == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	event := crashReporter.event("fatal", fmt.Sprintf("panic: %v", r))
+	event.Exception = &sentryExceptions{Values: []sentryException{{
+		Type:       fmt.Sprintf("panic (%T)", r),
+		Value:      fmt.Sprint(r),
+		Stacktrace: panicStack(),
+	}}}
+	if collection != "" {
+		event.Tags["collection"] = collection
+	}
+	if doc != nil {
+		event.Tags["document"] = documentID(doc.Raw())
+	}
+	if err := crashReporter.send(event); err != nil {
+		logf(levelWarn, "error reporting the panic to Sentry: %v", err)
+	}
+	panic(r)
+}
+
+// panicStack returns the stack of the panicking goroutine from where it
+// panicked, outermost call first as Sentry expects.
+func panicStack() *sentryStacktrace {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	var stack []sentryFrame
+	panicked := false
+	for {
+		frame, more := frames.Next()
+		if panicked {
+			stack = append(stack, sentryFrame{
+				Function: frame.Function,
+				Filename: frame.File,
+				Lineno:   frame.Line,
+				InApp:    strings.HasPrefix(frame.Function, "tbl/"),
+			})
+		}
+		if frame.Function == "runtime.gopanic" {
+			panicked = true
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return &sentryStacktrace{Frames: stack}
+}
+
+// event starts an event of the given level with the run's tags.
+func (s *sentryReporter) event(level, message string) *sentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	event := &sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Platform:    "go",
+		Level:       level,
+		Logger:      "cli-tools",
+		Release:     s.release,
+		Environment: s.environment,
+		ServerName:  host,
+		Message:     &sentryMessage{Formatted: message},
+		Tags:        map[string]string{"command": "migrate"},
+		Extra:       map[string]interface{}{},
+	}
+	if s.runID != "" {
+		event.Tags["run"] = s.runID
+	}
+	return event
+}
+
+// send posts event to Sentry as an envelope.
+func (s *sentryReporter) send(event *sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": event.EventID, "dsn": s.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	var body bytes.Buffer
+	body.Write(header)
+	fmt.Fprintf(&body, "\n{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteString("\n")
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client="+s.release+", sentry_key="+s.key)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
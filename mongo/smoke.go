@@ -0,0 +1,138 @@
+package mongo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// smokeQuery is one read the new API serves. Pick selects the value the
+// query is run for, the way a request would name a user or post; queries
+// without a parameter leave it empty.
+type smokeQuery struct {
+	Name  string
+	Pick  string
+	Query string
+}
+
+// smokeQueries are the reads behind the pages users open first. Coteries and
+// bots aren't migrated, so their pages aren't covered.
+var smokeQueries = []smokeQuery{
+	{
+		Name:  "profile fetch",
+		Pick:  "SELECT username FROM users WHERE username IS NOT NULL ORDER BY created_at DESC LIMIT 1",
+		Query: "SELECT id, username, display_name, bio, profile_picture, is_verified FROM users WHERE username = ?",
+	},
+	{
+		Name:  "profile posts",
+		Pick:  "SELECT author FROM posts WHERE author IS NOT NULL ORDER BY created_at DESC LIMIT 1",
+		Query: "SELECT id, title, created_at FROM posts WHERE author = ? ORDER BY created_at DESC LIMIT 20",
+	},
+	{
+		Name:  "feed page",
+		Query: "SELECT p.id, p.title, p.content, p.created_at, u.username, u.display_name FROM posts p LEFT JOIN users u ON u.id = p.author ORDER BY p.created_at DESC LIMIT 20",
+	},
+	{
+		Name:  "post comments",
+		Pick:  "SELECT post_id FROM comments ORDER BY created_at DESC LIMIT 1",
+		Query: "SELECT c.id, c.parent_id, c.content, c.created_at, u.username FROM comments c LEFT JOIN users u ON u.id = c.author WHERE c.post_id = ? ORDER BY c.created_at",
+	},
+	{
+		Name:  "post hearts",
+		Pick:  "SELECT post_id FROM post_heart LIMIT 1",
+		Query: "SELECT count(*) FROM post_heart WHERE post_id = ?",
+	},
+	{
+		Name:  "blog listing",
+		Query: "SELECT slug, title, date, author_name, overview FROM blogs ORDER BY published_at DESC LIMIT 20",
+	},
+	{
+		Name:  "partner listing",
+		Query: "SELECT title, text, link, logo FROM partners",
+	},
+}
+
+var smokeCommand = &cli.Command{
+	Name:  "smoke",
+	Usage: "Time the reads the new API serves against MySQL and check they return data, as the gate before cutover",
+	Flags: withFlags(mysqlFlags, []cli.Flag{
+		&cli.IntFlag{Name: "repeat", Value: 5, Usage: "times each query is run"},
+		&cli.DurationFlag{Name: "max-latency", Value: 200 * time.Millisecond, Usage: "slowest run a query may take"},
+	}),
+	Action: smoke,
+}
+
+func smoke(c *cli.Context) error {
+	if c.Int("repeat") < 1 {
+		return fmt.Errorf("--repeat must be at least 1")
+	}
+	mysqlDB := connectMySQL(c.String("mysql-uri"), nil)
+	defer mysqlDB.Close()
+
+	u := &checkup{}
+	for _, q := range smokeQueries {
+		timing, err := smokeCheck(mysqlDB, q, c.Int("repeat"), c.Duration("max-latency"))
+		u.check(q.Name+timing, err)
+	}
+	if u.failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d smoke check(s) failed", u.failed, len(smokeQueries)), 1)
+	}
+	return nil
+}
+
+// smokeCheck runs q repeat times, failing when it returns no rows or its
+// slowest run exceeds maxLatency. It returns the rows and latencies it saw.
+func smokeCheck(mysqlDB *sql.DB, q smokeQuery, repeat int, maxLatency time.Duration) (string, error) {
+	var args []interface{}
+	if q.Pick != "" {
+		var param string
+		err := mysqlDB.QueryRow(q.Pick).Scan(&param)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("nothing to run it for")
+		}
+		if err != nil {
+			return "", err
+		}
+		args = append(args, param)
+	}
+
+	latencies := make([]time.Duration, repeat)
+	rows := 0
+	for i := range latencies {
+		start := time.Now()
+		n, err := countRows(mysqlDB, q.Query, args...)
+		latencies[i] = time.Since(start)
+		if err != nil {
+			return "", err
+		}
+		rows = n
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	median, slowest := latencies[len(latencies)/2], latencies[len(latencies)-1]
+	timing := fmt.Sprintf(" (%d row(s), median %s, slowest %s)", rows, median.Round(time.Microsecond), slowest.Round(time.Microsecond))
+	switch {
+	case rows == 0:
+		return timing, fmt.Errorf("no rows")
+	case slowest > maxLatency:
+		return timing, fmt.Errorf("slower than %s", maxLatency)
+	}
+	return timing, nil
+}
+
+// countRows runs query and reads every row, like a client would.
+func countRows(mysqlDB *sql.DB, query string, args ...interface{}) (int, error) {
+	rows, err := mysqlDB.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
@@ -0,0 +1,559 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// documentCursor walks the documents of one source collection, so the migrate
+// functions don't care where documents come from. Raw returns the current
+// document as JSON, for reporting documents that fail to transfer.
+type documentCursor interface {
+	Next(ctx context.Context) bool
+	Decode(v interface{}) error
+	Raw() json.RawMessage
+	Err() error
+	Close(ctx context.Context) error
+	// Detach returns the current document as a cursor of its own, which
+	// stays valid once Next moves on.
+	Detach() documentCursor
+}
+
+// document is one document detached from the cursor it was read with. It
+// decodes the way that cursor does.
+type document struct {
+	data      []byte
+	unmarshal func(data []byte, v interface{}) error
+	// bson is set for BSON data, which Raw renders as extended JSON
+	bson bool
+}
+
+func (d *document) Next(ctx context.Context) bool   { return false }
+func (d *document) Decode(v interface{}) error      { return d.unmarshal(d.data, v) }
+func (d *document) Err() error                      { return nil }
+func (d *document) Close(ctx context.Context) error { return nil }
+func (d *document) Detach() documentCursor          { return d }
+
+func (d *document) Raw() json.RawMessage {
+	if !d.bson {
+		return d.data
+	}
+	data, err := bson.MarshalExtJSON(bson.Raw(d.data), false, false)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// unmarshalExtJSON decodes relaxed extended JSON.
+func unmarshalExtJSON(data []byte, v interface{}) error {
+	return bson.UnmarshalExtJSON(data, false, v)
+}
+
+// documentSource opens a cursor over a named collection.
+type documentSource interface {
+	Open(ctx context.Context, collection string, opts readOptions) (documentCursor, error)
+	Close(ctx context.Context) error
+}
+
+// documentCounter is a documentSource that can tell how many documents a
+// collection has without reading them.
+type documentCounter interface {
+	Count(ctx context.Context, collection string, filter bson.D) (int64, error)
+}
+
+// readOptions shape how a collection is read.
+type readOptions struct {
+	// Sort orders the documents by these fields, ascending.
+	Sort []string
+	// Skip starts reading after this many documents, for resuming a
+	// collection in the same order.
+	Skip int
+	// Filter is a MongoDB query the documents must match, nil for all.
+	Filter bson.D
+	// Projection picks the fields read, nil for all.
+	Projection bson.D
+}
+
+// mongoSource reads collections straight from the SocialFlux database.
+type mongoSource struct {
+	client *mongo.Client
+	db     *mongo.Database
+	// batchSize is the number of documents fetched per round trip, 0 for the server default.
+	batchSize int32
+	// readPref picks the members read from, nil for the connection string's
+	readPref *readpref.ReadPref
+	// noCursorTimeout keeps idle cursors open until they are closed
+	noCursorTimeout bool
+}
+
+func newMongoSource(ctx context.Context, uri string, retry *retrier) (*mongoSource, error) {
+	opts := options.Client().ApplyURI(uri)
+	if mongoTLS != nil {
+		opts.SetTLSConfig(mongoTLS)
+	}
+	if tunneled["mongodb"] {
+		opts.SetDialer(tunnel)
+	}
+	setMongoPool(opts)
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to MongoDB: %v", err)
+	}
+	// Connect is lazy; ping so an unreachable cluster is retried here rather than on the first Find
+	err = retry.do("connect", func() error {
+		return client.Ping(ctx, nil)
+	})
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("error connecting to MongoDB: %v", err)
+	}
+	return &mongoSource{client: client, db: client.Database("SocialFlux")}, nil
+}
+
+// parseReadPreference reads a --read-preference mode name.
+func parseReadPreference(name string) (*readpref.ReadPref, error) {
+	mode, err := readpref.ModeFromString(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown --read-preference %q, expected primary, primaryPreferred, secondary, secondaryPreferred or nearest", name)
+	}
+	return readpref.New(mode)
+}
+
+func (s *mongoSource) Open(ctx context.Context, collection string, opts readOptions) (documentCursor, error) {
+	find := options.Find()
+	if len(opts.Sort) > 0 {
+		sort := bson.D{}
+		for _, field := range opts.Sort {
+			sort = append(sort, bson.E{Key: field, Value: 1})
+		}
+		find.SetSort(sort)
+	}
+	if s.batchSize > 0 {
+		find.SetBatchSize(s.batchSize)
+	}
+	if opts.Skip > 0 {
+		find.SetSkip(int64(opts.Skip))
+	}
+	if s.noCursorTimeout {
+		find.SetNoCursorTimeout(true)
+	}
+	if opts.Projection != nil {
+		find.SetProjection(opts.Projection)
+	}
+	coll := options.Collection()
+	if s.readPref != nil {
+		coll.SetReadPreference(s.readPref)
+	}
+	filter := bson.D{}
+	if opts.Filter != nil {
+		filter = opts.Filter
+	}
+	logf(levelDebug, "Finding %s (filter %s, sort %v, skip %d, batch size %d, read preference %v)", collection, filterText(filter), opts.Sort, opts.Skip, s.batchSize, s.readPref)
+	cursor, err := s.db.Collection(collection, coll).Find(ctx, filter, find)
+	if err != nil {
+		return nil, fmt.Errorf("error finding %s: %w", collection, err)
+	}
+	return mongoCursor{cursor}, nil
+}
+
+// Count reads the estimate of the collection's metadata, or counts the
+// documents matching filter when there is one.
+func (s *mongoSource) Count(ctx context.Context, collection string, filter bson.D) (int64, error) {
+	coll := options.Collection()
+	if s.readPref != nil {
+		coll.SetReadPreference(s.readPref)
+	}
+	c := s.db.Collection(collection, coll)
+	if filter == nil {
+		return c.EstimatedDocumentCount(ctx)
+	}
+	return c.CountDocuments(ctx, filter)
+}
+
+func (s *mongoSource) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// mongoCursor adds Raw to *mongo.Cursor.
+type mongoCursor struct {
+	*mongo.Cursor
+}
+
+// Raw renders the current document as relaxed extended JSON.
+func (c mongoCursor) Raw() json.RawMessage {
+	data, err := bson.MarshalExtJSON(c.Current, false, false)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Detach copies the current document, whose bytes the cursor reuses.
+func (c mongoCursor) Detach() documentCursor {
+	return &document{data: append([]byte(nil), c.Current...), unmarshal: bson.Unmarshal, bson: true}
+}
+
+// apiSource pulls collections page by page from the NetSocial REST API, for
+// environments where the tool may not talk to MongoDB directly. Every
+// collection is expected at GET <base>/<collection>?page=N&limit=M and to
+// answer with a JSON array; a short page ends the collection.
+type apiSource struct {
+	base     *url.URL
+	token    string
+	pageSize int
+	interval time.Duration
+	client   *http.Client
+	retry    *retrier
+	last     time.Time
+}
+
+func newAPISource(base, token string, pageSize int, rate float64, retry *retrier) (*apiSource, error) {
+	if base == "" {
+		return nil, fmt.Errorf("--api-url is required with --source api")
+	}
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --api-url: %v", err)
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("--api-page-size must be positive")
+	}
+	s := &apiSource{
+		base:     u,
+		token:    token,
+		pageSize: pageSize,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		retry:    retry,
+	}
+	if rate > 0 {
+		s.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return s, nil
+}
+
+func (s *apiSource) Open(ctx context.Context, collection string, opts readOptions) (documentCursor, error) {
+	if len(opts.Sort) > 0 {
+		return nil, fmt.Errorf("the API source can't read %s in a guaranteed order", collection)
+	}
+	if opts.Filter != nil || opts.Projection != nil {
+		return nil, fmt.Errorf("the API source can't filter or project %s", collection)
+	}
+	// Pages are numbered from 1; start at the page holding document Skip+1
+	return &apiCursor{source: s, collection: collection, page: opts.Skip / s.pageSize, skip: opts.Skip % s.pageSize, index: -1}, nil
+}
+
+func (s *apiSource) Close(ctx context.Context) error {
+	return nil
+}
+
+// statusError is a server error response of the API, which is retried like
+// a network error since it is mostly a proxy or a restart getting in the way.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return e.status }
+
+// fetch loads one page of a collection, waiting out the rate limit first and
+// honouring Retry-After when the API throttles us anyway. Server errors are
+// retried with backoff.
+func (s *apiSource) fetch(ctx context.Context, collection string, page int) ([]json.RawMessage, error) {
+	for {
+		if wait := s.interval - time.Since(s.last); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		s.last = time.Now()
+
+		u := *s.base
+		u.Path += "/" + collection
+		q := u.Query()
+		q.Set("page", strconv.Itoa(page))
+		q.Set("limit", strconv.Itoa(s.pageSize))
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+
+		var resp *http.Response
+		err = s.retry.do("api request", func() (err error) {
+			resp, err = s.client.Do(req)
+			if err == nil && resp.StatusCode >= 500 {
+				resp.Body.Close()
+				return &statusError{code: resp.StatusCode, status: resp.Status}
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %s page %d: %w", collection, page, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s page %d: %v", collection, page, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			if retryAfter <= 0 {
+				retryAfter = 1
+			}
+			select {
+			case <-time.After(time.Duration(retryAfter) * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error fetching %s page %d: %s", collection, page, resp.Status)
+		}
+
+		var docs []json.RawMessage
+		if err := json.Unmarshal(body, &docs); err != nil {
+			return nil, fmt.Errorf("error decoding %s page %d: %v", collection, page, err)
+		}
+		return docs, nil
+	}
+}
+
+// apiCursor buffers one page at a time.
+type apiCursor struct {
+	source     *apiSource
+	collection string
+	page       int
+	docs       []json.RawMessage
+	index      int
+	// skip is the number of documents to drop from the first page fetched
+	skip int
+	done bool
+	err  error
+}
+
+func (c *apiCursor) Next(ctx context.Context) bool {
+	if c.err != nil {
+		return false
+	}
+	c.index++
+	if c.index < len(c.docs) {
+		return true
+	}
+	if c.done {
+		return false
+	}
+
+	c.page++
+	docs, err := c.source.fetch(ctx, c.collection, c.page)
+	if err != nil {
+		c.err = err
+		return false
+	}
+	c.docs, c.index, c.skip = docs, c.skip, 0
+	if len(docs) < c.source.pageSize {
+		c.done = true
+	}
+	return c.index < len(docs)
+}
+
+func (c *apiCursor) Decode(v interface{}) error {
+	return json.Unmarshal(c.docs[c.index], v)
+}
+
+func (c *apiCursor) Raw() json.RawMessage {
+	return c.docs[c.index]
+}
+
+func (c *apiCursor) Detach() documentCursor {
+	return &document{data: c.docs[c.index], unmarshal: json.Unmarshal}
+}
+
+func (c *apiCursor) Err() error {
+	return c.err
+}
+
+func (c *apiCursor) Close(ctx context.Context) error {
+	return nil
+}
+
+// fileCursor reads documents from a file of extended JSON as written by
+// mongoexport: one document per line, or with --jsonArray a JSON array of
+// them.
+type fileCursor struct {
+	f       *os.File
+	scanner *bufio.Scanner
+	// array decodes the elements of a JSON array instead of lines
+	array *json.Decoder
+	line  []byte
+	err   error
+}
+
+func openFileCursor(path string) (*fileCursor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	if first, err := firstByte(r); err == nil && first == '[' {
+		array := json.NewDecoder(r)
+		if _, err := array.Token(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		return &fileCursor{f: f, array: array}, nil
+	}
+	scanner := bufio.NewScanner(r)
+	// Posts with many comments easily exceed the default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &fileCursor{f: f, scanner: scanner}, nil
+}
+
+// firstByte returns the first byte of r that isn't white space, leaving it
+// unread.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, r.UnreadByte()
+		}
+	}
+}
+
+func (c *fileCursor) Next(ctx context.Context) bool {
+	if c.array != nil {
+		if c.err != nil || !c.array.More() {
+			return false
+		}
+		var doc json.RawMessage
+		if c.err = c.array.Decode(&doc); c.err != nil {
+			return false
+		}
+		c.line = doc
+		return true
+	}
+	for c.scanner.Scan() {
+		if line := bytes.TrimSpace(c.scanner.Bytes()); len(line) > 0 {
+			c.line = line
+			return true
+		}
+	}
+	return false
+}
+
+func (c *fileCursor) Decode(v interface{}) error {
+	return bson.UnmarshalExtJSON(c.line, false, v)
+}
+
+func (c *fileCursor) Raw() json.RawMessage {
+	return json.RawMessage(c.line)
+}
+
+// Detach copies the current line, which the scanner overwrites.
+func (c *fileCursor) Detach() documentCursor {
+	return &document{data: append([]byte(nil), c.line...), unmarshal: unmarshalExtJSON}
+}
+
+func (c *fileCursor) Err() error {
+	if c.array != nil {
+		return c.err
+	}
+	return c.scanner.Err()
+}
+
+func (c *fileCursor) Close(ctx context.Context) error {
+	return c.f.Close()
+}
+
+// dirSource reads collections from a directory of mongoexport files, one
+// <collection>.json or <collection>.ndjson per collection, or from the
+// database directory of a mongodump, with <collection>.bson or .bson.gz files.
+type dirSource struct {
+	dir string
+}
+
+func newDirSource(dir string) (*dirSource, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("--source dir needs --source-dir")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening --source-dir: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("--source-dir %s is not a directory", dir)
+	}
+	return &dirSource{dir: dir}, nil
+}
+
+func (s *dirSource) Open(ctx context.Context, collection string, opts readOptions) (documentCursor, error) {
+	if len(opts.Sort) > 0 {
+		return nil, fmt.Errorf("the dump directory source can't read %s sorted", collection)
+	}
+	if opts.Filter != nil || opts.Projection != nil {
+		return nil, fmt.Errorf("the dump directory source can't filter or project %s", collection)
+	}
+	var cursor documentCursor
+	for _, ext := range []string{".json", ".ndjson", ".bson", ".bson.gz"} {
+		path := filepath.Join(s.dir, collection+ext)
+		var err error
+		if strings.HasPrefix(ext, ".bson") {
+			cursor, err = openBSONFile(path)
+		} else {
+			cursor, err = openFileCursor(path)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			cursor = nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %v", path, err)
+		}
+		break
+	}
+	if cursor == nil {
+		return nil, fmt.Errorf("no %s.json, .ndjson or .bson in %s", collection, s.dir)
+	}
+	// Files can't seek to a document, so a resumed collection reads up to it
+	for i := 0; i < opts.Skip && cursor.Next(ctx); i++ {
+	}
+	if err := cursor.Err(); err != nil {
+		cursor.Close(ctx)
+		return nil, fmt.Errorf("error reading %s: %v", collection, err)
+	}
+	return cursor, nil
+}
+
+func (s *dirSource) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package mongo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPISourceFetchRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		// want is the number of documents fetched, -1 for an error
+		want     int
+		requests int
+	}{
+		{"ok", []int{http.StatusOK}, 2, 1},
+		{"bad gateway", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, 2, 3},
+		{"gateway timeout", []int{http.StatusGatewayTimeout, http.StatusOK}, 2, 2},
+		{"out of retries", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, -1, 3},
+		{"not found", []int{http.StatusNotFound, http.StatusOK}, -1, 1},
+	}
+	for _, tt := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := tt.statuses[requests]
+			requests++
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				w.Write([]byte(`[{"_id": "a"}, {"_id": "b"}]`))
+			}
+		}))
+		ctx := context.Background()
+		s, err := newAPISource(server.URL, "", 10, 0, newRetrier(ctx, 2, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs, err := s.fetch(ctx, "users", 1)
+		server.Close()
+		switch {
+		case tt.want < 0 && err == nil:
+			t.Errorf("%s: fetched %d document(s), want an error", tt.name, len(docs))
+		case tt.want >= 0 && (err != nil || len(docs) != tt.want):
+			t.Errorf("%s: fetched %d document(s), %v, want %d", tt.name, len(docs), err, tt.want)
+		}
+		if requests != tt.requests {
+			t.Errorf("%s: %d request(s), want %d", tt.name, requests, tt.requests)
+		}
+		if tt.name == "out of retries" && (err == nil || !strings.Contains(err.Error(), "502 Bad Gateway")) {
+			t.Errorf("%s: error = %v, want the last status", tt.name, err)
+		}
+	}
+}
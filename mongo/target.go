@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// targetDB is the database documents are migrated into. The migration
+// prepares and writes it only through these methods, so the SQL dialect stays
+// in one place and another database needs an implementation, with schema
+// migrations of its own, instead of changes to the transfer code.
+type targetDB interface {
+	// CreateSchema applies the pending schema migrations, noting them in changes.
+	CreateSchema(changes *schemaChangelog) error
+	// Exists reports whether table is in the database.
+	Exists(table string) (bool, error)
+	// Insert, Upsert and CopyBatch write through ex, the database itself or
+	// the transaction a batch of documents is written in.
+	Insert(ex sqlExecutor, row tableRow) error
+	// Upsert overwrites the columns of the row with the same key, if any.
+	Upsert(ex sqlExecutor, row tableRow) error
+	// CopyBatch inserts rows of one table and column list at once.
+	CopyBatch(ex sqlExecutor, rows []tableRow) error
+}
+
+// sqlExecutor is what *sql.DB and *sql.Tx have in common for writing.
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// mysqlTarget writes to MySQL or MariaDB.
+type mysqlTarget struct {
+	db *sql.DB
+}
+
+func (t *mysqlTarget) CreateSchema(changes *schemaChangelog) error {
+	return schemaUp(t.db, 0, changes)
+}
+
+func (t *mysqlTarget) Exists(table string) (bool, error) {
+	return tableExists(t.db, table)
+}
+
+func (t *mysqlTarget) Insert(ex sqlExecutor, row tableRow) error {
+	_, err := ex.Exec(t.insertStatement(row.Table, row.Columns, 1), row.Values...)
+	return err
+}
+
+func (t *mysqlTarget) Upsert(ex sqlExecutor, row tableRow) error {
+	updates := make([]string, len(row.Columns))
+	for i, col := range row.Columns {
+		updates[i] = fmt.Sprintf("`%s` = VALUES(`%s`)", col, col)
+	}
+	query := t.insertStatement(row.Table, row.Columns, 1) + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	_, err := ex.Exec(query, row.Values...)
+	return err
+}
+
+func (t *mysqlTarget) CopyBatch(ex sqlExecutor, rows []tableRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(rows)*len(rows[0].Columns))
+	for _, row := range rows {
+		args = append(args, row.Values...)
+	}
+	_, err := ex.Exec(t.insertStatement(rows[0].Table, rows[0].Columns, len(rows)), args...)
+	return err
+}
+
+// insertStatement returns an INSERT of n rows into table.
+func (t *mysqlTarget) insertStatement(table string, columns []string, n int) string {
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = "`" + col + "`"
+		placeholders[i] = "?"
+	}
+	values := make([]string, n)
+	for i := range values {
+		values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	return fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s", table, strings.Join(quoted, ", "), strings.Join(values, ", "))
+}
@@ -0,0 +1,180 @@
+package mongo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/urfave/cli/v2"
+)
+
+// mysqlTLSName is the name the MySQL TLS settings are registered with the
+// driver under, for the tls parameter of the DSNs.
+const mysqlTLSName = "cli-tools"
+
+// tlsFlags set up TLS to both databases for every command, on top of what
+// their connection strings say.
+var tlsFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "mysql-tls",
+		EnvVars: []string{"MYSQL_TLS"},
+		Usage:   "TLS to MySQL: disabled, preferred, required (or skip-verify: encrypted, unverified), verify-ca or verify-identity (default with a CA or client certificate)",
+	},
+	&cli.StringFlag{
+		Name:    "mysql-tls-ca",
+		EnvVars: []string{"MYSQL_TLS_CA"},
+		Usage:   "PEM file of the CA certificates MySQL's certificate is verified against, in place of the system's",
+	},
+	&cli.StringFlag{
+		Name:    "mysql-tls-cert",
+		EnvVars: []string{"MYSQL_TLS_CERT"},
+		Usage:   "PEM file of the client certificate presented to MySQL",
+	},
+	&cli.StringFlag{
+		Name:    "mysql-tls-key",
+		EnvVars: []string{"MYSQL_TLS_KEY"},
+		Usage:   "PEM file of the key of --mysql-tls-cert",
+	},
+	&cli.StringFlag{
+		Name:    "mongodb-tls-ca",
+		EnvVars: []string{"MONGODB_TLS_CA"},
+		Usage:   "PEM file of the CA certificates MongoDB's certificate is verified against, in place of the system's",
+	},
+	&cli.StringFlag{
+		Name:    "mongodb-tls-cert",
+		EnvVars: []string{"MONGODB_TLS_CERT"},
+		Usage:   "PEM file of the client certificate presented to MongoDB, e.g. for X.509 authentication",
+	},
+	&cli.StringFlag{
+		Name:    "mongodb-tls-key",
+		EnvVars: []string{"MONGODB_TLS_KEY"},
+		Usage:   "PEM file of the key of --mongodb-tls-cert, which may also hold both",
+	},
+	&cli.BoolFlag{
+		Name:    "mongodb-tls-insecure",
+		EnvVars: []string{"MONGODB_TLS_INSECURE"},
+		Usage:   "don't verify MongoDB's certificate or host name",
+	},
+}
+
+// mysqlTLS is the tls parameter set on every MySQL DSN, empty to leave the
+// DSN's own.
+var mysqlTLS string
+
+// mongoTLS replaces the TLS settings of the MongoDB connection string when set.
+var mongoTLS *tls.Config
+
+// applyTLS reads the TLS flags into mysqlTLS and mongoTLS.
+func applyTLS(c *cli.Context) error {
+	mode := c.String("mysql-tls")
+	ca, cert, key := c.String("mysql-tls-ca"), c.String("mysql-tls-cert"), c.String("mysql-tls-key")
+	if mode == "" && (ca != "" || cert != "" || key != "") {
+		mode = "verify-identity"
+	}
+	switch mode {
+	case "":
+	case "disabled", "preferred":
+		if ca != "" || cert != "" || key != "" {
+			return fmt.Errorf("--mysql-tls %s doesn't use --mysql-tls-ca or --mysql-tls-cert", mode)
+		}
+		mysqlTLS = map[string]string{"disabled": "false", "preferred": "preferred"}[mode]
+	case "required", "skip-verify", "verify-ca", "verify-identity":
+		cfg, err := tlsConfig("mysql", ca, cert, key)
+		if err != nil {
+			return err
+		}
+		switch mode {
+		case "required", "skip-verify":
+			cfg.InsecureSkipVerify = true
+		case "verify-ca":
+			// The chain is checked, not the host name, as MySQL's VERIFY_CA does
+			cfg.InsecureSkipVerify = true
+			cfg.VerifyConnection = verifyChain(cfg.RootCAs)
+		}
+		if err := mysql.RegisterTLSConfig(mysqlTLSName, cfg); err != nil {
+			return err
+		}
+		mysqlTLS = mysqlTLSName
+	default:
+		return fmt.Errorf("unknown --mysql-tls %q, expected disabled, preferred, required, skip-verify, verify-ca or verify-identity", mode)
+	}
+
+	ca, cert, key = c.String("mongodb-tls-ca"), c.String("mongodb-tls-cert"), c.String("mongodb-tls-key")
+	if ca != "" || cert != "" || key != "" || c.Bool("mongodb-tls-insecure") {
+		cfg, err := tlsConfig("mongodb", ca, cert, key)
+		if err != nil {
+			return err
+		}
+		cfg.InsecureSkipVerify = c.Bool("mongodb-tls-insecure")
+		mongoTLS = cfg
+	}
+	return nil
+}
+
+// tlsConfig loads the CA and client certificate files given for db, the
+// client certificate's key from key or else from cert itself.
+func tlsConfig(db, ca, cert, key string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		data, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("error reading --%s-tls-ca: %v", db, err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("--%s-tls-ca %s holds no PEM certificate", db, ca)
+		}
+	}
+	if key != "" && cert == "" {
+		return nil, fmt.Errorf("--%s-tls-key needs --%s-tls-cert", db, db)
+	}
+	if cert != "" {
+		if key == "" {
+			key = cert
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the --%s-tls-cert client certificate: %v", db, err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// verifyChain checks the server's certificate chain against roots, the
+// system's when nil, whatever host name it is for.
+func verifyChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("the server presented no certificate")
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// mysqlDSN sets the --mysql-tls settings on uri, and has it dial through the
+// --ssh tunnel.
+func mysqlDSN(uri string) (string, error) {
+	ssh := tunneled["mysql"]
+	if mysqlTLS == "" && !ssh {
+		return uri, nil
+	}
+	cfg, err := mysql.ParseDSN(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid MYSQL_URI: %v", err)
+	}
+	if mysqlTLS != "" {
+		cfg.TLSConfig = mysqlTLS
+	}
+	if ssh && cfg.Net == "tcp" {
+		cfg.Net = sshNet
+	}
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,108 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+)
+
+// txMark is where a collection stood at its last commit.
+type txMark struct {
+	migrated, skipped, failed int
+	quarantined               int64
+}
+
+func validTxPer(txPer string) error {
+	switch txPer {
+	case "row", "batch", "collection":
+		return nil
+	}
+	return fmt.Errorf("unknown --tx-per %q, expected row, batch or collection", txPer)
+}
+
+// transactional reports whether rows are written in transactions that cover
+// more than one statement.
+func (m *migrator) transactional() bool {
+	return (m.txPer == "batch" || m.txPer == "collection") && !m.dryRun
+}
+
+// insertDocument inserts the rows of one document. Inside a transaction a
+// document that fails or is cut short by ctx is rolled back to where it
+// started, so none of its rows stay behind when it is quarantined or the
+// batch is committed on stopping.
+func (m *migrator) insertDocument(ctx context.Context, rows []tableRow) error {
+	if !m.transactional() {
+		return m.insertRows(ctx, rows)
+	}
+	if m.tx == nil {
+		tx, err := m.mysqlDB.Begin()
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %w", err)
+		}
+		m.tx = tx
+	}
+	if _, err := m.tx.Exec("SAVEPOINT document"); err != nil {
+		return err
+	}
+	err := m.insertRows(ctx, rows)
+	if err != nil && (!isTransient(err) || ctx.Err() != nil) {
+		if _, rbErr := m.tx.Exec("ROLLBACK TO SAVEPOINT document"); rbErr != nil {
+			return rbErr
+		}
+	}
+	return err
+}
+
+// mark records the collection's counts as committed.
+func (m *migrator) mark(collection string) {
+	if m.committed == nil {
+		m.committed = map[string]txMark{}
+	}
+	m.committed[collection] = txMark{
+		migrated:    m.migrated[collection],
+		skipped:     m.skipped[collection],
+		failed:      m.failed.counts[collection],
+		quarantined: m.failed.size(collection),
+	}
+}
+
+// commit makes the documents transferred since the last commit visible.
+func (m *migrator) commit(collection string) error {
+	if m.tx != nil {
+		err := m.tx.Commit()
+		m.tx = nil
+		if err != nil {
+			m.restore(collection)
+			return fmt.Errorf("error committing %s: %w", collection, err)
+		}
+	}
+	m.mark(collection)
+	return m.applyLedger()
+}
+
+// rollback drops the documents transferred since the last commit, so the
+// collection resumes from there.
+func (m *migrator) rollback(collection string) {
+	if !m.transactional() {
+		return
+	}
+	if m.tx != nil {
+		// A broken connection has rolled the transaction back already
+		m.tx.Rollback()
+		m.tx = nil
+	}
+	m.restore(collection)
+}
+
+// restore puts the collection's counts and quarantine file back to the last
+// commit, dropping the ledgers of the documents since.
+func (m *migrator) restore(collection string) {
+	mark := m.committed[collection]
+	m.unsettled = nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrated[collection] = mark.migrated
+	m.skipped[collection] = mark.skipped
+	if err := m.failed.rewind(collection, mark.failed, mark.quarantined); err != nil {
+		logf(levelWarn, "%v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package mongo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// fieldTransform rewrites the text values of one column before they are
+// written, whichever collection the rows come from, with either a registered
+// func or a template.
+type fieldTransform struct {
+	Table  string            `json:"table" doc:"MySQL table the column belongs to, e.g. users"`
+	Column string            `json:"column" doc:"column whose text values are rewritten, e.g. profile_picture"`
+	Func   string            `json:"func" doc:"registered transform: lowercase, uppercase, trim, replace-prefix, replace or regexp"`
+	Args   map[string]string `json:"args" doc:"arguments of the transform, e.g. from and to for replace-prefix"`
+	// Template is the simpler alternative to Func for one-off rewrites
+	Template string `json:"template" doc:"Go template computing the new value, with the old one as dot; instead of func"`
+
+	apply func(string) (string, error)
+}
+
+// transformFuncs are the transforms a fieldTransform may name. Each builds the
+// rewrite from the transform's args, so bad arguments are reported when the
+// config loads rather than halfway through a run. Custom transforms are added
+// here.
+var transformFuncs = map[string]func(args map[string]string) (func(string) string, error){
+	"lowercase": func(args map[string]string) (func(string) string, error) {
+		return strings.ToLower, nil
+	},
+	"uppercase": func(args map[string]string) (func(string) string, error) {
+		return strings.ToUpper, nil
+	},
+	"trim": func(args map[string]string) (func(string) string, error) {
+		return strings.TrimSpace, nil
+	},
+	// replace-prefix swaps a leading from for to, e.g. an old CDN's base URL for the new one's
+	"replace-prefix": func(args map[string]string) (func(string) string, error) {
+		from, to := args["from"], args["to"]
+		if from == "" {
+			return nil, fmt.Errorf("needs from")
+		}
+		return func(s string) string {
+			if strings.HasPrefix(s, from) {
+				return to + s[len(from):]
+			}
+			return s
+		}, nil
+	},
+	"replace": func(args map[string]string) (func(string) string, error) {
+		old, replacement := args["old"], args["new"]
+		if old == "" {
+			return nil, fmt.Errorf("needs old")
+		}
+		return func(s string) string {
+			return strings.ReplaceAll(s, old, replacement)
+		}, nil
+	},
+	// regexp replaces every match of pattern, replacement may use $1 for groups
+	"regexp": func(args map[string]string) (func(string) string, error) {
+		if args["pattern"] == "" {
+			return nil, fmt.Errorf("needs pattern")
+		}
+		pattern, err := regexp.Compile(args["pattern"])
+		if err != nil {
+			return nil, err
+		}
+		replacement := args["replacement"]
+		return func(s string) string {
+			return pattern.ReplaceAllString(s, replacement)
+		}, nil
+	},
+}
+
+// templateFuncs are the functions a transform's template may call, besides
+// the text/template builtins. The value being rewritten is passed as dot.
+var templateFuncs = template.FuncMap{
+	"replace":    func(s, old, new string) string { return strings.ReplaceAll(s, old, new) },
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(s, prefix string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(s, suffix string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   strings.Contains,
+}
+
+func (t *fieldTransform) validate() error {
+	if !identifierPattern.MatchString(t.Table) || !identifierPattern.MatchString(t.Column) {
+		return fmt.Errorf("transform %s.%s: invalid table or column name", t.Table, t.Column)
+	}
+	switch {
+	case t.Template != "" && t.Func != "":
+		return fmt.Errorf("transform %s.%s: both func and template", t.Table, t.Column)
+	case t.Template != "":
+		tmpl, err := template.New(t.Table + "." + t.Column).Funcs(templateFuncs).Option("missingkey=error").Parse(t.Template)
+		if err != nil {
+			return fmt.Errorf("transform %s.%s: %v", t.Table, t.Column, err)
+		}
+		t.apply = func(s string) (string, error) {
+			var b strings.Builder
+			err := tmpl.Execute(&b, s)
+			return b.String(), err
+		}
+		return nil
+	}
+	build, ok := transformFuncs[t.Func]
+	if !ok {
+		names := make([]string, 0, len(transformFuncs))
+		for name := range transformFuncs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("transform %s.%s: unknown func %q, expected one of %s", t.Table, t.Column, t.Func, strings.Join(names, ", "))
+	}
+	apply, err := build(t.Args)
+	if err != nil {
+		return fmt.Errorf("transform %s.%s: %s: %v", t.Table, t.Column, t.Func, err)
+	}
+	t.apply = func(s string) (string, error) {
+		return apply(s), nil
+	}
+	return nil
+}
+
+// applyTransforms rewrites the string values of rows in place, running the
+// transforms of a column in the order the config lists them. A template that
+// fails on a value fails the document.
+func applyTransforms(transforms []*fieldTransform, rows []tableRow) error {
+	for _, t := range transforms {
+		for _, row := range rows {
+			if row.Table != t.Table {
+				continue
+			}
+			for i, col := range row.Columns {
+				text, ok := row.Values[i].(string)
+				if !ok || col != t.Column {
+					continue
+				}
+				rewritten, err := t.apply(text)
+				if err != nil {
+					return fmt.Errorf("error transforming %s.%s: %v", t.Table, t.Column, err)
+				}
+				row.Values[i] = rewritten
+			}
+		}
+	}
+	return nil
+}
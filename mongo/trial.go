@@ -0,0 +1,132 @@
+package mongo
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// trialTarget is the throwaway database a --trial run migrates into, on the
+// same server as the real target.
+type trialTarget struct {
+	name   string
+	real   string
+	uri    string
+	server *sql.DB
+}
+
+// newTrialTarget creates a trial_<timestamp> database next to the one uri
+// points at and returns the URI to migrate into instead.
+func newTrialTarget(uri string, retry *retrier) (*trialTarget, error) {
+	cfg, err := mysql.ParseDSN(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MYSQL_URI: %v", err)
+	}
+	real := cfg.DBName
+	if real == "" {
+		return nil, fmt.Errorf("--trial needs a database name in MYSQL_URI to compare against")
+	}
+	name := "trial_" + time.Now().UTC().Format("20060102T150405")
+
+	cfg.DBName = ""
+	server := connectMySQL(cfg.FormatDSN(), retry)
+	if _, err := server.Exec("CREATE DATABASE `" + name + "`"); err != nil {
+		server.Close()
+		return nil, fmt.Errorf("error creating trial database %s: %v", name, err)
+	}
+	logf(levelInfo, "Trial run: migrating into %s instead of %s", name, real)
+
+	cfg.DBName = name
+	return &trialTarget{name: name, real: real, uri: cfg.FormatDSN(), server: server}, nil
+}
+
+// compare prints the row count of every table in the trial database next to
+// the real target's, and the orphaned references the trial data has.
+func (t *trialTarget) compare() error {
+	rows, err := t.server.Query("SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME <> 'schema_migrations' ORDER BY TABLE_NAME", t.name)
+	if err != nil {
+		return fmt.Errorf("error listing trial tables: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\tTRIAL (%s)\tTARGET (%s)\tDIFFERENCE\n", t.name, t.real)
+	for _, table := range tables {
+		trialRows, err := t.count(t.name, table)
+		if err != nil {
+			return err
+		}
+		var exists int
+		err = t.server.QueryRow("SELECT count(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", t.real, table).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error looking up table %s: %v", table, err)
+		}
+		if exists == 0 {
+			fmt.Fprintf(w, "%s\t%d\t-\tnew table\n", table, trialRows)
+			continue
+		}
+		targetRows, err := t.count(t.real, table)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%+d\n", table, trialRows, targetRows, trialRows-targetRows)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	trialDB, err := sqlOpen(t.uri)
+	if err != nil {
+		return err
+	}
+	defer trialDB.Close()
+	for _, fk := range foreignKeys {
+		count, _, err := orphans(trialDB, fk, 0)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			logf(levelWarn, "Trial data has %d orphaned row(s) for %s", count, fk)
+		}
+	}
+	return nil
+}
+
+func (t *trialTarget) count(database, table string) (int, error) {
+	var n int
+	err := t.server.QueryRow(fmt.Sprintf("SELECT count(*) FROM `%s`.`%s`", database, table)).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("error counting %s.%s: %v", database, table, err)
+	}
+	return n, nil
+}
+
+// Close drops the trial database unless keep is set.
+func (t *trialTarget) Close(keep bool) error {
+	defer t.server.Close()
+	if keep {
+		logf(levelInfo, "Trial database %s kept, drop it with DROP DATABASE `%s`", t.name, t.name)
+		return nil
+	}
+	if _, err := t.server.Exec("DROP DATABASE `" + t.name + "`"); err != nil {
+		return fmt.Errorf("error dropping trial database %s: %v", t.name, err)
+	}
+	logf(levelInfo, "Dropped trial database %s", t.name)
+	return nil
+}
@@ -0,0 +1,191 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshNet is the network MySQL DSNs dial over when they go through the tunnel.
+const sshNet = "ssh"
+
+// sshFlags route the database connections of every command through an SSH
+// bastion, for databases that only listen on a private network.
+var sshFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "ssh",
+		EnvVars: []string{"CLI_TOOLS_SSH"},
+		Usage:   "user@bastion[:port] the database connections are tunneled through",
+	},
+	&cli.StringFlag{
+		Name:    "ssh-key",
+		EnvVars: []string{"CLI_TOOLS_SSH_KEY"},
+		Usage:   "private key file for the bastion, on top of the keys of the SSH agent",
+	},
+	&cli.StringFlag{
+		Name:    "ssh-key-passphrase",
+		EnvVars: []string{"CLI_TOOLS_SSH_KEY_PASSPHRASE"},
+		Usage:   "passphrase of --ssh-key",
+	},
+	&cli.StringFlag{
+		Name:    "ssh-known-hosts",
+		EnvVars: []string{"CLI_TOOLS_SSH_KNOWN_HOSTS"},
+		Usage:   "known_hosts file the bastion's host key is checked against (default ~/.ssh/known_hosts)",
+	},
+	&cli.StringFlag{
+		Name:  "ssh-databases",
+		Value: "mysql,mongodb",
+		Usage: "comma separated databases reached through the tunnel: mysql, mongodb or both",
+	},
+}
+
+// tunnel is the bastion connections go through, nil without --ssh. Each
+// database reachable through it is listed in tunneled.
+var (
+	tunnel   *sshTunnel
+	tunneled = map[string]bool{}
+)
+
+// sshTunnel dials through an SSH connection to a bastion, opened on the first
+// dial and reopened when it breaks.
+type sshTunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// applySSH sets up the tunnel --ssh asks for.
+func applySSH(c *cli.Context) error {
+	target := c.String("ssh")
+	if target == "" {
+		return nil
+	}
+	for _, db := range strings.Split(c.String("ssh-databases"), ",") {
+		db = strings.TrimSpace(db)
+		if db != "mysql" && db != "mongodb" {
+			return fmt.Errorf("invalid --ssh-databases %q, expected mysql, mongodb or both", c.String("ssh-databases"))
+		}
+		tunneled[db] = true
+	}
+
+	login, host, ok := strings.Cut(target, "@")
+	if !ok {
+		host, login = target, ""
+		if u, err := user.Current(); err == nil {
+			login = u.Username
+		}
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	var auth []ssh.AuthMethod
+	if path := c.String("ssh-key"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading --ssh-key: %v", err)
+		}
+		var signer ssh.Signer
+		if passphrase := c.String("ssh-key-passphrase"); passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading --ssh-key %s: %v", path, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		} else {
+			logf(levelWarn, "Not using the SSH agent: %v", err)
+		}
+	}
+	if len(auth) == 0 {
+		return fmt.Errorf("--ssh needs --ssh-key or an SSH agent")
+	}
+
+	knownHosts := c.String("ssh-known-hosts")
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("error locating ~/.ssh/known_hosts: %v", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return fmt.Errorf("error reading the known hosts: %v", err)
+	}
+
+	tunnel = &sshTunnel{
+		addr: host,
+		config: &ssh.ClientConfig{
+			User:            login,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         15 * time.Second,
+		},
+	}
+	mysql.RegisterDialContext(sshNet, func(ctx context.Context, addr string) (net.Conn, error) {
+		return tunnel.DialContext(ctx, "tcp", addr)
+	})
+	logf(levelDebug, "Tunneling %s through %s@%s", c.String("ssh-databases"), login, host)
+	return nil
+}
+
+// DialContext opens a connection to addr from the bastion.
+func (t *sshTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := t.connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, network, addr)
+	if err != nil && ctx.Err() == nil {
+		// The bastion may have dropped the connection; reconnect once
+		if client, err = t.connect(client); err != nil {
+			return nil, err
+		}
+		conn, err = client.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error tunneling to %s through %s: %v", addr, t.addr, err)
+	}
+	return conn, nil
+}
+
+// connect returns the SSH connection to the bastion, opening a new one when
+// there is none yet or the current one is stale.
+func (t *sshTunnel) connect(stale *ssh.Client) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil && t.client == stale {
+		t.client.Close()
+		t.client = nil
+	}
+	if t.client == nil {
+		client, err := ssh.Dial("tcp", t.addr, t.config)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to SSH bastion %s: %v", t.addr, err)
+		}
+		logf(levelInfo, "SSH tunnel to %s open", t.addr)
+		t.client = client
+	}
+	return t.client, nil
+}
@@ -0,0 +1,265 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// validationRule checks the values of one column before they are written.
+// Every rule but nonempty lets empty values through, so optional columns
+// only need the rule for their format.
+type validationRule struct {
+	Table  string            `json:"table" doc:"MySQL table the column belongs to, e.g. users"`
+	Column string            `json:"column" doc:"column whose values are checked, e.g. email"`
+	Rule   string            `json:"rule" doc:"registered check: nonempty, length, email, url or pattern"`
+	Args   map[string]string `json:"args" doc:"arguments of the check, e.g. min and max for length"`
+
+	check func(string) error
+}
+
+// validationChecks are the checks a validationRule may name. Each builds the
+// check from the rule's args, so bad arguments are reported when the config
+// loads.
+var validationChecks = map[string]func(args map[string]string) (func(string) error, error){
+	"nonempty": func(args map[string]string) (func(string) error, error) {
+		return func(s string) error {
+			if strings.TrimSpace(s) == "" {
+				return fmt.Errorf("empty")
+			}
+			return nil
+		}, nil
+	},
+	// length counts characters, not bytes; either bound may be left out
+	"length": func(args map[string]string) (func(string) error, error) {
+		bound := func(name string) (int, error) {
+			if args[name] == "" {
+				return -1, nil
+			}
+			n, err := strconv.Atoi(args[name])
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid %s %q", name, args[name])
+			}
+			return n, nil
+		}
+		min, err := bound("min")
+		if err != nil {
+			return nil, err
+		}
+		max, err := bound("max")
+		if err != nil {
+			return nil, err
+		}
+		if min < 0 && max < 0 {
+			return nil, fmt.Errorf("needs min or max")
+		}
+		return func(s string) error {
+			n := utf8.RuneCountInString(s)
+			if n < min || (max >= 0 && n > max) {
+				return fmt.Errorf("%d characters long", n)
+			}
+			return nil
+		}, nil
+	},
+	"email": func(args map[string]string) (func(string) error, error) {
+		return func(s string) error {
+			addr, err := mail.ParseAddress(s)
+			if err != nil || addr.Address != s || !strings.Contains(s[strings.LastIndex(s, "@"):], ".") {
+				return fmt.Errorf("not an email address")
+			}
+			return nil
+		}, nil
+	},
+	// url takes absolute URLs of the schemes listed in its schemes arg, http and https by default
+	"url": func(args map[string]string) (func(string) error, error) {
+		schemes := map[string]bool{"http": true, "https": true}
+		if args["schemes"] != "" {
+			schemes = map[string]bool{}
+			for _, scheme := range strings.Split(args["schemes"], ",") {
+				schemes[strings.TrimSpace(scheme)] = true
+			}
+		}
+		return func(s string) error {
+			u, err := url.Parse(s)
+			if err != nil || u.Host == "" || !schemes[u.Scheme] {
+				return fmt.Errorf("not a valid URL")
+			}
+			return nil
+		}, nil
+	},
+	"pattern": func(args map[string]string) (func(string) error, error) {
+		if args["pattern"] == "" {
+			return nil, fmt.Errorf("needs pattern")
+		}
+		pattern, err := regexp.Compile(args["pattern"])
+		if err != nil {
+			return nil, err
+		}
+		return func(s string) error {
+			if !pattern.MatchString(s) {
+				return fmt.Errorf("doesn't match %s", pattern)
+			}
+			return nil
+		}, nil
+	},
+}
+
+// defaultValidationRules are checked when the config lists no validations.
+var defaultValidationRules = []*validationRule{
+	{Table: "users", Column: "username", Rule: "nonempty"},
+	{Table: "users", Column: "username", Rule: "length", Args: map[string]string{"min": "2", "max": "32"}},
+	{Table: "users", Column: "email", Rule: "email"},
+	{Table: "users", Column: "profile_picture", Rule: "url"},
+	{Table: "users", Column: "profile_banner", Rule: "url"},
+	{Table: "partners", Column: "link", Rule: "url"},
+	{Table: "blogs", Column: "slug", Rule: "nonempty"},
+}
+
+func (r *validationRule) validate() error {
+	if !identifierPattern.MatchString(r.Table) || !identifierPattern.MatchString(r.Column) {
+		return fmt.Errorf("validation %s.%s: invalid table or column name", r.Table, r.Column)
+	}
+	build, ok := validationChecks[r.Rule]
+	if !ok {
+		names := make([]string, 0, len(validationChecks))
+		for name := range validationChecks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("validation %s.%s: unknown rule %q, expected one of %s", r.Table, r.Column, r.Rule, strings.Join(names, ", "))
+	}
+	check, err := build(r.Args)
+	if err != nil {
+		return fmt.Errorf("validation %s.%s: %s: %v", r.Table, r.Column, r.Rule, err)
+	}
+	r.check = check
+	return nil
+}
+
+// validator checks every row about to be written against the rules and lists
+// the values that fail in an invalid-values file. With refuse set, documents
+// with an invalid value are quarantined instead of written. A nil *validator
+// checks nothing.
+type validator struct {
+	rules  []*validationRule
+	refuse bool
+	path   string
+	report *os.File
+	enc    *json.Encoder
+	counts map[string]int
+	// refused counts the documents quarantined for invalid values
+	refused int
+}
+
+// invalidValue is one line of the invalid-values file. The value itself is
+// left out, as it is often personal data; Row leads to it.
+type invalidValue struct {
+	Collection string `json:"collection"`
+	Table      string `json:"table"`
+	Column     string `json:"column"`
+	Row        string `json:"row"`
+	Rule       string `json:"rule"`
+	Error      string `json:"error"`
+}
+
+func newValidator(rules []*validationRule, refuse bool, path string) (*validator, error) {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating invalid values file: %v", err)
+	}
+	return &validator{rules: rules, refuse: refuse, path: path, report: f, enc: json.NewEncoder(f), counts: map[string]int{}}, nil
+}
+
+// check applies the rules to the rows of one document of collection,
+// recording the invalid values in l.
+func (v *validator) check(collection string, rows []tableRow, l *ledger) error {
+	if v == nil {
+		return nil
+	}
+	var invalid []string
+	for _, rule := range v.rules {
+		for _, row := range rows {
+			if row.Table != rule.Table {
+				continue
+			}
+			for i, col := range row.Columns {
+				if col != rule.Column {
+					continue
+				}
+				text, ok := row.Values[i].(string)
+				if !ok && row.Values[i] != nil {
+					continue
+				}
+				if text == "" && rule.Rule != "nonempty" {
+					continue
+				}
+				err := rule.check(text)
+				if err == nil {
+					continue
+				}
+				name := rule.Table + "." + rule.Column + " " + rule.Rule
+				invalid = append(invalid, name)
+				finding := invalidValue{Collection: collection, Table: row.Table, Column: col, Row: rowKey(row), Rule: rule.Rule, Error: err.Error()}
+				l.add(func() error {
+					v.counts[name]++
+					if err := v.enc.Encode(finding); err != nil {
+						return fmt.Errorf("error writing %s: %v", v.path, err)
+					}
+					return nil
+				})
+			}
+		}
+	}
+	if len(invalid) == 0 || !v.refuse {
+		return nil
+	}
+	l.add(func() error {
+		v.refused++
+		return nil
+	})
+	return fmt.Errorf("invalid value(s): %s", strings.Join(invalid, ", "))
+}
+
+// refusedCount returns how many documents were quarantined for invalid values.
+func (v *validator) refusedCount() int {
+	if v == nil {
+		return 0
+	}
+	return v.refused
+}
+
+// summary logs how many values failed each rule.
+func (v *validator) summary() {
+	if v == nil || len(v.counts) == 0 {
+		return
+	}
+	names := make([]string, 0, len(v.counts))
+	for name := range v.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s (%d)", name, v.counts[name])
+	}
+	logf(levelWarn, "Invalid values: %s, see %s", strings.Join(parts, ", "), v.path)
+}
+
+func (v *validator) Close() error {
+	if v == nil {
+		return nil
+	}
+	return v.report.Close()
+}
@@ -0,0 +1,109 @@
+package mongo
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidationChecks(t *testing.T) {
+	tests := []struct {
+		rule  string
+		args  map[string]string
+		value string
+		valid bool
+	}{
+		{"nonempty", nil, "a", true},
+		{"nonempty", nil, " \t", false},
+		{"length", map[string]string{"min": "2", "max": "4"}, "ab", true},
+		{"length", map[string]string{"min": "2", "max": "4"}, "a", false},
+		{"length", map[string]string{"min": "2", "max": "4"}, "abcde", false},
+		// Characters, not bytes
+		{"length", map[string]string{"max": "4"}, "éééé", true},
+		{"length", map[string]string{"min": "3"}, strings.Repeat("x", 100), true},
+		{"email", nil, "someone@example.com", true},
+		{"email", nil, "Someone <someone@example.com>", false},
+		{"email", nil, "someone@localhost", false},
+		{"email", nil, "someone", false},
+		{"url", nil, "https://example.com/a", true},
+		{"url", nil, "http://example.com", true},
+		{"url", nil, "example.com", false},
+		{"url", nil, "ftp://example.com", false},
+		{"url", map[string]string{"schemes": "ftp, https"}, "ftp://example.com", true},
+		{"url", map[string]string{"schemes": "ftp"}, "https://example.com", false},
+		{"pattern", map[string]string{"pattern": "^[a-z0-9-]+$"}, "my-blog", true},
+		{"pattern", map[string]string{"pattern": "^[a-z0-9-]+$"}, "My Blog", false},
+	}
+	for _, tt := range tests {
+		check, err := validationChecks[tt.rule](tt.args)
+		if err != nil {
+			t.Fatalf("%s %v: %v", tt.rule, tt.args, err)
+		}
+		if err := check(tt.value); (err == nil) != tt.valid {
+			t.Errorf("%s %v on %q = %v, want valid %v", tt.rule, tt.args, tt.value, err, tt.valid)
+		}
+	}
+}
+
+func TestValidationRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule *validationRule
+		want string
+	}{
+		{&validationRule{Table: "users", Column: "email", Rule: "phone"}, `unknown rule "phone", expected one of email, length, nonempty, pattern, url`},
+		{&validationRule{Table: "users;", Column: "email", Rule: "email"}, "invalid table or column name"},
+		{&validationRule{Table: "users", Column: "username", Rule: "length"}, "length: needs min or max"},
+		{&validationRule{Table: "users", Column: "username", Rule: "length", Args: map[string]string{"min": "-1"}}, `invalid min "-1"`},
+		{&validationRule{Table: "users", Column: "username", Rule: "length", Args: map[string]string{"max": "many"}}, `invalid max "many"`},
+		{&validationRule{Table: "blogs", Column: "slug", Rule: "pattern"}, "pattern: needs pattern"},
+		{&validationRule{Table: "blogs", Column: "slug", Rule: "pattern", Args: map[string]string{"pattern": "("}}, "missing closing )"},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s.%s %s: error = %v, want one containing %q", tt.rule.Table, tt.rule.Column, tt.rule.Rule, err, tt.want)
+		}
+	}
+	for _, rule := range defaultValidationRules {
+		if err := rule.validate(); err != nil {
+			t.Errorf("default rule: %v", err)
+		}
+	}
+}
+
+func TestValidatorCheck(t *testing.T) {
+	for _, refuse := range []bool{false, true} {
+		v, err := newValidator(defaultValidationRules, refuse, filepath.Join(t.TempDir(), "invalid.ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := []tableRow{{
+			Table:   "users",
+			Columns: []string{"id", "username", "email", "profile_picture"},
+			// Empty values only fail nonempty
+			Values: []interface{}{"u1", "", "not an address", ""},
+		}}
+		l := &ledger{}
+		err = v.check("users", rows, l)
+		if refuse != (err != nil) {
+			t.Errorf("refuse %v: check = %v", refuse, err)
+		}
+		if len(v.counts) != 0 || v.refusedCount() != 0 {
+			t.Errorf("refuse %v: counted before settling", refuse)
+		}
+		if err := (&migrator{}).settle(l); err != nil {
+			t.Fatal(err)
+		}
+		v.Close()
+		want := map[string]int{"users.username nonempty": 1, "users.email email": 1}
+		if !reflect.DeepEqual(v.counts, want) {
+			t.Errorf("refuse %v: counts = %v, want %v", refuse, v.counts, want)
+		}
+		if n := len(readReport[invalidValue](t, v.path)); n != 2 {
+			t.Errorf("refuse %v: %d invalid value(s) reported, want 2", refuse, n)
+		}
+		if refuse && v.refusedCount() != 1 {
+			t.Errorf("refused %d document(s), want 1", v.refusedCount())
+		}
+	}
+}